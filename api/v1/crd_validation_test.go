@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	crdvalidation "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/validation"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/schema/defaulting"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// loadCRD reads the generated CRD in file, the way the API server stores it.
+func loadCRD(t *testing.T, file string) *apiextensions.CustomResourceDefinition {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("..", "..", "config", "crd", "bases", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crd := apiextensionsv1.CustomResourceDefinition{}
+	if err := yaml.Unmarshal(data, &crd); err != nil {
+		t.Fatal(err)
+	}
+	apiextensionsv1.SetObjectDefaults_CustomResourceDefinition(&crd)
+	internal := &apiextensions.CustomResourceDefinition{}
+	if err := apiextensionsv1.Convert_v1_CustomResourceDefinition_To_apiextensions_CustomResourceDefinition(&crd, internal, nil); err != nil {
+		t.Fatal(err)
+	}
+	return internal
+}
+
+// crdSchema returns the schema of the single version of the CRD.
+func crdSchema(t *testing.T, crd *apiextensions.CustomResourceDefinition) *apiextensions.CustomResourceValidation {
+	t.Helper()
+	if crd.Spec.Validation != nil {
+		return crd.Spec.Validation
+	}
+	if len(crd.Spec.Versions) != 1 || crd.Spec.Versions[0].Schema == nil {
+		t.Fatalf("versions = %v, want a single one with a schema", crd.Spec.Versions)
+	}
+	return crd.Spec.Versions[0].Schema
+}
+
+// toUnstructured returns obj the way users apply it, without a status.
+func toUnstructured(t *testing.T, obj runtime.Object) map[string]interface{} {
+	t.Helper()
+	unstructured, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(unstructured, "status")
+	return unstructured
+}
+
+// validateAgainstCRD validates obj against the schema of the generated CRD in
+// file, the way the API server does before any webhook sees it.
+func validateAgainstCRD(t *testing.T, file string, obj runtime.Object) error {
+	t.Helper()
+	validator, _, err := validation.NewSchemaValidator(crdSchema(t, loadCRD(t, file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return validation.ValidateCustomResource(nil, toUnstructured(t, obj), validator).ToAggregate()
+}
+
+// defaultByCRD applies the defaults of the schema of the generated CRD in
+// file to obj, the way the API server does before any webhook sees it.
+func defaultByCRD(t *testing.T, file string, obj runtime.Object) map[string]interface{} {
+	t.Helper()
+	structural, err := structuralschema.NewStructural(crdSchema(t, loadCRD(t, file)).OpenAPIV3Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unstructured := toUnstructured(t, obj)
+	defaulting.Default(unstructured, structural)
+	return unstructured
+}
+
+func TestCRDsAreValid(t *testing.T) {
+	// the API server only applies defaults of valid structural schemas
+	for _, file := range []string{"experiments.mad.md_drones.yaml", "experiments.mad.md_swarms.yaml"} {
+		if errs := crdvalidation.ValidateCustomResourceDefinition(loadCRD(t, file), apiextensionsv1.SchemeGroupVersion); len(errs) > 0 {
+			t.Errorf("%s: %v", file, errs.ToAggregate())
+		}
+	}
+}
+
+func TestCRDSchemaDeniesNegatives(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		obj     runtime.Object
+		wantErr bool
+	}{
+		{name: "swarm of none", file: "experiments.mad.md_swarms.yaml", obj: &Swarm{Spec: SwarmSpec{HowMany: 0}}},
+		{name: "swarm of some", file: "experiments.mad.md_swarms.yaml", obj: &Swarm{Spec: SwarmSpec{HowMany: 3}}},
+		{name: "negative swarm", file: "experiments.mad.md_swarms.yaml", obj: &Swarm{Spec: SwarmSpec{HowMany: -1}}, wantErr: true},
+		{name: "negative minAvailable", file: "experiments.mad.md_swarms.yaml", obj: &Swarm{Spec: SwarmSpec{HowMany: 1, MinAvailable: -1}}, wantErr: true},
+		{name: "drone", file: "experiments.mad.md_drones.yaml", obj: &Drone{Spec: DroneSpec{MaxPerNode: 2}}},
+		{name: "negative drone maxPerNode", file: "experiments.mad.md_drones.yaml", obj: &Drone{Spec: DroneSpec{MaxPerNode: -1}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateAgainstCRD(t, tt.file, tt.obj); (err != nil) != tt.wantErr {
+				t.Errorf("validation error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCRDSchemaDefaults(t *testing.T) {
+	tests := []struct {
+		name          string
+		file          string
+		obj           runtime.Object
+		path          []string
+		image         string
+		restartPolicy string
+	}{
+		{name: "empty drone", file: "experiments.mad.md_drones.yaml", obj: &Drone{},
+			path: []string{"spec"}, image: "danacr/drone-pod:latest", restartPolicy: "Always"},
+		{name: "explicit drone", file: "experiments.mad.md_drones.yaml",
+			obj:  &Drone{Spec: DroneSpec{Image: "danacr/drone-pod:v2", RestartPolicy: "Never"}},
+			path: []string{"spec"}, image: "danacr/drone-pod:v2", restartPolicy: "Never"},
+		{name: "swarm template", file: "experiments.mad.md_swarms.yaml", obj: &Swarm{Spec: SwarmSpec{HowMany: 1}},
+			path: []string{"spec", "template"}, image: "danacr/drone-pod:latest", restartPolicy: "Always"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := defaultByCRD(t, tt.file, tt.obj)
+			for _, field := range tt.path {
+				next, ok := spec[field].(map[string]interface{})
+				if !ok {
+					t.Fatalf("%s = %v, want an object", field, spec[field])
+				}
+				spec = next
+			}
+			if spec["image"] != tt.image || spec["restartPolicy"] != tt.restartPolicy {
+				t.Errorf("image/restartPolicy = %v/%v, want %s/%s", spec["image"], spec["restartPolicy"], tt.image, tt.restartPolicy)
+			}
+		})
+	}
+}
@@ -16,22 +16,329 @@ limitations under the License.
 package v1
 
 import (
+	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// DroneNameLabel is set on every drone pod to the name of its drone.
+const DroneNameLabel = "experiments.mad.md/drone"
+
+// RescheduleAnnotation moves a drone to another node whenever its value
+// changes, e.g. when set to an increasing counter.
+const RescheduleAnnotation = "experiments.mad.md/reschedule"
+
+// UnmanagedAnnotation, set to "true" on a drone of a swarm, makes the swarm
+// leave it alone for manual intervention: it neither counts the drone nor
+// deletes it on scale-down.
+const UnmanagedAnnotation = "experiments.mad.md/unmanaged"
+
+// DefaultImage is the image the CRD schema defaults drones to. The
+// controller runs its --default-drone-image instead for drones left at it.
+const DefaultImage = "danacr/drone-pod:latest"
+
 // DroneSpec defines the desired state of Drone
 type DroneSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	// Foo is an example field of Drone. Edit Drone_types.go to remove/update
+
+	// Image is the container image the drone runs. Defaults to
+	// danacr/drone-pod:latest, which the controller replaces with its
+	// --default-drone-image if set.
+	// +kubebuilder:default="danacr/drone-pod:latest"
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// RestartPolicy of the drone pod. Defaults to Always.
+	// +kubebuilder:validation:Enum=Always;OnFailure;Never
+	// +kubebuilder:default=Always
+	// +optional
+	RestartPolicy core.RestartPolicy `json:"restartPolicy,omitempty"`
+
+	// StartupProbe is set on the drone container for drones that take a while
+	// to initialize. The drone is not considered flying until it has passed.
+	// +optional
+	StartupProbe *core.Probe `json:"startupProbe,omitempty"`
+
+	// NodeSelector selects the nodes the drone may fly on. Defaults to nodes
+	// with the drone role.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Resources are the compute resources of the drone container.
+	// +optional
+	Resources core.ResourceRequirements `json:"resources,omitempty"`
+
+	// ActiveDeadlineSeconds is how long the drone may fly before its pod is
+	// terminated. Such drones fail with the DeadlineExceeded reason.
+	// +optional
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+
+	// MaxPerNode is how many drones may share a node. Defaults to 1.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxPerNode int32 `json:"maxPerNode,omitempty"`
+
+	// Affinity is the scheduling affinity of the drone pod.
+	// +optional
+	Affinity *core.Affinity `json:"affinity,omitempty"`
+
+	// ReadinessGates are extra pod conditions, e.g. set by an external
+	// service the drone registers with, that must be true before the drone
+	// counts as flying.
+	// +optional
+	ReadinessGates []core.PodReadinessGate `json:"readinessGates,omitempty"`
+
+	// SchedulerName is the scheduler the drone pod is dispatched by. The
+	// controller still picks the node unless it runs with
+	// --defer-to-scheduler.
+	// +optional
+	SchedulerName string `json:"schedulerName,omitempty"`
+
+	// RequiredNodeLabels are labels a drone node must carry on top of the
+	// node selector for the drone to fly on it, e.g. gpu=true.
+	// +optional
+	RequiredNodeLabels map[string]string `json:"requiredNodeLabels,omitempty"`
+
+	// PreferredNode is the node the drone flies on if it is free and fits,
+	// e.g. one with its data cached. Otherwise any free drone node is picked
+	// as usual. Ignored for drones left to their scheduler.
+	// +optional
+	PreferredNode string `json:"preferredNode,omitempty"`
+
+	// MeshInjection stamps the service mesh injection annotations configured
+	// on the controller (--mesh-annotations) on the drone pod.
+	// +optional
+	MeshInjection bool `json:"meshInjection,omitempty"`
+
+	// TTLSecondsAfterFinished is how long a drone whose pod succeeded or
+	// failed is kept around before it is deleted, like for Jobs. Unset keeps
+	// finished drones forever.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
+
+	// SpreadBy is a node label, e.g. topology.kubernetes.io/zone, across
+	// whose values the drones of a swarm are balanced before packing any
+	// single one. Set from the swarm.
+	// +optional
+	SpreadBy string `json:"spreadBy,omitempty"`
+
+	// MaxSkew adds a topology spread constraint over SpreadBy to the pods of
+	// drones of a swarm, for the scheduler to keep the number of drones per
+	// SpreadBy value within this much of each other. Zero adds none. Set from
+	// the swarm.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxSkew int32 `json:"maxSkew,omitempty"`
+
+	// PodLabels are extra labels put on the drone pod, e.g. for
+	// NetworkPolicies. They can't override the labels set by the controller.
+	// +optional
+	PodLabels map[string]string `json:"podLabels,omitempty"`
+
+	// RequireColocatedWith selects pods, e.g. a local cache, in the drone's
+	// namespace the drone must share a node with. Only nodes running such a
+	// pod are considered.
+	// +optional
+	RequireColocatedWith *metav1.LabelSelector `json:"requireColocatedWith,omitempty"`
+
+	// HostAliases are added to the hosts file of the drone pod, for names the
+	// drone must resolve without DNS.
+	// +optional
+	HostAliases []core.HostAlias `json:"hostAliases,omitempty"`
+
+	// WorkingDir of the drone container. Defaults to the image's.
+	// +optional
+	WorkingDir string `json:"workingDir,omitempty"`
+
+	// FallbackImage replaces the image once the drone pod failed to pull it
+	// for a while (--image-fallback-after). The drone sticks with the
+	// fallback from then on.
+	// +optional
+	FallbackImage string `json:"fallbackImage,omitempty"`
+
+	// DirectAssign binds the drone pod to the node picked for it right away,
+	// leaving the scheduler out. Beware that nothing then checks the pod
+	// against the node, e.g. its taints, affinity or free resources beyond
+	// what the controller itself considers.
+	// +optional
+	DirectAssign bool `json:"directAssign,omitempty"`
+
+	// PackByResources packs drones onto a node for as long as the requests
+	// of another one fit it, rather than up to a count. MaxPerNode then only
+	// caps the count if set. Requires resource requests.
+	// +optional
+	PackByResources bool `json:"packByResources,omitempty"`
+
+	// ProjectedToken mounts a service account token for an audience of its
+	// own into the drone container, e.g. for workload identity.
+	// +optional
+	ProjectedToken *ProjectedToken `json:"projectedToken,omitempty"`
+
+	// NodePoolSelectors narrow the drone nodes down to those matching any of
+	// these label sets, for fleets spanning node pools that don't share a
+	// label of their own.
+	// +optional
+	NodePoolSelectors []map[string]string `json:"nodePoolSelectors,omitempty"`
+
+	// Scrape has Prometheus scrape the drone: the drone pod gets the
+	// prometheus.io annotations and a metrics container port.
+	// +optional
+	Scrape *ScrapeConfig `json:"scrape,omitempty"`
+
+	// Subdomain, the name of a headless Service selecting the drone pods,
+	// gives the drone the stable DNS name
+	// <drone>.<subdomain>.<namespace>.svc.<cluster domain>.
+	// +optional
+	Subdomain string `json:"subdomain,omitempty"`
+
+	// TerminationMessagePath is the file in the drone container its
+	// termination message is read from. Defaults to /dev/termination-log.
+	// +optional
+	TerminationMessagePath string `json:"terminationMessagePath,omitempty"`
+
+	// TerminationMessagePolicy decides whether the end of the container log
+	// stands in for an empty termination message. Defaults to File.
+	// +kubebuilder:validation:Enum=File;FallbackToLogsOnError
+	// +optional
+	TerminationMessagePolicy core.TerminationMessagePolicy `json:"terminationMessagePolicy,omitempty"`
+
+	// EnableServiceLinks puts the addresses of the services in the namespace
+	// into the environment of the drone container. Setting it to false speeds
+	// up drone startup in namespaces with many services. Defaults to true.
+	// +optional
+	EnableServiceLinks *bool `json:"enableServiceLinks,omitempty"`
+
+	// PodSpecPatch is a strategic merge patch applied to the drone pod spec
+	// after everything else, as an escape hatch for pod fields the Drone API
+	// doesn't expose. The controller doesn't check what it changes.
+	// +optional
+	PodSpecPatch *runtime.RawExtension `json:"podSpecPatch,omitempty"`
+}
+
+// ScrapeConfig tells Prometheus where a drone serves its metrics.
+type ScrapeConfig struct {
+	// Port the drone container serves its metrics on.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+
+	// Path the metrics are served at. Defaults to /metrics.
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// ProjectedToken configures the projected service account token of a drone.
+type ProjectedToken struct {
+	// Audience is the intended audience of the token.
+	// +kubebuilder:validation:MinLength=1
+	Audience string `json:"audience"`
+
+	// ExpirationSeconds is how long the token is valid for, at least 600. The
+	// kubelet rotates it before it expires. Defaults to an hour.
+	// +kubebuilder:validation:Minimum=600
+	// +optional
+	ExpirationSeconds *int64 `json:"expirationSeconds,omitempty"`
+
+	// MountPath is the directory the token is mounted to, as a file named
+	// token. Defaults to /var/run/secrets/tokens.
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+// DronePhase is the lifecycle phase of a drone, following the phase of its pod.
+type DronePhase string
+
+const (
+	// DronePending means the drone waits for a node or for its pod to start.
+	DronePending DronePhase = "Pending"
+	// DroneRunning means the drone pod is running.
+	DroneRunning DronePhase = "Running"
+	// DroneSucceeded means the drone pod terminated successfully.
+	DroneSucceeded DronePhase = "Succeeded"
+	// DroneFailed means the drone pod terminated with a failure.
+	DroneFailed DronePhase = "Failed"
+	// DroneUnknown means the state of the drone pod could not be obtained.
+	DroneUnknown DronePhase = "Unknown"
+)
+
+// DroneConditionType is the type of a drone condition.
+type DroneConditionType string
+
+const (
+	// DroneImagePulled is true once the drone image got pulled, and false
+	// with the kubelet's reason while it can't be.
+	DroneImagePulled DroneConditionType = "ImagePulled"
+)
+
+// DroneCondition describes the state of a drone at a certain point.
+type DroneCondition struct {
+	// Type of the condition.
+	Type DroneConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown.
+	Status core.ConditionStatus `json:"status"`
+	// LastTransitionTime is when the condition last changed its status.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a brief CamelCase reason for the last transition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable explanation of the last transition.
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 // DroneStatus defines the observed state of Drone
 type DroneStatus struct {
 	Flying bool `json:"flying,omitempty"`
+
+	// Phase is the lifecycle phase of the drone.
+	// +optional
+	Phase DronePhase `json:"phase,omitempty"`
+
+	// Reason is a brief CamelCase message on why the drone is in its phase,
+	// e.g. DeadlineExceeded.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// LastTransitionTime is when the drone last took off or landed.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// PendingSince is when the drone started waiting for a free drone node,
+	// unset once it got one.
+	// +optional
+	PendingSince *metav1.Time `json:"pendingSince,omitempty"`
+
+	// MovingFrom is the node the controller took the drone's pod off to move
+	// it elsewhere, e.g. for a reschedule. The next pod avoids that node if
+	// any other is free. Unset once the drone has a pod again.
+	// +optional
+	MovingFrom string `json:"movingFrom,omitempty"`
+
+	// UsingFallbackImage is true once the drone switched to its fallback
+	// image.
+	// +optional
+	UsingFallbackImage bool `json:"usingFallbackImage,omitempty"`
+
+	// StartTime is when the kubelet first acknowledged the drone pod. Later
+	// pods of the drone don't change it.
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// ReadyTime is when the drone first took off. Together with StartTime and
+	// the creation time it tells how long the drone took to start up.
+	// +optional
+	ReadyTime *metav1.Time `json:"readyTime,omitempty"`
+
+	// Conditions are the latest observations of the drone's state.
+	// +optional
+	Conditions []DroneCondition `json:"conditions,omitempty"`
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 }
@@ -0,0 +1,186 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var dronelog = logf.Log.WithName("drone-resource")
+
+// SetupWebhookWithManager registers the Drone webhooks with the manager.
+func (r *Drone) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-experiments-mad-md-v1-drone,mutating=false,failurePolicy=fail,groups=experiments.mad.md,resources=drones,versions=v1,name=vdrone.kb.io
+
+var _ webhook.Validator = &Drone{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Drone) ValidateCreate() error {
+	dronelog.Info("validate create", "name", r.Name)
+	return r.validateDrone(nil)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Drone) ValidateUpdate(old runtime.Object) error {
+	dronelog.Info("validate update", "name", r.Name)
+	// the controller's status writes and finalizer removal come through here
+	// too, a drone on its way out must not get stuck on them
+	if r.DeletionTimestamp != nil {
+		return nil
+	}
+	oldDrone, ok := old.(*Drone)
+	if !ok {
+		return r.validateDrone(nil)
+	}
+	return r.validateDrone(oldDrone)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Drone) ValidateDelete() error {
+	return nil
+}
+
+// validateDrone validates the drone, created if old is nil and updated from
+// old otherwise. Updates are only denied for errors old didn't have, so
+// drones from before a check was added may go on as long as they don't add
+// to them.
+func (r *Drone) validateDrone(old *Drone) error {
+	allErrs := r.droneErrors()
+	if old != nil {
+		allErrs = newErrors(allErrs, old.droneErrors())
+	}
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Drone").GroupKind(), r.Name, allErrs)
+}
+
+func (r *Drone) droneErrors() field.ErrorList {
+	allErrs := validateDroneSpec(&r.Spec, field.NewPath("spec"))
+	allErrs = append(allErrs, ApplyPodSpecHints(r.Annotations, &core.PodSpec{})...)
+	if r.Spec.Subdomain != "" {
+		// the drone name becomes the pod's hostname
+		for _, msg := range validation.IsDNS1123Label(r.Name) {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("metadata").Child("name"), r.Name, "with a subdomain: "+msg))
+		}
+	}
+	return allErrs
+}
+
+// newErrors returns the errors of errs that aren't in old.
+func newErrors(errs, old field.ErrorList) field.ErrorList {
+	known := make(map[string]bool, len(old))
+	for _, err := range old {
+		known[err.Error()] = true
+	}
+	var added field.ErrorList
+	for _, err := range errs {
+		if !known[err.Error()] {
+			added = append(added, err)
+		}
+	}
+	return added
+}
+
+// validateDroneSpec validates a DroneSpec, be it on a Drone or in the
+// template of a Swarm.
+func validateDroneSpec(spec *DroneSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := validateImage(spec.Image, fldPath.Child("image"))
+	if spec.DirectAssign && spec.SchedulerName != "" {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("directAssign"), spec.DirectAssign,
+			"directAssign and schedulerName are mutually exclusive, directly assigned pods skip the scheduler"))
+	}
+	if spec.Subdomain != "" {
+		for _, msg := range validation.IsDNS1123Label(spec.Subdomain) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("subdomain"), spec.Subdomain, msg))
+		}
+	}
+	if spec.PodSpecPatch != nil {
+		if err := PatchPodSpec(&core.PodSpec{}, spec.PodSpecPatch.Raw); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("podSpecPatch"), string(spec.PodSpecPatch.Raw),
+				"must be a strategic merge patch of a pod spec: "+err.Error()))
+		}
+	}
+	if spec.PackByResources && len(spec.Resources.Requests) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath.Child("resources").Child("requests"),
+			"packByResources needs requests to pack by"))
+	}
+	return append(allErrs, validateResources(&spec.Resources, fldPath.Child("resources"))...)
+}
+
+// imageReferenceRegexp matches image references as understood by docker:
+// [domain[:port]/]path[:tag][@digest], with a lowercase path.
+var imageReferenceRegexp = regexp.MustCompile(`^` +
+	`(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*(?::[0-9]+)?/)?` +
+	`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+	`(?::[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127})?` +
+	`(?:@[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,})?$`)
+
+// validateImage makes sure the image, if set, is a well-formed reference so a
+// typo doesn't leave every drone stuck pulling it.
+func validateImage(image string, fldPath *field.Path) field.ErrorList {
+	if image == "" || imageReferenceRegexp.MatchString(image) {
+		return nil
+	}
+	return field.ErrorList{field.Invalid(fldPath, image, "must be a valid image reference")}
+}
+
+// validateResources makes sure no request exceeds its limit and extended
+// resources (e.g. nvidia.com/gpu) request exactly their limit, which
+// Kubernetes would only reject once the drone pod gets created.
+func validateResources(resources *core.ResourceRequirements, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for name, limit := range resources.Limits {
+		request, ok := resources.Requests[name]
+		if ok && request.Cmp(limit) > 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("requests").Key(string(name)), request.String(),
+				fmt.Sprintf("must be less than or equal to %s limit of %s", name, limit.String())))
+		} else if ok && isExtendedResource(name) && request.Cmp(limit) != 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("requests").Key(string(name)), request.String(),
+				fmt.Sprintf("must be equal to %s limit of %s", name, limit.String())))
+		}
+	}
+	for name, request := range resources.Requests {
+		if _, ok := resources.Limits[name]; !ok && isExtendedResource(name) {
+			allErrs = append(allErrs, field.Required(fldPath.Child("limits").Key(string(name)),
+				fmt.Sprintf("extended resources can't be overcommitted, set a limit of %s", request.String())))
+		}
+	}
+	return allErrs
+}
+
+// isExtendedResource reports whether name is a resource advertised by a
+// device plugin or the like rather than one native to Kubernetes.
+func isExtendedResource(name core.ResourceName) bool {
+	return strings.Contains(string(name), "/") && !strings.Contains(string(name), "kubernetes.io/")
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestValidateResources(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests core.ResourceList
+		limits   core.ResourceList
+		invalid  []string
+	}{
+		{name: "none"},
+		{name: "requests only", requests: resources("cpu", "500m")},
+		{name: "limits only", limits: resources("cpu", "1")},
+		{name: "request below limit", requests: resources("cpu", "500m", "memory", "64Mi"), limits: resources("cpu", "1", "memory", "128Mi")},
+		{name: "request equal to limit", requests: resources("memory", "128Mi"), limits: resources("memory", "128Mi")},
+		{
+			name:     "request above limit",
+			requests: resources("cpu", "2", "memory", "64Mi"),
+			limits:   resources("cpu", "1", "memory", "128Mi"),
+			invalid:  []string{"spec.resources.requests[cpu]"},
+		},
+		{name: "extended resource equal to limit", requests: resources("nvidia.com/gpu", "1"), limits: resources("nvidia.com/gpu", "1")},
+		{
+			name:     "extended resource below limit",
+			requests: resources("nvidia.com/gpu", "1"),
+			limits:   resources("nvidia.com/gpu", "2"),
+			invalid:  []string{"spec.resources.requests[nvidia.com/gpu]"},
+		},
+		{
+			name:     "extended resource without limit",
+			requests: resources("nvidia.com/gpu", "1"),
+			invalid:  []string{"spec.resources.limits[nvidia.com/gpu]"},
+		},
+		{name: "native prefixed resource without limit", requests: resources("kubernetes.io/something", "1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateResources(&core.ResourceRequirements{Requests: tt.requests, Limits: tt.limits}, field.NewPath("spec", "resources"))
+			if got := errorFields(errs); !equalStrings(got, tt.invalid) {
+				t.Errorf("invalid fields = %v, want %v", got, tt.invalid)
+			}
+		})
+	}
+}
+
+func TestValidateDroneResources(t *testing.T) {
+	drone := &Drone{ObjectMeta: metav1.ObjectMeta{Name: "heavy"}}
+	drone.Spec.Resources = core.ResourceRequirements{Requests: resources("cpu", "500m"), Limits: resources("cpu", "1")}
+	if err := drone.ValidateCreate(); err != nil {
+		t.Errorf("valid drone denied: %v", err)
+	}
+
+	valid := drone.DeepCopy()
+	drone.Spec.Resources.Requests = resources("cpu", "2")
+	err := drone.ValidateCreate()
+	if !apierrors.IsInvalid(err) {
+		t.Fatalf("drone with requests above limits = %v, want invalid", err)
+	}
+	if err := drone.ValidateUpdate(valid); !apierrors.IsInvalid(err) {
+		t.Errorf("update to requests above limits = %v, want invalid", err)
+	}
+}
+
+func TestValidateSwarmTemplateResources(t *testing.T) {
+	swarm := &Swarm{ObjectMeta: metav1.ObjectMeta{Name: "heavy"}, Spec: SwarmSpec{HowMany: 1}}
+	swarm.Spec.Template.Resources = core.ResourceRequirements{Requests: resources("memory", "64Mi"), Limits: resources("memory", "128Mi")}
+	if err := swarm.ValidateCreate(); err != nil {
+		t.Errorf("valid swarm denied: %v", err)
+	}
+
+	swarm.Spec.Template.Resources.Requests = resources("memory", "256Mi")
+	err := swarm.ValidateCreate()
+	statusErr, ok := err.(*apierrors.StatusError)
+	if !ok || !apierrors.IsInvalid(err) {
+		t.Fatalf("swarm with requests above limits = %v, want invalid", err)
+	}
+	if causes := statusErr.ErrStatus.Details.Causes; len(causes) != 1 || causes[0].Field != "spec.template.resources.requests[memory]" {
+		t.Errorf("causes = %v, want the template's memory request", causes)
+	}
+}
+
+// resources returns a resource list from name, quantity pairs.
+func resources(pairs ...string) core.ResourceList {
+	list := core.ResourceList{}
+	for i := 0; i < len(pairs); i += 2 {
+		list[core.ResourceName(pairs[i])] = resource.MustParse(pairs[i+1])
+	}
+	return list
+}
+
+// errorFields returns the fields of errs, in order.
+func errorFields(errs field.ErrorList) []string {
+	var fields []string
+	for _, err := range errs {
+		fields = append(fields, err.Field)
+	}
+	return fields
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestValidateImage(t *testing.T) {
+	tests := []struct {
+		image string
+		valid bool
+	}{
+		{image: "", valid: true},
+		{image: "busybox", valid: true},
+		{image: "danacr/drone-pod", valid: true},
+		{image: "danacr/drone-pod:latest", valid: true},
+		{image: "registry.local:5000/team/drone-pod:v1.2", valid: true},
+		{image: "danacr/drone-pod@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", valid: true},
+		{image: "danacr/drone-pod:v1@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", valid: true},
+		{image: "Danacr/Drone-Pod"},
+		{image: "danacr/drone-pod:"},
+		{image: "danacr//drone-pod"},
+		{image: "danacr/drone pod"},
+		{image: "danacr/drone-pod@sha256:short"},
+		{image: ":latest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			errs := validateImage(tt.image, field.NewPath("spec", "image"))
+			if valid := len(errs) == 0; valid != tt.valid {
+				t.Errorf("valid = %v, want %v: %v", valid, tt.valid, errs)
+			}
+		})
+	}
+}
+
+func TestValidateSwarmTemplateImage(t *testing.T) {
+	swarm := &Swarm{ObjectMeta: metav1.ObjectMeta{Name: "fleet"}, Spec: SwarmSpec{HowMany: 1}}
+	swarm.Spec.Template.Image = "danacr/drone-pod:v1"
+	if err := swarm.ValidateCreate(); err != nil {
+		t.Errorf("swarm with a valid image denied: %v", err)
+	}
+
+	swarm.Spec.Template.Image = "danacr/drone-pod:"
+	err := swarm.ValidateCreate()
+	statusErr, ok := err.(*apierrors.StatusError)
+	if !ok || !apierrors.IsInvalid(err) {
+		t.Fatalf("swarm with a malformed image = %v, want invalid", err)
+	}
+	if causes := statusErr.ErrStatus.Details.Causes; len(causes) != 1 || causes[0].Field != "spec.template.image" {
+		t.Errorf("causes = %v, want the template's image", causes)
+	}
+}
+
+func TestValidateDroneUpdateKeepsExistingErrors(t *testing.T) {
+	old := &Drone{ObjectMeta: metav1.ObjectMeta{Name: "legacy", Finalizers: []string{"experiments.mad.md/metrics"}}}
+	old.Spec.Resources = core.ResourceRequirements{Requests: resources("cpu", "2"), Limits: resources("cpu", "1")}
+	if err := old.ValidateCreate(); !apierrors.IsInvalid(err) {
+		t.Fatalf("drone with requests above limits = %v, want invalid", err)
+	}
+
+	relabelled := old.DeepCopy()
+	relabelled.Labels = map[string]string{"team": "drones"}
+	if err := relabelled.ValidateUpdate(old); err != nil {
+		t.Errorf("update leaving the existing error alone denied: %v", err)
+	}
+
+	worse := old.DeepCopy()
+	worse.Spec.Image = "Not An Image"
+	err := worse.ValidateUpdate(old)
+	statusErr, ok := err.(*apierrors.StatusError)
+	if !ok || !apierrors.IsInvalid(err) {
+		t.Fatalf("update adding an error = %v, want invalid", err)
+	}
+	if causes := statusErr.ErrStatus.Details.Causes; len(causes) != 1 || causes[0].Field != "spec.image" {
+		t.Errorf("causes = %v, want only the new one on spec.image", causes)
+	}
+}
+
+func TestValidateDroneUpdateWhileDeleting(t *testing.T) {
+	old := &Drone{ObjectMeta: metav1.ObjectMeta{Name: "legacy", Finalizers: []string{"experiments.mad.md/metrics"}}}
+	old.Spec.Image = "Not An Image"
+	now := metav1.Now()
+	old.DeletionTimestamp = &now
+
+	// the finalizer removal that lets the drone go
+	finalized := old.DeepCopy()
+	finalized.Finalizers = nil
+	if err := finalized.ValidateUpdate(old); err != nil {
+		t.Errorf("finalizer removal of a drone being deleted denied: %v", err)
+	}
+}
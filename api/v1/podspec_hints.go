@@ -0,0 +1,82 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// PodSpecAnnotationPrefix prefixes Drone annotations setting a field of the
+// drone pod spec, e.g. experiments.mad.md/podspec.priorityClassName, for the
+// odd placement tweak not worth an API field. Only the fields in podSpecHints
+// are supported.
+const PodSpecAnnotationPrefix = "experiments.mad.md/podspec."
+
+// podSpecHints set the pod spec field named by their key from an annotation
+// value. Fields widening what the pod may do, like hostNetwork or
+// serviceAccountName, are left out on purpose.
+var podSpecHints = map[string]func(spec *core.PodSpec, value string) error{
+	"priorityClassName": func(spec *core.PodSpec, value string) error {
+		spec.PriorityClassName = value
+		return nil
+	},
+	"terminationGracePeriodSeconds": func(spec *core.PodSpec, value string) error {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		spec.TerminationGracePeriodSeconds = &seconds
+		return nil
+	},
+}
+
+// ApplyPodSpecHints sets the pod spec fields named by the PodSpecAnnotationPrefix
+// annotations. Unsupported keys and unparsable values are skipped and
+// returned as errors.
+func ApplyPodSpecHints(annotations map[string]string, spec *core.PodSpec) field.ErrorList {
+	var allErrs field.ErrorList
+	fldPath := field.NewPath("metadata").Child("annotations")
+	for k, v := range annotations {
+		if !strings.HasPrefix(k, PodSpecAnnotationPrefix) {
+			continue
+		}
+		apply, ok := podSpecHints[strings.TrimPrefix(k, PodSpecAnnotationPrefix)]
+		if !ok {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Key(k), k, supportedPodSpecHints()))
+			continue
+		}
+		if err := apply(spec, v); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(k), v, err.Error()))
+		}
+	}
+	return allErrs
+}
+
+// supportedPodSpecHints returns the annotations ApplyPodSpecHints knows.
+func supportedPodSpecHints() []string {
+	var keys []string
+	for k := range podSpecHints {
+		keys = append(keys, PodSpecAnnotationPrefix+k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestApplyPodSpecHints(t *testing.T) {
+	thirty := int64(30)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        core.PodSpec
+		wantErrs    []field.ErrorType
+	}{
+		{name: "no hints", annotations: map[string]string{"team": "blue"}},
+		{name: "priority class", annotations: map[string]string{PodSpecAnnotationPrefix + "priorityClassName": "drones-high"},
+			want: core.PodSpec{PriorityClassName: "drones-high"}},
+		{name: "a couple of hints", annotations: map[string]string{
+			PodSpecAnnotationPrefix + "priorityClassName":             "drones-high",
+			PodSpecAnnotationPrefix + "terminationGracePeriodSeconds": "30",
+		}, want: core.PodSpec{PriorityClassName: "drones-high", TerminationGracePeriodSeconds: &thirty}},
+		{name: "unparsable grace period", annotations: map[string]string{PodSpecAnnotationPrefix + "terminationGracePeriodSeconds": "soon"},
+			wantErrs: []field.ErrorType{field.ErrorTypeInvalid}},
+		{name: "host network", annotations: map[string]string{PodSpecAnnotationPrefix + "hostNetwork": "true"},
+			wantErrs: []field.ErrorType{field.ErrorTypeNotSupported}},
+		{name: "service account", annotations: map[string]string{PodSpecAnnotationPrefix + "serviceAccountName": "admin"},
+			wantErrs: []field.ErrorType{field.ErrorTypeNotSupported}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := core.PodSpec{}
+			errs := ApplyPodSpecHints(tt.annotations, &spec)
+			var types []field.ErrorType
+			for _, err := range errs {
+				types = append(types, err.Type)
+			}
+			if len(types) != len(tt.wantErrs) || (len(types) > 0 && types[0] != tt.wantErrs[0]) {
+				t.Errorf("errors = %v, want types %v", errs, tt.wantErrs)
+			}
+			if spec.HostNetwork || spec.ServiceAccountName != "" {
+				t.Errorf("spec = %+v, want nothing widening what the pod may do", spec)
+			}
+			if spec.PriorityClassName != tt.want.PriorityClassName {
+				t.Errorf("priority class = %q, want %q", spec.PriorityClassName, tt.want.PriorityClassName)
+			}
+			if got, want := spec.TerminationGracePeriodSeconds, tt.want.TerminationGracePeriodSeconds; (got == nil) != (want == nil) || (got != nil && *got != *want) {
+				t.Errorf("termination grace period = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestValidateDronePodSpecHints(t *testing.T) {
+	drone := &Drone{ObjectMeta: metav1.ObjectMeta{Name: "hinted", Annotations: map[string]string{
+		PodSpecAnnotationPrefix + "priorityClassName": "drones-high",
+	}}}
+	if err := drone.ValidateCreate(); err != nil {
+		t.Errorf("drone with a supported hint denied: %v", err)
+	}
+
+	drone.Annotations[PodSpecAnnotationPrefix+"hostNetwork"] = "true"
+	if err := drone.ValidateCreate(); !apierrors.IsInvalid(err) {
+		t.Errorf("drone asking for the host network = %v, want invalid", err)
+	}
+}
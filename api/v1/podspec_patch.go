@@ -0,0 +1,43 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// PatchPodSpec applies patch to spec as a strategic merge patch, the way
+// kubectl patch does, leaving spec untouched on error.
+func PatchPodSpec(spec *core.PodSpec, patch []byte) error {
+	original, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	patched, err := strategicpatch.StrategicMergePatch(original, patch, core.PodSpec{})
+	if err != nil {
+		return err
+	}
+	result := core.PodSpec{}
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return err
+	}
+	*spec = result
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestPatchPodSpec(t *testing.T) {
+	share := true
+	base := func() core.PodSpec {
+		return core.PodSpec{
+			NodeName:   "node-1",
+			Containers: []core.Container{{Name: "drone", Image: "danacr/drone-pod:latest"}},
+		}
+	}
+	tests := []struct {
+		name    string
+		patch   string
+		want    func(*core.PodSpec)
+		wantErr bool
+	}{
+		{name: "empty patch", patch: `{}`, want: func(*core.PodSpec) {}},
+		{name: "unsupported field", patch: `{"shareProcessNamespace":true}`,
+			want: func(s *core.PodSpec) { s.ShareProcessNamespace = &share }},
+		{name: "container merged by name", patch: `{"containers":[{"name":"drone","stdin":true}]}`,
+			want: func(s *core.PodSpec) { s.Containers[0].Stdin = true }},
+		{name: "container added", patch: `{"containers":[{"name":"sidecar","image":"busybox"}]}`,
+			want: func(s *core.PodSpec) {
+				s.Containers = append([]core.Container{{Name: "sidecar", Image: "busybox"}}, s.Containers...)
+			}},
+		{name: "field deleted", patch: `{"nodeName":null}`, want: func(s *core.PodSpec) { s.NodeName = "" }},
+		{name: "not json", patch: `shareProcessNamespace: true`, wantErr: true},
+		{name: "wrong type", patch: `{"containers":"drone"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, want := base(), base()
+			err := PatchPodSpec(&spec, []byte(tt.patch))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			// a bad patch leaves the spec untouched
+			if tt.want != nil {
+				tt.want(&want)
+			}
+			if !equality.Semantic.DeepEqual(spec, want) {
+				t.Errorf("spec = %+v, want %+v", spec, want)
+			}
+		})
+	}
+}
+
+func TestValidateDronePodSpecPatch(t *testing.T) {
+	drone := &Drone{ObjectMeta: metav1.ObjectMeta{Name: "patched"}}
+	drone.Spec.PodSpecPatch = &runtime.RawExtension{Raw: []byte(`{"shareProcessNamespace":true}`)}
+	if err := drone.ValidateCreate(); err != nil {
+		t.Errorf("drone with a valid patch denied: %v", err)
+	}
+
+	drone.Spec.PodSpecPatch.Raw = []byte(`{"containers":"drone"}`)
+	if err := drone.ValidateCreate(); !apierrors.IsInvalid(err) {
+		t.Errorf("drone with a broken patch = %v, want invalid", err)
+	}
+}
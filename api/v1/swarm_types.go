@@ -16,29 +16,283 @@ limitations under the License.
 package v1
 
 import (
+	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// SwarmNameLabel is set on every drone created by a swarm to the name of
+// that swarm.
+const SwarmNameLabel = "experiments.mad.md/swarm"
+
+// SwarmNamespaceLabel is set on drones a swarm created in another namespace
+// to the namespace of that swarm. Owner references can't cross namespaces, so
+// this label is all that ties such drones to their swarm.
+const SwarmNamespaceLabel = "experiments.mad.md/swarm-namespace"
+
+// RestartedAtAnnotation recreates all drones of a swarm whenever its value
+// changes, like kubectl rollout restart does for Deployments. The swarm
+// stamps it on the drones it creates to tell the outdated ones.
+const RestartedAtAnnotation = "experiments.mad.md/restartedAt"
+
+// FailurePolicy decides what a swarm does with drones that failed.
+// +kubebuilder:validation:Enum=Replace;Ignore
+type FailurePolicy string
+
+const (
+	// FailurePolicyReplace deletes failed drones and creates new ones in
+	// their place.
+	FailurePolicyReplace FailurePolicy = "Replace"
+	// FailurePolicyIgnore keeps failed drones around, still counting them
+	// towards HowMany.
+	FailurePolicyIgnore FailurePolicy = "Ignore"
+)
+
+// ScaleDownOrder decides which drones a swarm deletes first when it has too
+// many.
+// +kubebuilder:validation:Enum=Newest;Oldest;Random
+type ScaleDownOrder string
+
+const (
+	// ScaleDownNewest deletes the most recently created drones first.
+	ScaleDownNewest ScaleDownOrder = "Newest"
+	// ScaleDownOldest deletes the longest running drones first.
+	ScaleDownOldest ScaleDownOrder = "Oldest"
+	// ScaleDownRandom deletes drones in an order that looks random, but is
+	// the same on every reconcile.
+	ScaleDownRandom ScaleDownOrder = "Random"
+)
+
 // SwarmSpec defines the desired state of Swarm
 type SwarmSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// Foo is an example field of Swarm. Edit Swarm_types.go to remove/update
-	HowMany *int32 `json:"howmany,omitempty"`
+	// HowMany is the number of drones the swarm should have. The Swarm
+	// webhook defaults it to 1, without it a missing howmany means 0.
+	// FillNodes swarms must leave it out.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	HowMany int32 `json:"howmany"`
+
+	// HowManyFrom reads the number of drones from a ConfigMap key in the
+	// namespace of the Swarm instead, so existing tooling can scale the swarm.
+	// HowMany is used while an optional ConfigMap or key is missing.
+	// +optional
+	HowManyFrom *core.ConfigMapKeySelector `json:"howManyFrom,omitempty"`
+
+	// TargetNamespace is the namespace the drones are created in. Defaults to
+	// the namespace of the Swarm.
+	// +optional
+	TargetNamespace string `json:"targetNamespace,omitempty"`
+
+	// NodeSelector is passed on to the drones of this swarm, overriding the
+	// default drone role selector.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Template is the spec of the drones created by this swarm.
+	// +optional
+	Template DroneSpec `json:"template,omitempty"`
+
+	// FailurePolicy decides whether failed drones are replaced. Defaults to
+	// Ignore.
+	// +optional
+	FailurePolicy FailurePolicy `json:"failurePolicy,omitempty"`
+
+	// OnePerNode keeps every drone of the swarm on its own node. The swarm
+	// won't create more drones than there are free drone nodes. When false,
+	// drones are packed up to the template's MaxPerNode.
+	// +optional
+	OnePerNode bool `json:"onePerNode,omitempty"`
+
+	// MinAvailable is how many flying drones a scale-down keeps, even below
+	// HowMany. Flying drones at that floor are only deleted once it is
+	// lowered, or Suspend is set.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinAvailable int32 `json:"minAvailable,omitempty"`
+
+	// Ordinal gives drones stable names like a StatefulSet does, <swarm>-0,
+	// <swarm>-1 and so on, instead of random ones. Missing ordinals are
+	// created lowest first and scale-downs remove the highest first.
+	// +optional
+	Ordinal bool `json:"ordinal,omitempty"`
+
+	// PDB makes the swarm manage a PodDisruptionBudget over its drone pods,
+	// so node drains don't take down too many drones at once.
+	// +optional
+	PDB *PDBSpec `json:"pdb,omitempty"`
+
+	// SpreadBy is a node label, e.g. topology.kubernetes.io/zone, across
+	// whose values the drones are balanced before any single one is filled.
+	// Overrides the template's.
+	// +optional
+	SpreadBy string `json:"spreadBy,omitempty"`
+
+	// MaxSkew makes the drone pods carry a topology spread constraint over
+	// SpreadBy with this skew, see the template's. Overrides the template's.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxSkew int32 `json:"maxSkew,omitempty"`
+
+	// PropagatedLabels are keys of Swarm labels copied into the PodLabels of
+	// its drones, and so onto their pods.
+	// +optional
+	PropagatedLabels []string `json:"propagatedLabels,omitempty"`
+
+	// Suspend deletes all drones of the swarm while true, without regard for
+	// MinAvailable. Unsetting it brings the swarm back up to HowMany.
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// Cordoned keeps the swarm from creating drones, even below HowMany,
+	// while leaving the ones it has alone. Failed drones aren't replaced and
+	// restarts wait until it is unset. Scale-downs still go ahead.
+	// +optional
+	Cordoned bool `json:"cordoned,omitempty"`
+
+	// FillNodes keeps exactly one drone on every schedulable drone node,
+	// like a DaemonSet, ignoring HowMany. Drones are added and removed as
+	// drone nodes come and go.
+	// +optional
+	FillNodes bool `json:"fillNodes,omitempty"`
+
+	// MinReadyNodes keeps the swarm from creating drones while fewer drone
+	// nodes than this are Ready, so a degraded cluster isn't stampeded. The
+	// NodesNotReady condition tells when it holds the swarm back.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinReadyNodes int32 `json:"minReadyNodes,omitempty"`
+
+	// ScaleDownOrder decides which drones go first on scale-down. Drones that
+	// aren't flying always go before flying ones, and ordinal swarms remove
+	// the highest ordinals first regardless.
+	// +optional
+	ScaleDownOrder ScaleDownOrder `json:"scaleDownOrder,omitempty"`
+
+	// HomogeneousBy is a node label, e.g. cloud.google.com/gke-nodepool,
+	// whose value all nodes running drones of the swarm are meant to share.
+	// The HeterogeneousPlacement condition and a warning event tell when
+	// they don't. Placement isn't changed by it.
+	// +optional
+	HomogeneousBy string `json:"homogeneousBy,omitempty"`
+}
+
+// PDBSpec configures the PodDisruptionBudget of a swarm. At most one of
+// MinAvailable and MaxUnavailable may be set; with neither, one drone may be
+// disrupted at a time.
+type PDBSpec struct {
+	// MinAvailable is how many drone pods must stay up during a disruption,
+	// as a number or a percentage. A number above HowMany is capped at it.
+	// +optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+
+	// MaxUnavailable is how many drone pods may be down during a disruption,
+	// as a number or a percentage.
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// SwarmConditionType is the type of a swarm condition.
+type SwarmConditionType string
+
+const (
+	// SwarmQuotaExceeded is true while a resource quota keeps the swarm from
+	// creating drones.
+	SwarmQuotaExceeded SwarmConditionType = "QuotaExceeded"
+	// SwarmSuspended is true while the swarm is suspended.
+	SwarmSuspended SwarmConditionType = "Suspended"
+	// SwarmNodesNotReady is true while too few drone nodes are Ready for the
+	// swarm to create drones.
+	SwarmNodesNotReady SwarmConditionType = "NodesNotReady"
+	// SwarmHeterogeneousPlacement is true while the drones of a swarm with
+	// HomogeneousBy run on nodes with differing values of that label.
+	SwarmHeterogeneousPlacement SwarmConditionType = "HeterogeneousPlacement"
+	// SwarmRestarting is true while drones created before the last change of
+	// the restartedAt annotation remain.
+	SwarmRestarting SwarmConditionType = "Restarting"
+	// SwarmCordoned is true while the swarm is cordoned.
+	SwarmCordoned SwarmConditionType = "Cordoned"
+)
+
+// SwarmCondition describes the state of a swarm at a certain point.
+type SwarmCondition struct {
+	// Type of the condition.
+	Type SwarmConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown.
+	Status core.ConditionStatus `json:"status"`
+	// LastTransitionTime is when the condition last changed its status.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a brief CamelCase reason for the last transition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable explanation of the last transition.
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
+// ScaleAction is a change in the number of drones of a swarm.
+type ScaleAction string
+
+const (
+	// ScaleUp means the swarm created drones.
+	ScaleUp ScaleAction = "ScaleUp"
+	// ScaleDown means the swarm deleted drones.
+	ScaleDown ScaleAction = "ScaleDown"
+)
+
 // SwarmStatus defines the observed state of Swarm
 type SwarmStatus struct {
 	FlyingDrones int32 `json:"flyingdrones,omitempty"`
+
+	// AvailableNodes is the number of drone nodes without a drone.
+	AvailableNodes int32 `json:"availableNodes,omitempty"`
+
+	// OccupiedNodes is the number of drone nodes with a drone.
+	OccupiedNodes int32 `json:"occupiedNodes,omitempty"`
+
+	// CreatedThisPass is how many drones the last reconcile created. It falls
+	// short of the missing drones when a create failed midway, the rest are
+	// created on retry.
+	// +optional
+	CreatedThisPass int32 `json:"createdThisPass,omitempty"`
+
+	// UnschedulableDrones is the number of drones of the swarm waiting for
+	// a drone node to fly on.
+	// +optional
+	UnschedulableDrones int32 `json:"unschedulableDrones,omitempty"`
+
+	// LastScaleTime is when the swarm last created or deleted drones.
+	// +optional
+	LastScaleTime metav1.Time `json:"lastScaleTime,omitempty"`
+
+	// LastScaleAction is what the swarm last did at LastScaleTime, ScaleUp or
+	// ScaleDown.
+	// +optional
+	LastScaleAction ScaleAction `json:"lastScaleAction,omitempty"`
+
+	// OutdatedDrones is how many drones created before the last change of the
+	// restartedAt annotation are still waiting to be recreated.
+	// +optional
+	OutdatedDrones int32 `json:"outdatedDrones,omitempty"`
+
+	// Conditions are the latest observations of the swarm's state.
+	// +optional
+	Conditions []SwarmCondition `json:"conditions,omitempty"`
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Desired",type="integer",JSONPath=".spec.howmany"
+// +kubebuilder:printcolumn:name="Flying",type="integer",JSONPath=".status.flyingdrones"
+// +kubebuilder:printcolumn:name="Unschedulable",type="integer",JSONPath=".status.unschedulableDrones"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // Swarm is the Schema for the swarms API
 type Swarm struct {
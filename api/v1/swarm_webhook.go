@@ -0,0 +1,366 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+)
+
+// log is for logging in this package.
+var swarmlog = logf.Log.WithName("swarm-resource")
+
+// swarmClient and swarmRecorder let the webhook look at the drones of a swarm
+// and warn about what an update does to them.
+var (
+	swarmClient   client.Client
+	swarmRecorder record.EventRecorder
+)
+
+// RequiredSwarmLabel is a label key every Swarm must carry, e.g. team, for
+// governance. Empty requires none.
+var RequiredSwarmLabel string
+
+// LargeSwarmThreshold is the HowMany above which a Swarm needs approval:
+// LargeSwarmApprovedAnnotation set to "true" on it, or LargeSwarmNamespaceLabel
+// set to "true" on its namespace. HowManyFrom and FillNodes swarms always need
+// it. Zero lets swarms of any size through.
+var LargeSwarmThreshold int32
+
+// LargeSwarmNamespaceLabel is a label key marking namespaces whose swarms may
+// exceed LargeSwarmThreshold without approval. Empty marks none.
+var LargeSwarmNamespaceLabel string
+
+// LargeSwarmApprovedAnnotation approves a Swarm above LargeSwarmThreshold.
+const LargeSwarmApprovedAnnotation = "experiments.mad.md/large-swarm-approved"
+
+// DroneTemplateAnnotation names an entry of DroneTemplates whose settings the
+// defaulting webhook copies into the drone template of a Swarm, where unset.
+const DroneTemplateAnnotation = "experiments.mad.md/drone-template"
+
+// DroneTemplates are named drone specs, e.g. with common resources and
+// probes, that swarms can pick up through DroneTemplateAnnotation.
+var DroneTemplates map[string]DroneSpec
+
+// ParseDroneTemplates parses a YAML or JSON map of template names to drone
+// specs.
+func ParseDroneTemplates(data []byte) (map[string]DroneSpec, error) {
+	templates := map[string]DroneSpec{}
+	if err := yaml.UnmarshalStrict(data, &templates); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// SetupWebhookWithManager registers the Swarm webhooks with the manager.
+func (r *Swarm) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	swarmClient = mgr.GetClient()
+	swarmRecorder = mgr.GetEventRecorderFor("swarm-webhook")
+	// registered ahead of the builder, which then leaves the path alone
+	mgr.GetWebhookServer().Register("/mutate-experiments-mad-md-v1-swarm", &webhook.Admission{Handler: &swarmDefaulter{}})
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-experiments-mad-md-v1-swarm,mutating=true,failurePolicy=fail,groups=experiments.mad.md,resources=swarms,verbs=create;update,versions=v1,name=mswarm.kb.io
+
+var _ webhook.Defaulter = &Swarm{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *Swarm) Default() {
+	name, ok := r.Annotations[DroneTemplateAnnotation]
+	if !ok {
+		return
+	}
+	// unknown templates are rejected by the validating webhook
+	if defaults, ok := DroneTemplates[name]; ok {
+		swarmlog.Info("default", "name", r.Name, "template", name)
+		applyDroneDefaults(&r.Spec.Template, &defaults)
+	}
+}
+
+// DefaultHowMany is the number of drones of a Swarm leaving out howmany.
+const DefaultHowMany = 1
+
+// DefaultFromJSON defaults the Swarm decoded from raw. Unlike Default it can
+// tell a howmany left out, which decodes to 0 just like an explicit 0.
+func (r *Swarm) DefaultFromJSON(raw []byte) error {
+	var fields struct {
+		Spec map[string]json.RawMessage `json:"spec"`
+	}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return err
+	}
+	// fillNodes swarms ignore howmany, it stays 0 unless set
+	if _, ok := fields.Spec["howmany"]; !ok && !r.Spec.FillNodes {
+		r.Spec.HowMany = DefaultHowMany
+	}
+	r.Default()
+	return nil
+}
+
+// swarmDefaulter is the Swarm defaulting webhook. It stands in for the one
+// webhook.Defaulter gets, which only sees the decoded Swarm.
+type swarmDefaulter struct {
+	decoder *admission.Decoder
+}
+
+// InjectDecoder implements admission.DecoderInjector.
+func (d *swarmDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+// Handle implements admission.Handler.
+func (d *swarmDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	swarm := &Swarm{}
+	if err := d.decoder.Decode(req, swarm); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if err := swarm.DefaultFromJSON(req.Object.Raw); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	marshalled, err := json.Marshal(swarm)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshalled)
+}
+
+// applyDroneDefaults copies the settings of defaults that spec leaves unset
+// into it. The CRD schema defaults the image and restart policy before the
+// webhook sees them, so those count as unset while at the schema defaults.
+func applyDroneDefaults(spec, defaults *DroneSpec) {
+	defaults = defaults.DeepCopy()
+	if (spec.Image == "" || spec.Image == DefaultImage) && defaults.Image != "" {
+		spec.Image = defaults.Image
+	}
+	if (spec.RestartPolicy == "" || spec.RestartPolicy == core.RestartPolicyAlways) && defaults.RestartPolicy != "" {
+		spec.RestartPolicy = defaults.RestartPolicy
+	}
+	if spec.StartupProbe == nil {
+		spec.StartupProbe = defaults.StartupProbe
+	}
+	if spec.NodeSelector == nil {
+		spec.NodeSelector = defaults.NodeSelector
+	}
+	if spec.Resources.Requests == nil {
+		spec.Resources.Requests = defaults.Resources.Requests
+	}
+	if spec.Resources.Limits == nil {
+		spec.Resources.Limits = defaults.Resources.Limits
+	}
+	if spec.ActiveDeadlineSeconds == nil {
+		spec.ActiveDeadlineSeconds = defaults.ActiveDeadlineSeconds
+	}
+	if spec.Affinity == nil {
+		spec.Affinity = defaults.Affinity
+	}
+	if spec.SchedulerName == "" {
+		spec.SchedulerName = defaults.SchedulerName
+	}
+	if spec.PodLabels == nil {
+		spec.PodLabels = defaults.PodLabels
+	}
+	if spec.HostAliases == nil {
+		spec.HostAliases = defaults.HostAliases
+	}
+	if spec.WorkingDir == "" {
+		spec.WorkingDir = defaults.WorkingDir
+	}
+	if spec.FallbackImage == "" {
+		spec.FallbackImage = defaults.FallbackImage
+	}
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-experiments-mad-md-v1-swarm,mutating=false,failurePolicy=fail,groups=experiments.mad.md,resources=swarms,versions=v1,name=vswarm.kb.io
+
+var _ webhook.Validator = &Swarm{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Swarm) ValidateCreate() error {
+	swarmlog.Info("validate create", "name", r.Name)
+	return r.validateSwarm(nil)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Swarm) ValidateUpdate(old runtime.Object) error {
+	swarmlog.Info("validate update", "name", r.Name)
+	// without a status subresource the controller's status writes and
+	// finalizer removal come through here too, a swarm on its way out must
+	// not get stuck on them
+	if r.DeletionTimestamp != nil {
+		return nil
+	}
+	oldSwarm, ok := old.(*Swarm)
+	if !ok {
+		return r.validateSwarm(nil)
+	}
+	if r.Spec.HowMany < oldSwarm.Spec.HowMany {
+		r.warnDraining()
+	}
+	return r.validateSwarm(oldSwarm)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Swarm) ValidateDelete() error {
+	return nil
+}
+
+// validateSwarm validates the swarm, created if old is nil and updated from
+// old otherwise.
+func (r *Swarm) validateSwarm(old *Swarm) error {
+	specPath := field.NewPath("spec")
+	allErrs := validateDroneSpec(&r.Spec.Template, specPath.Child("template"))
+	// swarms from before the label was required may go on without it, as
+	// long as they don't drop it
+	if RequiredSwarmLabel != "" && (old == nil || hasLabel(old, RequiredSwarmLabel)) {
+		if !hasLabel(r, RequiredSwarmLabel) {
+			allErrs = append(allErrs, field.Required(field.NewPath("metadata").Child("labels").Key(RequiredSwarmLabel),
+				"every swarm must carry this label"))
+		}
+	}
+	if name, ok := r.Annotations[DroneTemplateAnnotation]; ok {
+		if _, known := DroneTemplates[name]; !known {
+			allErrs = append(allErrs, field.NotFound(field.NewPath("metadata").Child("annotations").Key(DroneTemplateAnnotation), name))
+		}
+	}
+	if r.Spec.FillNodes && r.Spec.HowManyFrom != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("fillNodes"), r.Spec.FillNodes, "fillNodes and howManyFrom are mutually exclusive"))
+	}
+	// the webhook leaves howmany of fillNodes swarms at 0, so any other
+	// value was set. Swarms from before that carry the old default of 1 and
+	// may keep it.
+	if r.Spec.FillNodes && r.Spec.HowMany != 0 && (old == nil || !old.Spec.FillNodes || old.Spec.HowMany != r.Spec.HowMany) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("fillNodes"), r.Spec.FillNodes,
+			"fillNodes and howmany are mutually exclusive, fillNodes runs one drone per drone node"))
+	}
+	if r.Spec.OnePerNode && r.Spec.Template.MaxPerNode > 1 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("onePerNode"), r.Spec.OnePerNode,
+			"onePerNode and template.maxPerNode above 1 are mutually exclusive"))
+	}
+	if fldPath, msg := r.largeSwarmGrowth(old, specPath); fldPath != nil {
+		approved, err := r.largeSwarmApproved()
+		if err != nil {
+			allErrs = append(allErrs, field.InternalError(fldPath, err))
+		} else if !approved {
+			allErrs = append(allErrs, field.Forbidden(fldPath, msg+" need "+largeSwarmApprovals()))
+		}
+	}
+	if pdb := r.Spec.PDB; pdb != nil && pdb.MinAvailable != nil && pdb.MaxUnavailable != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("pdb"), pdb, "minAvailable and maxUnavailable are mutually exclusive"))
+	}
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Swarm").GroupKind(), r.Name, allErrs)
+}
+
+// hasLabel reports whether the swarm carries the label key.
+func hasLabel(swarm *Swarm, key string) bool {
+	_, ok := swarm.Labels[key]
+	return ok
+}
+
+// largeSwarmGrowth returns the field letting the swarm grow past
+// LargeSwarmThreshold and why, or nil if the swarm doesn't. HowManyFrom and
+// FillNodes swarms can grow without bounds. Updates only count when they let
+// the swarm grow, so swarms from before the threshold keep working.
+func (r *Swarm) largeSwarmGrowth(old *Swarm, specPath *field.Path) (*field.Path, string) {
+	if LargeSwarmThreshold <= 0 {
+		return nil, ""
+	}
+	switch {
+	case r.Spec.HowMany > LargeSwarmThreshold && (old == nil || r.Spec.HowMany > old.Spec.HowMany):
+		return specPath.Child("howmany"), fmt.Sprintf("swarms of more than %d drones", LargeSwarmThreshold)
+	case r.Spec.HowManyFrom != nil && (old == nil || old.Spec.HowManyFrom == nil):
+		return specPath.Child("howManyFrom"), fmt.Sprintf("swarms reading howmany from a ConfigMap, which may exceed %d drones,", LargeSwarmThreshold)
+	case r.Spec.FillNodes && (old == nil || !old.Spec.FillNodes):
+		return specPath.Child("fillNodes"), fmt.Sprintf("swarms filling all drone nodes, which may exceed %d drones,", LargeSwarmThreshold)
+	}
+	return nil, ""
+}
+
+// largeSwarmApprovals names the ways a large swarm can be approved.
+func largeSwarmApprovals() string {
+	approvals := fmt.Sprintf("approval by the %s annotation set to \"true\"", LargeSwarmApprovedAnnotation)
+	if LargeSwarmNamespaceLabel != "" {
+		approvals += fmt.Sprintf(" or the %s label set to \"true\" on their namespace", LargeSwarmNamespaceLabel)
+	}
+	return approvals
+}
+
+// largeSwarmApproved reports whether the swarm may exceed LargeSwarmThreshold,
+// by its own annotation or the label of its namespace.
+func (r *Swarm) largeSwarmApproved() (bool, error) {
+	if r.Annotations[LargeSwarmApprovedAnnotation] == "true" {
+		return true, nil
+	}
+	if LargeSwarmNamespaceLabel == "" || swarmClient == nil {
+		return false, nil
+	}
+	ns := core.Namespace{}
+	if err := swarmClient.Get(context.Background(), client.ObjectKey{Name: r.Namespace}, &ns); err != nil {
+		return false, err
+	}
+	return ns.Labels[LargeSwarmNamespaceLabel] == "true", nil
+}
+
+// warnDraining warns, with an event on the swarm, when HowMany drops below the
+// number of its drones still being deleted. The update is let through, but the
+// swarm only creates drones again once those are gone. Admission warnings need
+// a newer API server, hence the event.
+func (r *Swarm) warnDraining() {
+	if swarmClient == nil {
+		return
+	}
+	namespace := r.Spec.TargetNamespace
+	if namespace == "" {
+		namespace = r.Namespace
+	}
+	drones := DroneList{}
+	if err := swarmClient.List(context.Background(), &drones, client.InNamespace(namespace),
+		client.MatchingLabels{SwarmNameLabel: r.Name}); err != nil {
+		swarmlog.Error(err, "failed to list drones", "name", r.Name)
+		return
+	}
+	var draining int32
+	for _, d := range drones.Items {
+		if d.DeletionTimestamp != nil {
+			draining++
+		}
+	}
+	if r.Spec.HowMany < draining {
+		msg := fmt.Sprintf("howmany %d is below the %d drones still draining", r.Spec.HowMany, draining)
+		swarmlog.Info("warning: "+msg, "name", r.Name)
+		swarmRecorder.Event(r, core.EventTypeWarning, "HowManyBelowDraining", msg)
+	}
+}
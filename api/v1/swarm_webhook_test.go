@@ -0,0 +1,425 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func init() {
+	// the fake client decodes everything with the client-go scheme
+	if err := AddToScheme(scheme.Scheme); err != nil {
+		panic(err)
+	}
+}
+
+func TestSwarmDefaultFromJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want int32
+	}{
+		{name: "howmany left out", raw: `{"spec":{"onePerNode":true}}`, want: DefaultHowMany},
+		{name: "spec left out", raw: `{"metadata":{"name":"swarm"}}`, want: DefaultHowMany},
+		{name: "explicit zero", raw: `{"spec":{"howmany":0}}`, want: 0},
+		{name: "explicit count", raw: `{"spec":{"howmany":5}}`, want: 5},
+		{name: "fillNodes without howmany", raw: `{"spec":{"fillNodes":true}}`, want: 0},
+		{name: "fillNodes with howmany", raw: `{"spec":{"fillNodes":true,"howmany":1}}`, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			swarm := &Swarm{}
+			if err := json.Unmarshal([]byte(tt.raw), swarm); err != nil {
+				t.Fatal(err)
+			}
+			if err := swarm.DefaultFromJSON([]byte(tt.raw)); err != nil {
+				t.Fatal(err)
+			}
+			if swarm.Spec.HowMany != tt.want {
+				t.Errorf("howmany = %d, want %d", swarm.Spec.HowMany, tt.want)
+			}
+		})
+	}
+}
+
+func TestSwarmDefaulterHandle(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaulter := &swarmDefaulter{}
+	if err := defaulter.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := []byte(`{"apiVersion":"experiments.mad.md/v1","kind":"Swarm","metadata":{"name":"swarm"},"spec":{}}`)
+	resp := defaulter.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+		Object: runtime.RawExtension{Raw: raw},
+	}})
+	if !resp.Allowed {
+		t.Fatalf("swarm denied: %v", resp.Result)
+	}
+	var found bool
+	for _, patch := range resp.Patches {
+		if patch.Path == "/spec/howmany" && patch.Value == float64(DefaultHowMany) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("patches = %v, want howmany defaulted to %d", resp.Patches, DefaultHowMany)
+	}
+}
+
+func TestSwarmValidateUpdateWarnsDraining(t *testing.T) {
+	now := metav1.Now()
+	var objs []runtime.Object
+	for i, name := range []string{"a", "b", "c", "d"} {
+		drone := &Drone{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{SwarmNameLabel: "fleet"}}}
+		if i < 3 {
+			drone.DeletionTimestamp = &now
+		}
+		objs = append(objs, drone)
+	}
+	recorder := record.NewFakeRecorder(10)
+	swarmClient, swarmRecorder = fake.NewFakeClientWithScheme(scheme.Scheme, objs...), recorder
+	defer func() { swarmClient, swarmRecorder = nil, nil }()
+
+	tests := []struct {
+		name     string
+		from, to int32
+		warn     bool
+	}{
+		{name: "below draining", from: 5, to: 1, warn: true},
+		{name: "at draining", from: 5, to: 3},
+		{name: "growing", from: 1, to: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := &Swarm{ObjectMeta: metav1.ObjectMeta{Name: "fleet", Namespace: "default"}, Spec: SwarmSpec{HowMany: tt.from}}
+			updated := old.DeepCopy()
+			updated.Spec.HowMany = tt.to
+			// a warning, the update goes through
+			if err := updated.ValidateUpdate(old); err != nil {
+				t.Fatalf("update denied: %v", err)
+			}
+			select {
+			case event := <-recorder.Events:
+				if !tt.warn || !strings.HasPrefix(event, "Warning HowManyBelowDraining ") {
+					t.Errorf("event = %q, want warn %v", event, tt.warn)
+				}
+			default:
+				if tt.warn {
+					t.Error("no warning about the draining drones")
+				}
+			}
+		})
+	}
+}
+
+func TestSwarmRequiredLabel(t *testing.T) {
+	RequiredSwarmLabel = "team"
+	defer func() { RequiredSwarmLabel = "" }()
+	swarm := func(labels map[string]string) *Swarm {
+		return &Swarm{ObjectMeta: metav1.ObjectMeta{Name: "fleet", Labels: labels}, Spec: SwarmSpec{HowMany: 1}}
+	}
+	labeled, unlabeled := map[string]string{"team": "blue"}, map[string]string{"app": "drone"}
+
+	tests := []struct {
+		name    string
+		old     *Swarm
+		swarm   *Swarm
+		allowed bool
+	}{
+		{name: "create labeled", swarm: swarm(labeled), allowed: true},
+		{name: "create unlabeled", swarm: swarm(unlabeled)},
+		{name: "create without labels", swarm: swarm(nil)},
+		{name: "update keeping the label", old: swarm(labeled), swarm: swarm(labeled), allowed: true},
+		{name: "update dropping the label", old: swarm(labeled), swarm: swarm(unlabeled)},
+		{name: "update of a swarm from before the label", old: swarm(unlabeled), swarm: swarm(unlabeled), allowed: true},
+		{name: "update adding the label", old: swarm(unlabeled), swarm: swarm(labeled), allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.old == nil {
+				err = tt.swarm.ValidateCreate()
+			} else {
+				err = tt.swarm.ValidateUpdate(tt.old)
+			}
+			if allowed := err == nil; allowed != tt.allowed {
+				t.Errorf("allowed = %v, want %v: %v", allowed, tt.allowed, err)
+			}
+			if err != nil {
+				statusErr, ok := err.(*apierrors.StatusError)
+				if !ok || len(statusErr.ErrStatus.Details.Causes) != 1 || statusErr.ErrStatus.Details.Causes[0].Field != "metadata.labels[team]" {
+					t.Errorf("error = %v, want the missing team label", err)
+				}
+			}
+		})
+	}
+
+	// a swarm on its way out isn't held up on the label
+	deleting := swarm(unlabeled)
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	if err := deleting.ValidateUpdate(swarm(labeled)); err != nil {
+		t.Errorf("update of a deleted swarm denied: %v", err)
+	}
+}
+
+func TestParseDroneTemplates(t *testing.T) {
+	templates, err := ParseDroneTemplates([]byte(`
+small:
+  image: danacr/drone-pod:v1
+  resources:
+    requests:
+      cpu: 100m
+  workingDir: /srv/drone
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, ok := templates["small"]
+	if !ok || small.Image != "danacr/drone-pod:v1" || small.WorkingDir != "/srv/drone" || small.Resources.Requests.Cpu().MilliValue() != 100 {
+		t.Errorf("templates = %+v, want the small one", templates)
+	}
+
+	if _, err := ParseDroneTemplates([]byte("small:\n  imag: danacr/drone-pod:v1\n")); err == nil {
+		t.Error("template with a misspelled field parsed, want it rejected")
+	}
+}
+
+func TestSwarmDefaultDroneTemplate(t *testing.T) {
+	requests := core.ResourceList{core.ResourceCPU: resource.MustParse("100m")}
+	DroneTemplates = map[string]DroneSpec{"small": {
+		Image:         "danacr/drone-pod:v1",
+		RestartPolicy: core.RestartPolicyOnFailure,
+		Resources:     core.ResourceRequirements{Requests: requests},
+		StartupProbe:  &core.Probe{FailureThreshold: 30},
+		PodLabels:     map[string]string{"size": "small"},
+	}}
+	defer func() { DroneTemplates = nil }()
+
+	swarm := &Swarm{ObjectMeta: metav1.ObjectMeta{Name: "fleet", Annotations: map[string]string{DroneTemplateAnnotation: "small"}}}
+	swarm.Spec.Template.Image = "danacr/drone-pod:v2"
+	swarm.Default()
+	template := swarm.Spec.Template
+	if template.Image != "danacr/drone-pod:v2" {
+		t.Errorf("image = %q, want the swarm's own", template.Image)
+	}
+	if template.RestartPolicy != core.RestartPolicyOnFailure || template.StartupProbe == nil || template.StartupProbe.FailureThreshold != 30 {
+		t.Errorf("template = %+v, want the restart policy and startup probe of the drone template", template)
+	}
+	if !reflect.DeepEqual(template.Resources.Requests, requests) || template.PodLabels["size"] != "small" {
+		t.Errorf("template = %+v, want the requests and pod labels of the drone template", template)
+	}
+	template.PodLabels["size"] = "large"
+	if DroneTemplates["small"].PodLabels["size"] != "small" {
+		t.Error("defaulted swarm shares the pod labels of the drone template")
+	}
+
+	schemaDefaulted := &Swarm{ObjectMeta: metav1.ObjectMeta{Name: "fleet", Annotations: map[string]string{DroneTemplateAnnotation: "small"}}}
+	schemaDefaulted.Spec.Template.Image = DefaultImage
+	schemaDefaulted.Spec.Template.RestartPolicy = core.RestartPolicyAlways
+	schemaDefaulted.Default()
+	if template := schemaDefaulted.Spec.Template; template.Image != "danacr/drone-pod:v1" || template.RestartPolicy != core.RestartPolicyOnFailure {
+		t.Errorf("template = %+v, want the drone template to replace the schema defaults", template)
+	}
+
+	unknown := &Swarm{ObjectMeta: metav1.ObjectMeta{Name: "fleet", Annotations: map[string]string{DroneTemplateAnnotation: "huge"}}, Spec: SwarmSpec{HowMany: 1}}
+	unknown.Default()
+	if err := unknown.ValidateCreate(); !apierrors.IsInvalid(err) {
+		t.Errorf("swarm with an unknown drone template = %v, want invalid", err)
+	}
+}
+
+func TestValidateConflictingPlacement(t *testing.T) {
+	one := intstr.FromInt(1)
+	tests := []struct {
+		name      string
+		spec      SwarmSpec
+		wantField string
+		wantNames []string
+	}{
+		{name: "onePerNode alone", spec: SwarmSpec{HowMany: 3, OnePerNode: true}},
+		{name: "onePerNode with maxPerNode 1", spec: SwarmSpec{HowMany: 3, OnePerNode: true, Template: DroneSpec{MaxPerNode: 1}}},
+		{name: "onePerNode with maxPerNode 2", spec: SwarmSpec{HowMany: 3, OnePerNode: true, Template: DroneSpec{MaxPerNode: 2}},
+			wantField: "spec.onePerNode", wantNames: []string{"onePerNode", "maxPerNode"}},
+		{name: "fillNodes alone", spec: SwarmSpec{FillNodes: true}},
+		{name: "fillNodes with howmany", spec: SwarmSpec{HowMany: 5, FillNodes: true},
+			wantField: "spec.fillNodes", wantNames: []string{"fillNodes", "howmany"}},
+		{name: "fillNodes with howmany 1", spec: SwarmSpec{HowMany: 1, FillNodes: true},
+			wantField: "spec.fillNodes", wantNames: []string{"fillNodes", "howmany"}},
+		{name: "fillNodes with howManyFrom", spec: SwarmSpec{FillNodes: true,
+			HowManyFrom: &core.ConfigMapKeySelector{LocalObjectReference: core.LocalObjectReference{Name: "scale"}, Key: "drones"}},
+			wantField: "spec.fillNodes", wantNames: []string{"fillNodes", "howManyFrom"}},
+		{name: "directAssign alone", spec: SwarmSpec{HowMany: 1, Template: DroneSpec{DirectAssign: true}}},
+		{name: "directAssign with schedulerName", spec: SwarmSpec{HowMany: 1, Template: DroneSpec{DirectAssign: true, SchedulerName: "gang"}},
+			wantField: "spec.template.directAssign", wantNames: []string{"directAssign", "schedulerName"}},
+		{name: "pdb with both bounds", spec: SwarmSpec{HowMany: 1, PDB: &PDBSpec{MinAvailable: &one, MaxUnavailable: &one}},
+			wantField: "spec.pdb", wantNames: []string{"minAvailable", "maxUnavailable"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			swarm := &Swarm{ObjectMeta: metav1.ObjectMeta{Name: "fleet"}, Spec: tt.spec}
+			err := swarm.ValidateCreate()
+			if tt.wantField == "" {
+				if err != nil {
+					t.Errorf("swarm denied: %v", err)
+				}
+				return
+			}
+			statusErr, ok := err.(*apierrors.StatusError)
+			if !ok || !apierrors.IsInvalid(err) {
+				t.Fatalf("swarm = %v, want invalid", err)
+			}
+			causes := statusErr.ErrStatus.Details.Causes
+			if len(causes) != 1 || causes[0].Field != tt.wantField {
+				t.Fatalf("causes = %v, want one on %s", causes, tt.wantField)
+			}
+			for _, name := range tt.wantNames {
+				if !strings.Contains(causes[0].Message, name) {
+					t.Errorf("message %q doesn't name %s", causes[0].Message, name)
+				}
+			}
+		})
+	}
+
+	drone := &Drone{ObjectMeta: metav1.ObjectMeta{Name: "direct"}, Spec: DroneSpec{DirectAssign: true, SchedulerName: "gang"}}
+	if err := drone.ValidateCreate(); !apierrors.IsInvalid(err) {
+		t.Errorf("drone with directAssign and schedulerName = %v, want invalid", err)
+	}
+}
+
+func TestValidateFillNodesKeepsOldHowMany(t *testing.T) {
+	// defaulted to 1 before fillNodes swarms kept howmany at 0
+	old := &Swarm{ObjectMeta: metav1.ObjectMeta{Name: "fleet"}, Spec: SwarmSpec{HowMany: 1, FillNodes: true}}
+	relabelled := old.DeepCopy()
+	relabelled.Labels = map[string]string{"team": "drones"}
+	if err := relabelled.ValidateUpdate(old); err != nil {
+		t.Errorf("update keeping the old howmany denied: %v", err)
+	}
+
+	changed := old.DeepCopy()
+	changed.Spec.HowMany = 3
+	if err := changed.ValidateUpdate(old); !apierrors.IsInvalid(err) {
+		t.Errorf("update setting howmany on a fillNodes swarm = %v, want invalid", err)
+	}
+
+	counted := &Swarm{ObjectMeta: metav1.ObjectMeta{Name: "fleet"}, Spec: SwarmSpec{HowMany: 1}}
+	filling := counted.DeepCopy()
+	filling.Spec.FillNodes = true
+	if err := filling.ValidateUpdate(counted); !apierrors.IsInvalid(err) {
+		t.Errorf("update turning on fillNodes next to howmany = %v, want invalid", err)
+	}
+}
+
+func TestSwarmLargeSwarmApproval(t *testing.T) {
+	LargeSwarmThreshold, LargeSwarmNamespaceLabel = 10, "drones.example.com/large"
+	swarmClient = fake.NewFakeClientWithScheme(scheme.Scheme,
+		&core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "big", Labels: map[string]string{"drones.example.com/large": "true"}}},
+		&core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "small"}})
+	defer func() { LargeSwarmThreshold, LargeSwarmNamespaceLabel, swarmClient = 0, "", nil }()
+	swarm := func(namespace string, howMany int32, approved bool) *Swarm {
+		s := &Swarm{ObjectMeta: metav1.ObjectMeta{Name: "fleet", Namespace: namespace}, Spec: SwarmSpec{HowMany: howMany}}
+		if approved {
+			s.Annotations = map[string]string{LargeSwarmApprovedAnnotation: "true"}
+		}
+		return s
+	}
+	fromConfigMap := swarm("small", 1, false)
+	fromConfigMap.Spec.HowManyFrom = &core.ConfigMapKeySelector{LocalObjectReference: core.LocalObjectReference{Name: "scale"}, Key: "drones"}
+	filling := swarm("small", 0, false)
+	filling.Spec.FillNodes = true
+
+	tests := []struct {
+		name      string
+		old       *Swarm
+		swarm     *Swarm
+		wantField string
+	}{
+		{name: "at the threshold", swarm: swarm("small", 10, false)},
+		{name: "above the threshold", swarm: swarm("small", 11, false), wantField: "spec.howmany"},
+		{name: "approved by annotation", swarm: swarm("small", 11, true)},
+		{name: "approved by namespace", swarm: swarm("big", 11, false)},
+		{name: "annotation not true", swarm: func() *Swarm {
+			s := swarm("small", 11, false)
+			s.Annotations = map[string]string{LargeSwarmApprovedAnnotation: "yes"}
+			return s
+		}(), wantField: "spec.howmany"},
+		{name: "howManyFrom", swarm: fromConfigMap, wantField: "spec.howManyFrom"},
+		{name: "fillNodes", swarm: filling, wantField: "spec.fillNodes"},
+		{name: "growing past the threshold", old: swarm("small", 10, false), swarm: swarm("small", 12, false), wantField: "spec.howmany"},
+		{name: "shrinking from before the threshold", old: swarm("small", 20, false), swarm: swarm("small", 15, false)},
+		{name: "unchanged howManyFrom", old: fromConfigMap, swarm: fromConfigMap},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.old == nil {
+				err = tt.swarm.ValidateCreate()
+			} else {
+				err = tt.swarm.ValidateUpdate(tt.old)
+			}
+			if tt.wantField == "" {
+				if err != nil {
+					t.Errorf("swarm denied: %v", err)
+				}
+				return
+			}
+			statusErr, ok := err.(*apierrors.StatusError)
+			if !ok || len(statusErr.ErrStatus.Details.Causes) != 1 || statusErr.ErrStatus.Details.Causes[0].Field != tt.wantField ||
+				!strings.Contains(statusErr.ErrStatus.Details.Causes[0].Message, LargeSwarmApprovedAnnotation) ||
+				!strings.Contains(statusErr.ErrStatus.Details.Causes[0].Message, LargeSwarmNamespaceLabel) {
+				t.Errorf("error = %v, want %s forbidden without approval", err, tt.wantField)
+			}
+		})
+	}
+
+	want := `swarms reading howmany from a ConfigMap, which may exceed 10 drones, need approval by the ` +
+		`experiments.mad.md/large-swarm-approved annotation set to "true" or the drones.example.com/large label set to "true" on their namespace`
+	if err := fromConfigMap.ValidateCreate(); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want it to say %q", err, want)
+	}
+
+	// without a namespace to look at, only the annotation approves
+	LargeSwarmNamespaceLabel = ""
+	want = `swarms of more than 10 drones need approval by the experiments.mad.md/large-swarm-approved annotation set to "true"`
+	if err := swarm("big", 11, false).ValidateCreate(); err == nil || !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("error = %v, want it to end in %q", err, want)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+// setSwarmCondition adds or updates the condition of the given type. The
+// transition time only moves to now when the status actually changes.
+func setSwarmCondition(status *experimentsv1.SwarmStatus, conditionType experimentsv1.SwarmConditionType, conditionStatus core.ConditionStatus, reason, message string, now metav1.Time) {
+	for i := range status.Conditions {
+		c := &status.Conditions[i]
+		if c.Type != conditionType {
+			continue
+		}
+		if c.Status != conditionStatus {
+			c.Status = conditionStatus
+			c.LastTransitionTime = now
+		}
+		c.Reason, c.Message = reason, message
+		return
+	}
+	status.Conditions = append(status.Conditions, experimentsv1.SwarmCondition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		LastTransitionTime: now,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// swarmConditionTrue reports whether the condition of the given type is
+// true.
+func swarmConditionTrue(status *experimentsv1.SwarmStatus, conditionType experimentsv1.SwarmConditionType) bool {
+	for _, c := range status.Conditions {
+		if c.Type == conditionType {
+			return c.Status == core.ConditionTrue
+		}
+	}
+	return false
+}
+
+// setDroneCondition is like setSwarmCondition for drones, and reports whether
+// the condition changed.
+func setDroneCondition(status *experimentsv1.DroneStatus, conditionType experimentsv1.DroneConditionType, conditionStatus core.ConditionStatus, reason, message string, now metav1.Time) bool {
+	for i := range status.Conditions {
+		c := &status.Conditions[i]
+		if c.Type != conditionType {
+			continue
+		}
+		if c.Status == conditionStatus && c.Reason == reason && c.Message == message {
+			return false
+		}
+		if c.Status != conditionStatus {
+			c.Status = conditionStatus
+			c.LastTransitionTime = now
+		}
+		c.Reason, c.Message = reason, message
+		return true
+	}
+	status.Conditions = append(status.Conditions, experimentsv1.DroneCondition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		LastTransitionTime: now,
+		Reason:             reason,
+		Message:            message,
+	})
+	return true
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+)
+
+// reconcileContext returns the context a single reconcile runs in. It is
+// cancelled once stop is closed, i.e. the manager shuts down, so reconciles
+// don't start creating things halfway through. A non-zero timeout bounds it
+// as well, so a hung API call fails the reconcile (which is then requeued)
+// instead of blocking the worker forever.
+func reconcileContext(timeout time.Duration, stop <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		cancel = chainCancel(cancelTimeout, cancel)
+	}
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// chainCancel returns a CancelFunc calling all of fns.
+func chainCancel(fns ...context.CancelFunc) context.CancelFunc {
+	return func() {
+		for _, fn := range fns {
+			fn()
+		}
+	}
+}
+
+// shuttingDown reports whether the reconcile should stop making changes.
+func shuttingDown(ctx context.Context) bool {
+	return ctx.Err() == context.Canceled
+}
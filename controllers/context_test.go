@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// hungClient is a client whose reads never come back before the context is
+// done.
+type hungClient struct {
+	client.Client
+}
+
+func (c hungClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestReconcileTimesOut(t *testing.T) {
+	droneReconciler, _ := newDroneReconciler(newDrone("hung"))
+	droneReconciler.Client = hungClient{droneReconciler.Client}
+	droneReconciler.Timeout = 10 * time.Millisecond
+	swarmReconciler, _ := newSwarmReconciler(newSwarm("hung", 1))
+	swarmReconciler.Client = hungClient{swarmReconciler.Client}
+	swarmReconciler.Timeout = 10 * time.Millisecond
+
+	for name, reconciler := range map[string]interface {
+		Reconcile(ctrl.Request) (ctrl.Result, error)
+	}{"drone": droneReconciler, "swarm": swarmReconciler} {
+		t.Run(name, func(t *testing.T) {
+			done := make(chan error, 1)
+			go func() {
+				_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "hung"}})
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				// an error gets the request requeued with backoff
+				if err != context.DeadlineExceeded {
+					t.Errorf("reconcile error = %v, want the deadline to be exceeded", err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("reconcile hangs on a hung client")
+			}
+		})
+	}
+}
+
+// stoppingClient closes stop the first time nodes get listed, i.e. right
+// before drones or their pods get created, and waits for the reconcile to
+// notice.
+type stoppingClient struct {
+	client.Client
+	stop    chan struct{}
+	stopped bool
+}
+
+func (c *stoppingClient) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	if _, ok := list.(*core.NodeList); ok && !c.stopped {
+		c.stopped = true
+		close(c.stop)
+		<-ctx.Done()
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+func TestReconcileStopsCreatingOnShutdown(t *testing.T) {
+	t.Run("swarm", func(t *testing.T) {
+		r, _ := newSwarmReconciler(newSwarm("late", 3), droneNode("node-1"))
+		c := &stoppingClient{Client: r.Client, stop: make(chan struct{})}
+		r.Client = c
+		if err := r.InjectStopChannel(c.stop); err != nil {
+			t.Fatal(err)
+		}
+
+		reconcileSwarm(t, r, "late")
+		if drones := listDrones(t, c.Client, testNamespace); len(drones) != 0 {
+			t.Errorf("got %d drones created while shutting down, want none", len(drones))
+		}
+	})
+	t.Run("drone", func(t *testing.T) {
+		r, _ := newDroneReconciler(newDrone("late"), droneNode("node-1"))
+		c := &stoppingClient{Client: r.Client, stop: make(chan struct{})}
+		r.Client = c
+		if err := r.InjectStopChannel(c.stop); err != nil {
+			t.Fatal(err)
+		}
+
+		reconcileDrone(t, r, "late")
+		pods := core.PodList{}
+		if err := c.Client.List(context.Background(), &pods); err != nil {
+			t.Fatal(err)
+		}
+		if len(pods.Items) != 0 {
+			t.Errorf("got %d pods created while shutting down, want none", len(pods.Items))
+		}
+	})
+}
+
+func TestReconcileContextStop(t *testing.T) {
+	stop := make(chan struct{})
+	ctx, cancel := reconcileContext(time.Hour, stop)
+	defer cancel()
+	if shuttingDown(ctx) {
+		t.Fatal("shutting down before the manager stopped")
+	}
+	close(stop)
+	<-ctx.Done()
+	if !shuttingDown(ctx) {
+		t.Error("not shutting down once the manager stopped")
+	}
+}
@@ -18,14 +18,33 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"time"
 
 	"github.com/go-logr/logr"
 	core "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	experimentsv1 "github.com/danacr/drone/api/v1"
 )
@@ -33,8 +52,63 @@ import (
 // DroneReconciler reconciles a Drone object
 type DroneReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// NonControllerOwner makes the Drone a plain owner of its pod instead of
+	// the controlling one, so another controller can co-own the pod.
+	NonControllerOwner bool
+
+	// Timeout bounds a single reconcile. Zero means no timeout.
+	Timeout time.Duration
+
+	// SyncPeriod is how often each object is reconciled even when nothing
+	// changed, plus up to 10% jitter. Zero leaves it to the watches.
+	SyncPeriod time.Duration
+
+	// stop is closed when the manager shuts down
+	stop <-chan struct{}
+
+	// ImageRewrites maps image prefixes to their replacement, e.g. to pull
+	// drone images from an internal mirror.
+	ImageRewrites map[string]string
+
+	// DeferToScheduler leaves the choice of node to the scheduler of Drones
+	// naming one, instead of pinning their pods to a free drone node.
+	DeferToScheduler bool
+
+	// Clock tells the time, the wall clock unless set.
+	Clock clock.Clock
+
+	// TolerateNodeTaint lets drone pods onto drone nodes tainted by the
+	// NodeReconciler.
+	TolerateNodeTaint bool
+
+	// MeshAnnotations are stamped on the pods of Drones asking for mesh
+	// injection.
+	MeshAnnotations map[string]string
+
+	// ImageFallbackAfter is how long a drone pod may fail to pull its image
+	// before the Drone's fallback image is used instead.
+	ImageFallbackAfter time.Duration
+
+	// FinalizerPrefix is the domain of the finalizer put on Drones,
+	// DefaultFinalizerPrefix if empty.
+	FinalizerPrefix string
+
+	// RateLimiter spaces out the retries of failed reconciles, the
+	// workqueue default if nil.
+	RateLimiter workqueue.RateLimiter
+
+	// DefaultImage runs drones that don't name an image or are left at the
+	// schema default DefaultDroneImage, DefaultDroneImage if empty.
+	DefaultImage string
+
+	// MaxStartingPerNode caps how many drone pods a single node may be
+	// starting at once, new ones wait for a node with fewer. Zero means no
+	// limit.
+	MaxStartingPerNode int32
 }
 
 // +kubebuilder:rbac:groups=experiments.mad.md,resources=drones,verbs=get;list;watch;create;update;patch;delete
@@ -43,8 +117,17 @@ type DroneReconciler struct {
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile stuff
-func (r *DroneReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	ctx := context.Background()
+func (r *DroneReconciler) Reconcile(req ctrl.Request) (result ctrl.Result, err error) {
+	log := r.Log.WithValues("Drone", req.NamespacedName)
+	defer limitRetries(log, r.RateLimiter, req, &result, &err)
+	defer recoverReconcile(log, &err)
+	return r.reconcile(req)
+}
+
+// reconcile brings the pod of the Drone in line with it.
+func (r *DroneReconciler) reconcile(req ctrl.Request) (result ctrl.Result, err error) {
+	ctx, cancel := reconcileContext(r.Timeout, r.stop)
+	defer cancel()
 	log := r.Log.WithValues("Drone", req.NamespacedName)
 
 	// your logic here
@@ -52,88 +135,621 @@ func (r *DroneReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	Drone := experimentsv1.Drone{}
 	if err := r.Client.Get(ctx, req.NamespacedName, &Drone); err != nil {
 		log.Error(err, "failed to get Drone resource")
+		if apierrors.IsNotFound(err) {
+			pendingDrones.Delete(req.NamespacedName)
+		}
 		// Ignore NotFound errors as they will be retried automatically if the
 		// resource is created in future.
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	// resync it every SyncPeriod even when nothing changes
+	defer requeueForSync(r.SyncPeriod, &result, &err)
+
+	finalizer := finalizerName(r.FinalizerPrefix, metricsFinalizer)
+	if Drone.DeletionTimestamp != nil {
+		// the Drone may be gone before we ever see it NotFound, e.g. when
+		// the manager restarts in between, so clean up while we still can
+		pendingDrones.Delete(req.NamespacedName)
+		if hasFinalizer(&Drone, finalizer) {
+			// garbage collection would get to the pod too, but may lag
+			// behind, e.g. while a swarm scales down
+			if err := r.deletePod(ctx, &Drone); err != nil {
+				log.Error(err, "failed to delete drone pod")
+				return ctrl.Result{}, err
+			}
+			controllerutil.RemoveFinalizer(&Drone, finalizer)
+			if err := r.Update(ctx, &Drone); err != nil {
+				log.Error(err, "failed to remove finalizer")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+	if !hasFinalizer(&Drone, finalizer) {
+		controllerutil.AddFinalizer(&Drone, finalizer)
+		if err := r.Update(ctx, &Drone); err != nil {
+			log.Error(err, "failed to add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if msg := invalidDroneSpec(&Drone.Spec); msg != "" {
+		// a spec update brings the Drone back
+		log.Info("invalid Drone spec, leaving it alone", "reason", msg)
+		r.Recorder.Event(&Drone, core.EventTypeWarning, reasonInvalidSpec, msg)
+		return ctrl.Result{}, nil
+	}
 
 	log.Info("checking if we have an existing drone")
+	result = ctrl.Result{}
 	pod := core.Pod{}
-	err := r.Client.Get(ctx, client.ObjectKey{Namespace: Drone.Namespace, Name: Drone.Name}, &pod)
-	if apierrors.IsNotFound(err) {
+	err = r.Client.Get(ctx, PodRefForDrone(&Drone), &pod)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "failed to get Drone resource")
+		return ctrl.Result{}, err
+	}
+	if err == nil {
+		if pod.DeletionTimestamp != nil {
+			// the pod's deletion will trigger another reconcile
+			log.Info("drone pod is terminating")
+			return ctrl.Result{}, nil
+		}
+		if want, ok := Drone.Annotations[experimentsv1.RescheduleAnnotation]; ok && pod.Annotations[experimentsv1.RescheduleAnnotation] != want {
+			log.Info("rescheduling drone", "node", pod.Spec.NodeName)
+			moved, err := r.movePod(ctx, &Drone, &pod)
+			if err != nil {
+				log.Error(err, "failed to move drone pod")
+				return ctrl.Result{}, err
+			}
+			if !moved {
+				log.Info("no other drone node to move to, rescheduling later")
+				r.Recorder.Event(&Drone, core.EventTypeWarning, reasonNoFreeNode, "no other drone node to reschedule the drone to")
+				return ctrl.Result{RequeueAfter: moveRequeueDelay}, nil
+			}
+			return ctrl.Result{}, nil
+		}
+		// a finished pod would run all over again elsewhere
+		if cordoned, err := r.nodeCordoned(ctx, podNode(&pod)); err != nil {
+			log.Error(err, "failed to get drone node")
+			return ctrl.Result{}, err
+		} else if cordoned && !podTerminated(&pod) {
+			// move the drone before the node gets drained under it, but
+			// only if it has somewhere to go
+			log.Info("drone node is cordoned, rescheduling drone", "node", podNode(&pod))
+			moved, err := r.movePod(ctx, &Drone, &pod)
+			if err != nil {
+				log.Error(err, "failed to move drone pod")
+				return ctrl.Result{}, err
+			}
+			if moved {
+				return ctrl.Result{}, nil
+			}
+			// the status still follows the pod while it stays
+			log.Info("no other drone node to move to, staying on the cordoned node")
+			result.RequeueAfter = moveRequeueDelay
+		}
+		if Drone.Spec.FallbackImage != "" && !Drone.Status.UsingFallbackImage && imagePullFailing(&pod) &&
+			r.Clock.Since(pod.CreationTimestamp.Time) > r.ImageFallbackAfter {
+			// the pod is updated with the fallback image below
+			log.Info("drone image fails to pull, falling back", "image", Drone.Spec.FallbackImage)
+			r.Recorder.Eventf(&Drone, core.EventTypeWarning, "FallbackImage",
+				"image %s failed to pull, falling back to %s", Drone.Spec.Image, Drone.Spec.FallbackImage)
+			Drone.Status.UsingFallbackImage = true
+			if err := r.Update(ctx, &Drone); err != nil {
+				log.Error(err, "failed to update Drone")
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	if apierrors.IsNotFound(err) && Drone.Status.Flying {
+		// the pod went away behind our back, e.g. while the controller was
+		// down; every Drone is reconciled on startup, so this heals then
+		log.Info("drone pod of a flying drone is gone, recreating it")
+		r.Recorder.Event(&Drone, core.EventTypeWarning, "PodMissing", "the drone pod is gone, recreating it")
+	}
+
+	var nodeName string
+	if apierrors.IsNotFound(err) && r.defersToScheduler(&Drone) {
+		log.Info("could not find existing Drone, leaving its placement to the scheduler", "scheduler", Drone.Spec.SchedulerName)
+	} else if apierrors.IsNotFound(err) {
 		log.Info("could not find existing Drone, trying to create one...")
 
-		// get list of available nodes that are drones
-		dronenodes := core.NodeList{}
-		if err := r.List(ctx, &dronenodes, client.MatchingLabels{"node-role.kubernetes.io/drone": "drone"}); err != nil {
+		pool, err := r.placementPool(ctx, &Drone)
+		if errors.Is(err, errInvalidColocation) {
+			log.Info("invalid colocation selector", "reason", err.Error())
+			r.Recorder.Event(&Drone, core.EventTypeWarning, reasonInvalidSpec, err.Error())
+			return ctrl.Result{}, nil
+		}
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		// keep a moved drone off the node it was taken off, unless that is
+		// the only one left
+		others := *pool
+		others.ExcludeNode(Drone.Status.MovingFrom)
+		nodeName, err = pickNode(&others, &Drone)
+		if err != nil && Drone.Status.MovingFrom != "" {
+			nodeName, err = pickNode(pool, &Drone)
+		}
+		if err != nil {
+			log.Info("no drone node to fly on", "reason", err.Error())
+			r.Recorder.Event(&Drone, core.EventTypeWarning, pendingReason(err), err.Error())
+			changed := setStatus(&Drone, experimentsv1.DronePending, pendingReason(err), false, metav1.NewTime(r.Clock.Now()))
+			if Drone.Status.PendingSince == nil {
+				now := metav1.NewTime(r.Clock.Now())
+				Drone.Status.PendingSince = &now
+				changed = true
+			}
+			pendingDrones.Set(req.NamespacedName, Drone.Status.PendingSince.Time)
+			if changed {
+				if err := r.Update(ctx, &Drone); err != nil {
+					log.Error(err, "failed to update Drone")
+					return ctrl.Result{}, err
+				}
+			}
+			return ctrl.Result{}, nil
+		}
+	}
+
+	if shuttingDown(ctx) {
+		log.Info("shutting down, leaving the drone pod alone")
+		return ctrl.Result{}, nil
+	}
+
+	// if the node is free, schedule a drone-pod, otherwise bring the existing
+	// one in line with the Drone
+	ref := PodRefForDrone(&Drone)
+	pod.Name, pod.Namespace = ref.Name, ref.Namespace
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, &pod, func() error {
+		desired := r.buildPod(Drone, nodeName)
+		if pod.CreationTimestamp.IsZero() {
+			// the node is picked once, a flying drone stays where it is
+			pod.Labels = desired.Labels
+			pod.Annotations = desired.Annotations
+			pod.Spec = desired.Spec
+		}
+		// a pod spec patch may have added containers ahead of the drone one
+		var image string
+		for _, c := range desired.Spec.Containers {
+			if c.Name == DroneContainerName {
+				image = c.Image
+			}
+		}
+		for i, c := range pod.Spec.Containers {
+			if c.Name == DroneContainerName {
+				pod.Spec.Containers[i].Image = image
+			}
+		}
+		if r.NonControllerOwner {
+			setOwnerReference(&Drone, &pod)
+			return nil
+		}
+		return controllerutil.SetControllerReference(&Drone, &pod, r.Scheme)
+	})
+	if apierrors.IsAlreadyExists(err) {
+		// the pod was created behind our back (e.g. a stale cache), which is
+		// just as good as creating it ourselves
+		log.Info("drone pod already exists")
+		if err := r.Client.Get(ctx, ref, &pod); err != nil {
+			log.Error(err, "failed to get drone pod")
 			return ctrl.Result{}, err
 		}
-		// get list of running pods
-		dronepods := core.PodList{}
-		if err := r.List(ctx, &dronepods, client.InNamespace(Drone.Namespace)); err != nil {
+	} else if err != nil {
+		log.Error(err, "failed to create drone")
+		return ctrl.Result{}, err
+	}
+	if op != controllerutil.OperationResultNone {
+		log.Info("reconciled drone pod", "operation", op)
+	}
+
+	phase, flying := podPhase(&pod), podFlying(&pod)
+	changed := setStatus(&Drone, phase, pod.Status.Reason, flying, metav1.NewTime(r.Clock.Now()))
+	if status, reason, message := imagePullCondition(&pod); setDroneCondition(&Drone.Status, experimentsv1.DroneImagePulled, status, reason, message, metav1.NewTime(r.Clock.Now())) {
+		changed = true
+	}
+	if recordStartup(&Drone.Status, &pod, metav1.NewTime(r.Clock.Now())) {
+		changed = true
+	}
+	if Drone.Status.PendingSince != nil {
+		Drone.Status.PendingSince = nil
+		changed = true
+	}
+	if Drone.Status.MovingFrom != "" {
+		Drone.Status.MovingFrom = ""
+		changed = true
+	}
+	pendingDrones.Delete(req.NamespacedName)
+	if changed {
+		log.Info("updating Drone resource status", "phase", phase, "flying", flying)
+		if err := r.Update(ctx, &Drone); err != nil {
+			log.Error(err, "failed to update Drone")
 			return ctrl.Result{}, err
 		}
+	}
 
-		var dronePodNodeNameList []string
-		for _, p := range dronepods.Items {
-			dronePodNodeNameList = append(dronePodNodeNameList, p.Spec.NodeName)
+	if ttl := Drone.Spec.TTLSecondsAfterFinished; ttl != nil && (phase == experimentsv1.DroneSucceeded || phase == experimentsv1.DroneFailed) {
+		// the phase last changed when the drone finished
+		expiry := Drone.Status.LastTransitionTime.Add(time.Duration(*ttl) * time.Second)
+		if left := expiry.Sub(r.Clock.Now()); left > 0 {
+			return ctrl.Result{RequeueAfter: left}, nil
+		}
+		log.Info("finished drone is past its TTL, deleting it")
+		if err := r.Delete(ctx, &Drone); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "failed to delete Drone")
+			return ctrl.Result{}, err
 		}
+	}
 
-		for _, dronenode := range dronenodes.Items {
-			if !stringInSlice(dronenode.Name, dronePodNodeNameList) {
+	return result, nil
+}
 
-				// if the node is free, schedule a drone-pod
-				pod = *buildPod(Drone, dronenode.Name)
-				if err := r.Client.Create(ctx, &pod); err != nil {
-					log.Error(err, "failed to create drone")
-					return ctrl.Result{}, err
-				}
+// deletePod deletes the pod of the Drone, if it has one it owns.
+func (r *DroneReconciler) deletePod(ctx context.Context, Drone *experimentsv1.Drone) error {
+	pod := core.Pod{}
+	if err := r.Get(ctx, PodRefForDrone(Drone), &pod); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if pod.DeletionTimestamp != nil {
+		return nil
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.UID == Drone.UID {
+			return client.IgnoreNotFound(r.Delete(ctx, &pod))
+		}
+	}
+	return nil
+}
 
-				log.Info("created Drone")
-				log.Info("updating Drone resource status")
-				Drone.Status.Flying = true
-				if r.Update(ctx, &Drone); err != nil {
-					log.Error(err, "failed to update Drone")
-					return ctrl.Result{}, err
-				}
+// setOwnerReference adds the Drone to the owners of the pod without making it
+// the controller.
+func setOwnerReference(Drone *experimentsv1.Drone, pod *core.Pod) {
+	ref := *metav1.NewControllerRef(Drone, experimentsv1.GroupVersion.WithKind("Drone"))
+	ref.Controller = nil
+	for i, existing := range pod.OwnerReferences {
+		if existing.UID == ref.UID {
+			pod.OwnerReferences[i] = ref
+			return
+		}
+	}
+	pod.OwnerReferences = append(pod.OwnerReferences, ref)
+}
 
-			} else {
-				log.Error(err, "Not enough drone nodes")
-				Drone.Status.Flying = false
-				if r.Update(ctx, &Drone); err != nil {
-					log.Error(err, "failed to update Drone")
-					return ctrl.Result{}, err
-				}
-				return ctrl.Result{}, nil
+// setStatus records the phase of the drone and why, whether it is flying and,
+// as now, when either last changed. It reports whether the status needs to be
+// written.
+func setStatus(Drone *experimentsv1.Drone, phase experimentsv1.DronePhase, reason string, flying bool, now metav1.Time) bool {
+	if Drone.Status.Phase == phase && Drone.Status.Reason == reason && Drone.Status.Flying == flying {
+		return false
+	}
+	Drone.Status.Phase = phase
+	Drone.Status.Reason = reason
+	Drone.Status.Flying = flying
+	Drone.Status.LastTransitionTime = now
+	return true
+}
+
+// recordStartup sets the start and ready times of the drone from its pod, the
+// first time they are known. It reports whether either was set.
+func recordStartup(status *experimentsv1.DroneStatus, pod *core.Pod, now metav1.Time) bool {
+	changed := false
+	if status.StartTime == nil && pod.Status.StartTime != nil {
+		status.StartTime = pod.Status.StartTime.DeepCopy()
+		changed = true
+	}
+	if status.ReadyTime == nil && podFlying(pod) {
+		// readiness gates may have held the drone back past the pod being
+		// ready, so only trust the pod's time without them
+		ready := now
+		for _, c := range pod.Status.Conditions {
+			if c.Type == core.PodReady && !c.LastTransitionTime.IsZero() && len(pod.Spec.ReadinessGates) == 0 {
+				ready = c.LastTransitionTime
 			}
+		}
+		status.ReadyTime = &ready
+		changed = true
+	}
+	return changed
+}
 
-			return ctrl.Result{}, nil
+// podPhase maps the phase of the drone pod onto the drone.
+func podPhase(pod *core.Pod) experimentsv1.DronePhase {
+	if pod.Status.Phase == "" {
+		return experimentsv1.DronePending
+	}
+	return experimentsv1.DronePhase(pod.Status.Phase)
+}
+
+// podFlying reports whether the drone pod is ready and all its readiness
+// gates are met. Readiness is only looked at once every container has passed
+// its startup probe.
+func podFlying(pod *core.Pod) bool {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Started != nil && !*cs.Started {
+			return false
+		}
+	}
+	conditions := map[core.PodConditionType]core.ConditionStatus{}
+	for _, c := range pod.Status.Conditions {
+		conditions[c.Type] = c.Status
+	}
+	for _, gate := range pod.Spec.ReadinessGates {
+		if conditions[gate.ConditionType] != core.ConditionTrue {
+			return false
+		}
+	}
+	return conditions[core.PodReady] == core.ConditionTrue
+}
+
+// DroneContainerName is the name of the container running the drone in a
+// drone pod, e.g. for streaming its logs.
+const DroneContainerName = "drone-pod"
+
+// PodRefForDrone returns the key of the pod backing the given drone.
+func PodRefForDrone(Drone *experimentsv1.Drone) client.ObjectKey {
+	return client.ObjectKey{Namespace: Drone.Namespace, Name: Drone.Name}
+}
+
+// podTemplateHashLabel holds the hash of the DroneSpec a drone pod was built
+// from. Pods whose hash differs from that of their Drone are out of date.
+const podTemplateHashLabel = "pod-template-hash"
+
+// droneSpecHash returns a short, label-safe hash of the spec.
+func droneSpecHash(spec *experimentsv1.DroneSpec) string {
+	hasher := fnv.New32a()
+	// marshalling a plain struct can't fail
+	data, _ := json.Marshal(spec)
+	hasher.Write(data)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// maxPerNode returns how many drones the drone may share a node with. Drones
+// packed by resources only count if they set MaxPerNode.
+func maxPerNode(spec *experimentsv1.DroneSpec) int32 {
+	if spec.PackByResources && spec.MaxPerNode == 0 {
+		return math.MaxInt32
+	}
+	return spec.MaxPerNode
+}
+
+// imagePullErrors are the reasons a container waits for its image that won't
+// go away by waiting.
+var imagePullErrors = map[string]bool{
+	"ErrImagePull":     true,
+	"ImagePullBackOff": true,
+	"InvalidImageName": true,
+}
+
+// imagePullFailing reports whether the drone container of the pod can't pull
+// its image.
+func imagePullFailing(pod *core.Pod) bool {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == DroneContainerName && cs.State.Waiting != nil && imagePullErrors[cs.State.Waiting.Reason] {
+			return true
 		}
+	}
+	return false
+}
 
+// imagePullCondition returns the ImagePulled condition of the drone container
+// of the pod: true once the container got an image, false while the image
+// fails to pull and unknown until the kubelet tried.
+func imagePullCondition(pod *core.Pod) (core.ConditionStatus, string, string) {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name != DroneContainerName {
+			continue
+		}
+		if cs.ImageID != "" || cs.State.Running != nil || cs.State.Terminated != nil {
+			return core.ConditionTrue, "Pulled", ""
+		}
+		if cs.State.Waiting != nil && imagePullErrors[cs.State.Waiting.Reason] {
+			return core.ConditionFalse, cs.State.Waiting.Reason, cs.State.Waiting.Message
+		}
 	}
+	return core.ConditionUnknown, "Pulling", ""
+}
+
+// moveRequeueDelay is how long a drone to be moved, e.g. off a cordoned
+// node, waits for another node to move to.
+const moveRequeueDelay = 30 * time.Second
+
+// nodeCordoned reports whether the named node is cordoned. A pod not on any
+// node yet, or on one that is gone, isn't on a cordoned node.
+func (r *DroneReconciler) nodeCordoned(ctx context.Context, name string) (bool, error) {
+	if name == "" {
+		return false, nil
+	}
+	node := core.Node{}
+	if err := r.Get(ctx, client.ObjectKey{Name: name}, &node); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return node.Spec.Unschedulable, nil
+}
+
+// defersToScheduler reports whether the pod of the drone is placed by its
+// scheduler rather than pinned to a node by the controller.
+func (r *DroneReconciler) defersToScheduler(Drone *experimentsv1.Drone) bool {
+	return r.DeferToScheduler && Drone.Spec.SchedulerName != ""
+}
+
+// placementPool lists the drone nodes the Drone may be placed on.
+func (r *DroneReconciler) placementPool(ctx context.Context, Drone *experimentsv1.Drone) (*dronePool, error) {
+	pool, err := listDronePool(ctx, r.Client, Drone.Namespace, Drone.Spec.NodeSelector, Drone.Spec.RequiredNodeLabels)
 	if err != nil {
-		log.Error(err, "failed to get Drone resource")
-		return ctrl.Result{}, err
+		return nil, err
+	}
+	pool.RestrictToNodePools(Drone.Spec.NodePoolSelectors)
+	pool.MaxStartingPerNode = r.MaxStartingPerNode
+	if Drone.Spec.RequireColocatedWith != nil {
+		selector, err := metav1.LabelSelectorAsSelector(Drone.Spec.RequireColocatedWith)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidColocation, err)
+		}
+		pool.RestrictToNodesRunning(selector)
+	}
+	return pool, nil
+}
+
+// pickNode picks the node of the pool the Drone flies on.
+func pickNode(pool *dronePool, Drone *experimentsv1.Drone) (string, error) {
+	if preferred := Drone.Spec.PreferredNode; preferred != "" && pool.FreeNodeFits(preferred, maxPerNode(&Drone.Spec), Drone.Spec.Resources.Requests) {
+		return preferred, nil
+	}
+	if swarm, ok := Drone.Labels[experimentsv1.SwarmNameLabel]; ok && Drone.Spec.SpreadBy != "" {
+		return pool.BestSpreadNode(maxPerNode(&Drone.Spec), Drone.Spec.Resources.Requests, Drone.Spec.SpreadBy,
+			map[string]string{experimentsv1.SwarmNameLabel: swarm})
 	}
+	return pool.BestFreeNode(maxPerNode(&Drone.Spec), Drone.Spec.Resources.Requests)
+}
 
-	return ctrl.Result{}, nil
+// movePod deletes the drone pod for it to be recreated on another node, if
+// another one is free for it, and grounds the drone noting the node it leaves
+// in the status. It reports whether the pod was deleted. Pods left to their
+// scheduler are always deleted.
+func (r *DroneReconciler) movePod(ctx context.Context, Drone *experimentsv1.Drone, pod *core.Pod) (bool, error) {
+	if !r.defersToScheduler(Drone) {
+		pool, err := r.placementPool(ctx, Drone)
+		if errors.Is(err, errInvalidColocation) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		pool.ExcludeNode(podNode(pod))
+		if _, err := pickNode(pool, Drone); err != nil {
+			return false, nil
+		}
+	}
+	// grounded, so the pod being gone isn't taken for it going missing
+	Drone.Status.MovingFrom = podNode(pod)
+	setStatus(Drone, experimentsv1.DronePending, reasonMoving, false, metav1.NewTime(r.Clock.Now()))
+	if err := r.Update(ctx, Drone); err != nil {
+		return false, err
+	}
+	if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-func buildPod(Drone experimentsv1.Drone, dronenodename string) *core.Pod {
+// DefaultDroneImage runs the drone when neither the Drone nor the
+// reconciler name an image.
+const DefaultDroneImage = experimentsv1.DefaultImage
+
+func (r *DroneReconciler) buildPod(Drone experimentsv1.Drone, dronenodename string) *core.Pod {
+	image := Drone.Spec.Image
+	// the CRD schema defaults the image, so treat its default as unset
+	if image == "" || image == DefaultDroneImage {
+		image = r.DefaultImage
+	}
+	if image == "" {
+		image = DefaultDroneImage
+	}
+	if Drone.Status.UsingFallbackImage && Drone.Spec.FallbackImage != "" {
+		image = Drone.Spec.FallbackImage
+	}
+	// the CRD schema defaults it, but Drones from before that may lack it
+	restartPolicy := Drone.Spec.RestartPolicy
+	if restartPolicy == "" {
+		restartPolicy = core.RestartPolicyAlways
+	}
+
+	// remember which reschedule request the pod was created for
+	var annotations map[string]string
+	if v, ok := Drone.Annotations[experimentsv1.RescheduleAnnotation]; ok {
+		annotations = map[string]string{experimentsv1.RescheduleAnnotation: v}
+	}
+	if Drone.Spec.MeshInjection && len(r.MeshAnnotations) > 0 {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		for k, v := range r.MeshAnnotations {
+			annotations[k] = v
+		}
+	}
+
+	// label the pod after its drone and swarm, e.g. for anti-affinity, and
+	// after the spec it was built from to tell outdated pods apart; the
+	// requested pod labels can't override those
+	labels := map[string]string{}
+	for k, v := range Drone.Spec.PodLabels {
+		labels[k] = v
+	}
+	labels[experimentsv1.DroneNameLabel] = Drone.Name
+	labels[podTemplateHashLabel] = droneSpecHash(&Drone.Spec)
+	if swarm, ok := Drone.Labels[experimentsv1.SwarmNameLabel]; ok {
+		labels[experimentsv1.SwarmNameLabel] = swarm
+	}
+
+	// pin the pod to the node picked for it, or let the scheduler choose
+	// among the drone nodes. Directly assigned pods skip the scheduler, and
+	// with it every check it would make, such as taints and affinity.
+	var nodeName string
+	nodeSelector := map[string]string{hostnameLabel: dronenodename}
+	if dronenodename == "" {
+		nodeSelector = droneNodeSelector(Drone.Spec.NodeSelector, Drone.Spec.RequiredNodeLabels)
+	} else if Drone.Spec.DirectAssign {
+		nodeName, nodeSelector = dronenodename, nil
+	}
+
+	affinity := Drone.Spec.Affinity
+	if Drone.Spec.RequireColocatedWith != nil {
+		affinity = colocateWith(affinity.DeepCopy(), Drone.Spec.RequireColocatedWith)
+	}
+	// a node selector can't express the node pools, leave it to the
+	// scheduler as node affinity
+	if dronenodename == "" && len(Drone.Spec.NodePoolSelectors) > 0 {
+		affinity = inNodePools(affinity.DeepCopy(), Drone.Spec.NodePoolSelectors)
+	}
+
+	// the drones of a swarm are what gets spread
+	var spreadConstraints []core.TopologySpreadConstraint
+	if swarm, ok := Drone.Labels[experimentsv1.SwarmNameLabel]; ok && Drone.Spec.SpreadBy != "" && Drone.Spec.MaxSkew > 0 {
+		spreadConstraints = []core.TopologySpreadConstraint{{
+			MaxSkew:           Drone.Spec.MaxSkew,
+			TopologyKey:       Drone.Spec.SpreadBy,
+			WhenUnsatisfiable: core.DoNotSchedule,
+			LabelSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{experimentsv1.SwarmNameLabel: swarm},
+			},
+		}}
+	}
+
+	var tolerations []core.Toleration
+	if r.TolerateNodeTaint {
+		tolerations = []core.Toleration{droneNodeToleration}
+	}
+
+	ref := PodRefForDrone(&Drone)
 	pod := core.Pod{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:            Drone.Name,
-			Namespace:       Drone.Namespace,
+			Name:            ref.Name,
+			Namespace:       ref.Namespace,
+			Labels:          labels,
+			Annotations:     annotations,
 			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(&Drone, experimentsv1.GroupVersion.WithKind("Drone"))},
 		},
 		Spec: core.PodSpec{
-			NodeSelector: map[string]string{
-				"kubernetes.io/hostname": dronenodename,
-			},
+			NodeSelector:              nodeSelector,
+			NodeName:                  nodeName,
+			SchedulerName:             Drone.Spec.SchedulerName,
+			RestartPolicy:             restartPolicy,
+			ActiveDeadlineSeconds:     Drone.Spec.ActiveDeadlineSeconds,
+			Affinity:                  affinity,
+			ReadinessGates:            Drone.Spec.ReadinessGates,
+			Tolerations:               tolerations,
+			TopologySpreadConstraints: spreadConstraints,
+			HostAliases:               Drone.Spec.HostAliases,
+			EnableServiceLinks:        Drone.Spec.EnableServiceLinks,
 			Containers: []core.Container{
 				{
-					Name:  "drone-pod",
-					Image: "danacr/drone-pod:latest",
+					Name:                     DroneContainerName,
+					Image:                    rewriteImage(image, r.ImageRewrites),
+					StartupProbe:             Drone.Spec.StartupProbe,
+					Resources:                Drone.Spec.Resources,
+					WorkingDir:               Drone.Spec.WorkingDir,
+					TerminationMessagePath:   Drone.Spec.TerminationMessagePath,
+					TerminationMessagePolicy: Drone.Spec.TerminationMessagePolicy,
 					Env: []core.EnvVar{
 						core.EnvVar{Name: "NODE",
 							ValueFrom: &core.EnvVarSource{
@@ -148,16 +764,249 @@ func buildPod(Drone experimentsv1.Drone, dronenodename string) *core.Pod {
 			},
 		},
 	}
+	if token := Drone.Spec.ProjectedToken; token != nil {
+		addProjectedToken(&pod.Spec, token)
+	}
+	if Drone.Spec.Subdomain != "" {
+		pod.Spec.Hostname, pod.Spec.Subdomain = Drone.Name, Drone.Spec.Subdomain
+	}
+	if scrape := Drone.Spec.Scrape; scrape != nil {
+		addScrapeConfig(&pod, scrape)
+	}
+	// the webhook rejects bad hints, those slipping past it are left out
+	if errs := experimentsv1.ApplyPodSpecHints(Drone.Annotations, &pod.Spec); len(errs) > 0 {
+		r.Log.Info("ignoring invalid pod spec hints", "Drone", ref, "reason", errs.ToAggregate().Error())
+	}
+	if patch := Drone.Spec.PodSpecPatch; patch != nil {
+		if err := experimentsv1.PatchPodSpec(&pod.Spec, patch.Raw); err != nil {
+			r.Log.Info("ignoring invalid pod spec patch", "Drone", ref, "reason", err.Error())
+		}
+	}
 	return &pod
 }
 
+// addScrapeConfig annotates the pod for Prometheus to scrape its drone
+// container, and names the metrics port on it.
+func addScrapeConfig(pod *core.Pod, scrape *experimentsv1.ScrapeConfig) {
+	path := scrape.Path
+	if path == "" {
+		path = "/metrics"
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations["prometheus.io/scrape"] = "true"
+	pod.Annotations["prometheus.io/port"] = fmt.Sprint(scrape.Port)
+	pod.Annotations["prometheus.io/path"] = path
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == DroneContainerName {
+			pod.Spec.Containers[i].Ports = append(pod.Spec.Containers[i].Ports, core.ContainerPort{
+				Name:          "metrics",
+				ContainerPort: scrape.Port,
+				Protocol:      core.ProtocolTCP,
+			})
+		}
+	}
+}
+
+// projectedTokenVolume is the name of the volume holding the projected
+// service account token of a drone.
+const projectedTokenVolume = "drone-token"
+
+// addProjectedToken mounts the projected service account token into the drone
+// container of the pod spec.
+func addProjectedToken(spec *core.PodSpec, token *experimentsv1.ProjectedToken) {
+	mountPath := token.MountPath
+	if mountPath == "" {
+		mountPath = "/var/run/secrets/tokens"
+	}
+	// the volumes and mounts may still be those of the Drone, leave them be
+	spec.Volumes = append(spec.Volumes[:len(spec.Volumes):len(spec.Volumes)], core.Volume{
+		Name: projectedTokenVolume,
+		VolumeSource: core.VolumeSource{
+			Projected: &core.ProjectedVolumeSource{
+				Sources: []core.VolumeProjection{{
+					ServiceAccountToken: &core.ServiceAccountTokenProjection{
+						Audience:          token.Audience,
+						ExpirationSeconds: token.ExpirationSeconds,
+						Path:              "token",
+					},
+				}},
+			},
+		},
+	})
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == DroneContainerName {
+			mounts := spec.Containers[i].VolumeMounts
+			spec.Containers[i].VolumeMounts = append(mounts[:len(mounts):len(mounts)], core.VolumeMount{
+				Name:      projectedTokenVolume,
+				MountPath: mountPath,
+				ReadOnly:  true,
+			})
+		}
+	}
+}
+
+// inNodePools adds a node affinity to affinity keeping the drone pod on nodes
+// matching any of the label sets. Node selector terms are ORed.
+func inNodePools(affinity *core.Affinity, selectors []map[string]string) *core.Affinity {
+	if affinity == nil {
+		affinity = &core.Affinity{}
+	}
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = &core.NodeAffinity{}
+	}
+	required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil {
+		required = &core.NodeSelector{}
+	}
+	var terms []core.NodeSelectorTerm
+	for _, selector := range selectors {
+		var keys []string
+		for k := range selector {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		term := core.NodeSelectorTerm{}
+		for _, k := range keys {
+			term.MatchExpressions = append(term.MatchExpressions, core.NodeSelectorRequirement{
+				Key:      k,
+				Operator: core.NodeSelectorOpIn,
+				Values:   []string{selector[k]},
+			})
+		}
+		terms = append(terms, term)
+	}
+	// terms already present must hold as well, so each is combined with
+	// every pool
+	if len(required.NodeSelectorTerms) > 0 {
+		var combined []core.NodeSelectorTerm
+		for _, existing := range required.NodeSelectorTerms {
+			for _, term := range terms {
+				combined = append(combined, core.NodeSelectorTerm{
+					MatchExpressions: append(append([]core.NodeSelectorRequirement{}, existing.MatchExpressions...), term.MatchExpressions...),
+					MatchFields:      existing.MatchFields,
+				})
+			}
+		}
+		terms = combined
+	}
+	required.NodeSelectorTerms = terms
+	affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = required
+	return affinity
+}
+
+// colocateWith adds a pod affinity to affinity keeping the drone pod on a node
+// running a pod matched by selector.
+func colocateWith(affinity *core.Affinity, selector *metav1.LabelSelector) *core.Affinity {
+	if affinity == nil {
+		affinity = &core.Affinity{}
+	}
+	if affinity.PodAffinity == nil {
+		affinity.PodAffinity = &core.PodAffinity{}
+	}
+	affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution = append(
+		affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution,
+		core.PodAffinityTerm{
+			LabelSelector: selector,
+			TopologyKey:   hostnameLabel,
+		})
+	return affinity
+}
+
 var (
 	podOwnerKey = ".metadata.controller"
 )
 
+// InjectStopChannel is called by the manager with the channel closed on
+// shutdown.
+func (r *DroneReconciler) InjectStopChannel(stop <-chan struct{}) error {
+	r.stop = stop
+	return nil
+}
+
 // SetupWithManager stuff
 func (r *DroneReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	if err := mgr.GetFieldIndexer().IndexField(&core.Pod{}, podOwnerKey, func(rawObj runtime.Object) []string {
+	if r.Clock == nil {
+		r.Clock = clock.RealClock{}
+	}
+	gvk, err := apiutil.GVKForObject(&experimentsv1.Drone{}, mgr.GetScheme())
+	if err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(&core.Pod{}, podOwnerKey, podOwnerIndexFunc(gvk)); err != nil {
+		return err
+	}
+	if err := metrics.Registry.Register(newOccupancyCollector(mgr.GetClient(), r.Log)); err != nil {
+		return err
+	}
+
+	// freed up capacity gives the drones waiting for a node another go
+	blocked := &handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.blockedDrones)}
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&experimentsv1.Drone{}).
+		Watches(&source.Kind{Type: &core.Node{}}, blocked).
+		Watches(&source.Kind{Type: &core.Node{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.cordonedDrones),
+		}).
+		Watches(&source.Kind{Type: &core.Pod{}}, blocked).
+		WithEventFilter(ignoreStatusUpdates(&experimentsv1.Drone{}))
+	if r.NonControllerOwner {
+		b = b.Watches(&source.Kind{Type: &core.Pod{}}, &handler.EnqueueRequestForOwner{OwnerType: &experimentsv1.Drone{}})
+	} else {
+		b = b.Owns(&core.Pod{})
+	}
+	return b.Complete(r)
+}
+
+// blockedDrones maps a node or pod event to the drones waiting for a free
+// node, in the namespace of the pod or anywhere for nodes.
+func (r *DroneReconciler) blockedDrones(o handler.MapObject) []reconcile.Request {
+	drones := experimentsv1.DroneList{}
+	if err := r.List(context.Background(), &drones, client.InNamespace(o.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "failed to list blocked drones")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, d := range drones.Items {
+		if d.Status.PendingSince != nil {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: d.Namespace, Name: d.Name},
+			})
+		}
+	}
+	return requests
+}
+
+// cordonedDrones maps an event of a cordoned node to the drones flying on it.
+func (r *DroneReconciler) cordonedDrones(o handler.MapObject) []reconcile.Request {
+	node, ok := o.Object.(*core.Node)
+	if !ok || !node.Spec.Unschedulable {
+		return nil
+	}
+	pods := core.PodList{}
+	if err := r.List(context.Background(), &pods); err != nil {
+		r.Log.Error(err, "failed to list drone pods")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, p := range pods.Items {
+		if drone, ok := p.Labels[experimentsv1.DroneNameLabel]; ok && podNode(&p) == node.Name {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: p.Namespace, Name: drone},
+			})
+		}
+	}
+	return requests
+}
+
+// podOwnerIndexFunc indexes pods by the name of their controlling owner, as
+// long as that owner is of the given kind.
+func podOwnerIndexFunc(gvk schema.GroupVersionKind) func(runtime.Object) []string {
+	return func(rawObj runtime.Object) []string {
 		// grab the Deployment object, extract the owner...
 		po := rawObj.(*core.Pod)
 		owner := metav1.GetControllerOf(po)
@@ -165,26 +1014,11 @@ func (r *DroneReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			return nil
 		}
 		// ...make sure it's a Drone...
-		if owner.APIVersion != experimentsv1.GroupVersion.String() || owner.Kind != "Drone" {
+		if owner.APIVersion != gvk.GroupVersion().String() || owner.Kind != gvk.Kind {
 			return nil
 		}
 
 		// ...and if so, return it
 		return []string{owner.Name}
-	}); err != nil {
-		return err
-	}
-
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&experimentsv1.Drone{}).
-		Owns(&core.Pod{}).
-		Complete(r)
-}
-func stringInSlice(a string, list []string) bool {
-	for _, b := range list {
-		if b == a {
-			return true
-		}
 	}
-	return false
 }
@@ -0,0 +1,1477 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+func TestBuildPodStartupProbe(t *testing.T) {
+	drone := newDrone("slow")
+	drone.Spec.StartupProbe = &core.Probe{
+		Handler:          core.Handler{HTTPGet: &core.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt(8080)}},
+		FailureThreshold: 30,
+		PeriodSeconds:    10,
+	}
+	r := &DroneReconciler{Log: logf.NullLogger{}}
+	pod := r.buildPod(*drone, "node-1")
+	if got := pod.Spec.Containers[0].StartupProbe; !equality.Semantic.DeepEqual(got, drone.Spec.StartupProbe) {
+		t.Errorf("startup probe = %v, want %v", got, drone.Spec.StartupProbe)
+	}
+}
+
+func TestPodFlying(t *testing.T) {
+	started, notStarted := true, false
+	tests := []struct {
+		name    string
+		ready   core.ConditionStatus
+		started []*bool
+		want    bool
+	}{
+		{name: "ready without startup probe", ready: core.ConditionTrue, started: []*bool{nil}, want: true},
+		{name: "not ready", ready: core.ConditionFalse, started: []*bool{&started}, want: false},
+		{name: "ready before startup probe passed", ready: core.ConditionTrue, started: []*bool{&notStarted}, want: false},
+		{name: "ready after startup probe passed", ready: core.ConditionTrue, started: []*bool{&started}, want: true},
+		{name: "one container still starting", ready: core.ConditionTrue, started: []*bool{&started, &notStarted}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := dronePod("drone", "node-1", tt.ready == core.ConditionTrue)
+			for _, s := range tt.started {
+				pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, core.ContainerStatus{Started: s})
+			}
+			if got := podFlying(pod); got != tt.want {
+				t.Errorf("podFlying() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileDefersFlyingUntilStarted(t *testing.T) {
+	drone := newDrone("slow")
+	drone.Spec.StartupProbe = &core.Probe{Handler: core.Handler{Exec: &core.ExecAction{Command: []string{"true"}}}}
+	notStarted := false
+	pod := dronePod("slow", "node-1", true)
+	pod.Status.ContainerStatuses = []core.ContainerStatus{{Name: DroneContainerName, Started: &notStarted}}
+	r, _ := newDroneReconciler(drone, pod, droneNode("node-1"))
+
+	reconcileDrone(t, r, "slow")
+	if getDrone(t, r, "slow").Status.Flying {
+		t.Fatal("drone flies before its startup probe passed")
+	}
+
+	started := true
+	pod = getPod(t, r, "slow")
+	pod.Status.ContainerStatuses[0].Started = &started
+	if err := r.Update(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	reconcileDrone(t, r, "slow")
+	if !getDrone(t, r, "slow").Status.Flying {
+		t.Error("drone doesn't fly once its startup probe passed")
+	}
+}
+
+func TestPodOwnerIndexFunc(t *testing.T) {
+	gvk, err := apiutil.GVKForObject(&experimentsv1.Drone{}, scheme.Scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drone := newDrone("owner")
+	isController := true
+	tests := []struct {
+		name   string
+		owners []metav1.OwnerReference
+		want   []string
+	}{
+		{name: "owned by drone", owners: []metav1.OwnerReference{*metav1.NewControllerRef(drone, gvk)}, want: []string{"owner"}},
+		{name: "no owner"},
+		{name: "plain owner", owners: []metav1.OwnerReference{{APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind, Name: "owner"}}},
+		{name: "owned by another kind", owners: []metav1.OwnerReference{{
+			APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "owner", Controller: &isController,
+		}}},
+		{name: "owned by another version", owners: []metav1.OwnerReference{{
+			APIVersion: gvk.Group + "/v2", Kind: gvk.Kind, Name: "owner", Controller: &isController,
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := dronePod("owner", "node-1", false)
+			pod.OwnerReferences = tt.owners
+			if got := podOwnerIndexFunc(gvk)(pod); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("index = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileRecreatesDriftedPod(t *testing.T) {
+	r, _ := newDroneReconciler(newDrone("drifter"), droneNode("node-1"))
+	reconcileDrone(t, r, "drifter")
+	pod := getPod(t, r, "drifter")
+
+	// the pod is deleted with no event reaching the controller, the periodic
+	// resync reconciles the Drone regardless
+	if err := r.Delete(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	reconcileDrone(t, r, "drifter")
+	if pod := getPod(t, r, "drifter"); podNode(pod) != "node-1" {
+		t.Errorf("recreated pod is on node %q, want node-1", podNode(pod))
+	}
+}
+
+func TestPodRefForDrone(t *testing.T) {
+	drone := newDrone("logger")
+	drone.Namespace = "fleet"
+	ref := PodRefForDrone(drone)
+	if want := (client.ObjectKey{Namespace: "fleet", Name: "logger"}); ref != want {
+		t.Errorf("PodRefForDrone() = %v, want %v", ref, want)
+	}
+
+	// tooling streaming logs relies on buildPod following it
+	pod := (&DroneReconciler{Log: logf.NullLogger{}}).buildPod(*drone, "node-1")
+	if pod.Namespace != ref.Namespace || pod.Name != ref.Name {
+		t.Errorf("pod is %s/%s, want %v", pod.Namespace, pod.Name, ref)
+	}
+	if len(pod.Spec.Containers) != 1 || pod.Spec.Containers[0].Name != DroneContainerName {
+		t.Errorf("pod containers = %v, want a single %s", pod.Spec.Containers, DroneContainerName)
+	}
+}
+
+func TestReconcileTwiceKeepsPod(t *testing.T) {
+	r, _ := newDroneReconciler(newDrone("steady"), droneNode("node-1"), droneNode("node-2"))
+	reconcileDrone(t, r, "steady")
+	first := getPod(t, r, "steady")
+
+	reconcileDrone(t, r, "steady")
+	second := getPod(t, r, "steady")
+	if !equality.Semantic.DeepEqual(first.Spec, second.Spec) {
+		t.Errorf("pod spec changed on the second reconcile:\n%v\n%v", first.Spec, second.Spec)
+	}
+	if !equality.Semantic.DeepEqual(first.OwnerReferences, second.OwnerReferences) || metav1.GetControllerOf(second) == nil {
+		t.Errorf("owner references = %v, want the Drone as controller", second.OwnerReferences)
+	}
+}
+
+func TestReconcileUpdatesPodImageInPlace(t *testing.T) {
+	r, _ := newDroneReconciler(newDrone("drift"), droneNode("node-1"), droneNode("node-2"))
+	reconcileDrone(t, r, "drift")
+	node := podNode(getPod(t, r, "drift"))
+
+	drone := getDrone(t, r, "drift")
+	drone.Spec.Image = "danacr/drone-pod:v2"
+	if err := r.Update(context.Background(), drone); err != nil {
+		t.Fatal(err)
+	}
+	reconcileDrone(t, r, "drift")
+	pod := getPod(t, r, "drift")
+	if image := pod.Spec.Containers[0].Image; image != "danacr/drone-pod:v2" {
+		t.Errorf("image = %q, want the updated one", image)
+	}
+	if podNode(pod) != node {
+		t.Errorf("pod moved from %q to %q, want it to stay", node, podNode(pod))
+	}
+}
+
+// staleCacheClient misses pods until one gets created, like a cache that
+// hasn't seen them yet.
+type staleCacheClient struct {
+	client.Client
+	synced bool
+}
+
+func (c *staleCacheClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	if _, ok := obj.(*core.Pod); ok && !c.synced {
+		return apierrors.NewNotFound(core.Resource("pods"), key.Name)
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *staleCacheClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if _, ok := obj.(*core.Pod); ok {
+		c.synced = true
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestReconcilePodAlreadyExists(t *testing.T) {
+	// the pod counts against node-1, so a stale reconcile picks node-2
+	r, _ := newDroneReconciler(newDrone("racer"), dronePod("racer", "node-1", true), droneNode("node-1"), droneNode("node-2"))
+	c := r.Client
+	r.Client = &staleCacheClient{Client: c}
+
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "racer"}}); err != nil {
+		t.Fatalf("reconcile failed on an existing pod: %v", err)
+	}
+	if !getDrone(t, c, "racer").Status.Flying {
+		t.Error("drone status doesn't follow the existing pod")
+	}
+	if node := podNode(getPod(t, c, "racer")); node != "node-1" {
+		t.Errorf("pod is on node %q, want it left on node-1", node)
+	}
+}
+
+func TestReconcileOwnerReference(t *testing.T) {
+	for _, nonController := range []bool{false, true} {
+		t.Run(fmt.Sprintf("non-controller %v", nonController), func(t *testing.T) {
+			r, _ := newDroneReconciler(newDrone("owned"), droneNode("node-1"))
+			r.NonControllerOwner = nonController
+			reconcileDrone(t, r, "owned")
+
+			owners := getPod(t, r, "owned").OwnerReferences
+			if len(owners) != 1 || owners[0].Kind != "Drone" || owners[0].Name != "owned" || owners[0].UID != newDrone("owned").UID {
+				t.Fatalf("owner references = %v, want the Drone", owners)
+			}
+			if isController := owners[0].Controller != nil && *owners[0].Controller; isController == nonController {
+				t.Errorf("drone is controller = %v, want %v", isController, !nonController)
+			}
+		})
+	}
+}
+
+func TestReconcileNonControllerOwnerKeepsCoOwner(t *testing.T) {
+	isController := true
+	injector := metav1.OwnerReference{APIVersion: "mesh.example.com/v1", Kind: "Injector", Name: "mesh", UID: "mesh", Controller: &isController}
+	pod := dronePod("shared", "node-1", true)
+	pod.OwnerReferences = []metav1.OwnerReference{injector}
+	r, _ := newDroneReconciler(newDrone("shared"), pod, droneNode("node-1"))
+	r.NonControllerOwner = true
+
+	reconcileDrone(t, r, "shared")
+	reconcileDrone(t, r, "shared")
+	owners := getPod(t, r, "shared").OwnerReferences
+	if len(owners) != 2 || !equality.Semantic.DeepEqual(owners[0], injector) || owners[1].Name != "shared" || owners[1].Controller != nil {
+		t.Errorf("owner references = %v, want the injector as controller and the Drone as plain owner", owners)
+	}
+}
+
+func TestReconcileLastTransitionTime(t *testing.T) {
+	r, clock := newDroneReconciler(newDrone("timed"), dronePod("timed", "node-1", false), droneNode("node-1"))
+	reconcileDrone(t, r, "timed")
+	if got := getDrone(t, r, "timed").Status.LastTransitionTime; !got.Time.Equal(testTime) {
+		t.Fatalf("last transition time = %v, want %v", got, testTime)
+	}
+
+	clock.Step(time.Minute)
+	reconcileDrone(t, r, "timed")
+	if got := getDrone(t, r, "timed").Status.LastTransitionTime; !got.Time.Equal(testTime) {
+		t.Errorf("last transition time = %v without a transition, want it to stay at %v", got, testTime)
+	}
+
+	clock.Step(time.Minute)
+	pod := getPod(t, r, "timed")
+	pod.Status.Conditions[0].Status = core.ConditionTrue
+	if err := r.Update(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	reconcileDrone(t, r, "timed")
+	if got, want := getDrone(t, r, "timed").Status.LastTransitionTime, testTime.Add(2*time.Minute); !got.Time.Equal(want) {
+		t.Errorf("last transition time = %v once flying, want %v", got, want)
+	}
+}
+
+func TestActiveDeadlineSeconds(t *testing.T) {
+	deadline := int64(600)
+	drone := newDrone("batch")
+	drone.Spec.ActiveDeadlineSeconds = &deadline
+
+	pod := (&DroneReconciler{Log: logf.NullLogger{}}).buildPod(*drone, "node-1")
+	if got := pod.Spec.ActiveDeadlineSeconds; got == nil || *got != deadline {
+		t.Errorf("pod active deadline = %v, want %d", got, deadline)
+	}
+	copied := drone.DeepCopy()
+	*copied.Spec.ActiveDeadlineSeconds = 60
+	if *drone.Spec.ActiveDeadlineSeconds != deadline {
+		t.Error("deep copy shares the active deadline")
+	}
+
+	// the pod gets killed once past it
+	pod = dronePod("batch", "node-1", false)
+	pod.Status.Phase, pod.Status.Reason = core.PodFailed, deadlineExceededReason
+	r, _ := newDroneReconciler(drone, pod, droneNode("node-1"))
+	reconcileDrone(t, r, "batch")
+	if status := getDrone(t, r, "batch").Status; status.Phase != experimentsv1.DroneFailed || status.Reason != deadlineExceededReason {
+		t.Errorf("drone phase/reason = %s/%s, want %s/%s", status.Phase, status.Reason, experimentsv1.DroneFailed, deadlineExceededReason)
+	}
+}
+
+func TestReconcileRescheduleAnnotation(t *testing.T) {
+	r, _ := newDroneReconciler(newDrone("mover"), droneNode("node-1"), droneNode("node-2"))
+	reconcileDrone(t, r, "mover")
+	from := podNode(getPod(t, r, "mover"))
+
+	drone := getDrone(t, r, "mover")
+	drone.Annotations = map[string]string{experimentsv1.RescheduleAnnotation: "1"}
+	if err := r.Update(context.Background(), drone); err != nil {
+		t.Fatal(err)
+	}
+	// the first reconcile takes the pod away, the next one places it anew
+	reconcileDrone(t, r, "mover")
+	reconcileDrone(t, r, "mover")
+	pod := getPod(t, r, "mover")
+	to := podNode(pod)
+	if to == from {
+		t.Fatalf("drone stayed on %q, want it moved", from)
+	}
+	if pod.Annotations[experimentsv1.RescheduleAnnotation] != "1" {
+		t.Errorf("pod annotations = %v, want the reschedule request it was created for", pod.Annotations)
+	}
+
+	reconcileDrone(t, r, "mover")
+	if node := podNode(getPod(t, r, "mover")); node != to {
+		t.Errorf("drone moved to %q on the same reschedule request, want it to stay on %q", node, to)
+	}
+}
+
+func TestReconcileRescheduleWithoutOtherNode(t *testing.T) {
+	drone := newDrone("stuck")
+	drone.Annotations = map[string]string{experimentsv1.RescheduleAnnotation: "1"}
+	r, _ := newDroneReconciler(drone, dronePod("stuck", "node-1", true), droneNode("node-1"))
+
+	if result := reconcileDrone(t, r, "stuck"); result.RequeueAfter != moveRequeueDelay {
+		t.Errorf("requeue after = %v, want %v", result.RequeueAfter, moveRequeueDelay)
+	}
+	if node := podNode(getPod(t, r, "stuck")); node != "node-1" {
+		t.Errorf("drone is on %q, want it left on node-1", node)
+	}
+}
+
+func TestReconcileSkipsNodesUnderPressure(t *testing.T) {
+	for _, pressure := range []core.NodeConditionType{core.NodeMemoryPressure, core.NodeDiskPressure, core.NodePIDPressure} {
+		t.Run(string(pressure), func(t *testing.T) {
+			node := droneNode("node-1")
+			node.Status.Conditions = append(node.Status.Conditions, core.NodeCondition{Type: pressure, Status: core.ConditionTrue})
+			r, _ := newDroneReconciler(newDrone("wary"), node)
+
+			reconcileDrone(t, r, "wary")
+			if err := r.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: "wary"}, &core.Pod{}); !apierrors.IsNotFound(err) {
+				t.Fatalf("pod lookup = %v, want no pod on a node under %s", err, pressure)
+			}
+			if drone := getDrone(t, r, "wary"); drone.Status.Phase != experimentsv1.DronePending {
+				t.Errorf("drone phase = %s, want %s", drone.Status.Phase, experimentsv1.DronePending)
+			}
+
+			node.Status.Conditions[1].Status = core.ConditionFalse
+			if err := r.Update(context.Background(), node); err != nil {
+				t.Fatal(err)
+			}
+			reconcileDrone(t, r, "wary")
+			if node := podNode(getPod(t, r, "wary")); node != "node-1" {
+				t.Errorf("pod is on %q once the pressure cleared, want node-1", node)
+			}
+		})
+	}
+}
+
+func TestDroneSpecHash(t *testing.T) {
+	deadline := int64(60)
+	base := experimentsv1.DroneSpec{Image: "danacr/drone-pod:v1", NodeSelector: map[string]string{"pool": "blue", "zone": "a"}}
+	hash := droneSpecHash(&base)
+	if copied := base.DeepCopy(); droneSpecHash(copied) != hash {
+		t.Error("hash differs for an equal spec")
+	}
+	if droneSpecHash(&base) != hash {
+		t.Error("hash differs between calls")
+	}
+	if msgs := validation.IsValidLabelValue(hash); len(msgs) > 0 {
+		t.Errorf("hash %q isn't a valid label value: %v", hash, msgs)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*experimentsv1.DroneSpec)
+	}{
+		{name: "image", change: func(s *experimentsv1.DroneSpec) { s.Image = "danacr/drone-pod:v2" }},
+		{name: "node selector", change: func(s *experimentsv1.DroneSpec) { s.NodeSelector["zone"] = "b" }},
+		{name: "active deadline", change: func(s *experimentsv1.DroneSpec) { s.ActiveDeadlineSeconds = &deadline }},
+		{name: "max per node", change: func(s *experimentsv1.DroneSpec) { s.MaxPerNode = 2 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := base.DeepCopy()
+			tt.change(spec)
+			if droneSpecHash(spec) == hash {
+				t.Errorf("hash unchanged after changing the %s", tt.name)
+			}
+		})
+	}
+
+	drone := newDrone("hashed")
+	drone.Spec = base
+	pod := (&DroneReconciler{Log: logf.NullLogger{}}).buildPod(*drone, "node-1")
+	if got := pod.Labels[podTemplateHashLabel]; got != hash {
+		t.Errorf("pod template hash label = %q, want %q", got, hash)
+	}
+}
+
+func TestBuildPodDefaults(t *testing.T) {
+	tests := []struct {
+		name              string
+		spec              experimentsv1.DroneSpec
+		defaultImage      string
+		wantImage         string
+		wantRestartPolicy core.RestartPolicy
+	}{
+		{name: "empty spec", wantImage: DefaultDroneImage, wantRestartPolicy: core.RestartPolicyAlways},
+		{name: "reconciler default image", defaultImage: "registry.local/drone:v1",
+			wantImage: "registry.local/drone:v1", wantRestartPolicy: core.RestartPolicyAlways},
+		{name: "schema default image", defaultImage: "registry.local/drone:v1",
+			spec:      experimentsv1.DroneSpec{Image: DefaultDroneImage, RestartPolicy: core.RestartPolicyAlways},
+			wantImage: "registry.local/drone:v1", wantRestartPolicy: core.RestartPolicyAlways},
+		{name: "schema default image without reconciler default",
+			spec:      experimentsv1.DroneSpec{Image: DefaultDroneImage},
+			wantImage: DefaultDroneImage, wantRestartPolicy: core.RestartPolicyAlways},
+		{name: "explicit values", defaultImage: "registry.local/drone:v1",
+			spec:      experimentsv1.DroneSpec{Image: "danacr/drone-pod:v2", RestartPolicy: core.RestartPolicyNever},
+			wantImage: "danacr/drone-pod:v2", wantRestartPolicy: core.RestartPolicyNever},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drone := newDrone("plain")
+			drone.Spec = tt.spec
+			r := &DroneReconciler{Log: logf.NullLogger{}, DefaultImage: tt.defaultImage}
+			pod := r.buildPod(*drone, "node-1")
+			if len(pod.Spec.Containers) != 1 || pod.Spec.Containers[0].Name != DroneContainerName {
+				t.Fatalf("containers = %v, want a single %s", pod.Spec.Containers, DroneContainerName)
+			}
+			if got := pod.Spec.Containers[0].Image; got != tt.wantImage {
+				t.Errorf("image = %q, want %q", got, tt.wantImage)
+			}
+			if got := pod.Spec.RestartPolicy; got != tt.wantRestartPolicy {
+				t.Errorf("restart policy = %q, want %q", got, tt.wantRestartPolicy)
+			}
+		})
+	}
+}
+
+func TestPodFlyingReadinessGates(t *testing.T) {
+	const registered = core.PodConditionType("example.com/registered")
+	gates := []core.PodReadinessGate{{ConditionType: registered}}
+	tests := []struct {
+		name  string
+		ready bool
+		gate  core.ConditionStatus
+		want  bool
+	}{
+		{name: "ready and registered", ready: true, gate: core.ConditionTrue, want: true},
+		{name: "ready, not registered", ready: true, gate: core.ConditionFalse},
+		{name: "ready, gate not set yet", ready: true},
+		{name: "registered, not ready", gate: core.ConditionTrue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := dronePod("gated", "node-1", tt.ready)
+			pod.Spec.ReadinessGates = gates
+			if tt.gate != "" {
+				pod.Status.Conditions = append(pod.Status.Conditions, core.PodCondition{Type: registered, Status: tt.gate})
+			}
+			if got := podFlying(pod); got != tt.want {
+				t.Errorf("podFlying() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	drone := newDrone("gated")
+	drone.Spec.ReadinessGates = gates
+	pod := (&DroneReconciler{Log: logf.NullLogger{}}).buildPod(*drone, "node-1")
+	if !equality.Semantic.DeepEqual(pod.Spec.ReadinessGates, gates) {
+		t.Errorf("pod readiness gates = %v, want %v", pod.Spec.ReadinessGates, gates)
+	}
+}
+
+func TestBlockedDronesGetScheduledOnceFreed(t *testing.T) {
+	occupant := dronePod("occupant", "node-1", true)
+	r, _ := newDroneReconciler(newDrone("blocked"), newDrone("occupant"), occupant, droneNode("node-1"))
+	reconcileDrone(t, r, "occupant")
+	reconcileDrone(t, r, "blocked")
+	if getDrone(t, r, "blocked").Status.PendingSince == nil {
+		t.Fatal("drone isn't pending with no free node")
+	}
+
+	// the occupant's pod goes away, e.g. as its Drone got deleted
+	if err := r.Delete(context.Background(), occupant); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete(context.Background(), getDrone(t, r, "occupant")); err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range []handler.MapObject{
+		{Meta: occupant, Object: occupant},
+		{Meta: droneNode("node-1"), Object: droneNode("node-1")},
+	} {
+		requests := r.blockedDrones(o)
+		if want := []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "blocked"}}}; !reflect.DeepEqual(requests, want) {
+			t.Fatalf("requests = %v, want %v", requests, want)
+		}
+	}
+
+	reconcileDrone(t, r, "blocked")
+	if node := podNode(getPod(t, r, "blocked")); node != "node-1" {
+		t.Errorf("drone is on %q, want the freed node-1", node)
+	}
+	if getDrone(t, r, "blocked").Status.PendingSince != nil {
+		t.Error("drone is still pending once scheduled")
+	}
+}
+
+func TestReconcileSchedulerName(t *testing.T) {
+	tests := []struct {
+		name         string
+		deferred     bool
+		scheduler    string
+		wantSelector map[string]string
+	}{
+		{name: "pinned", scheduler: "custom", wantSelector: map[string]string{hostnameLabel: "node-1"}},
+		{name: "deferred", deferred: true, scheduler: "custom", wantSelector: map[string]string{droneNodeLabel: "drone"}},
+		// only drones naming a scheduler are left to it
+		{name: "deferred without scheduler", deferred: true, wantSelector: map[string]string{hostnameLabel: "node-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drone := newDrone("scheduled")
+			drone.Spec.SchedulerName = tt.scheduler
+			r, _ := newDroneReconciler(drone, droneNode("node-1"))
+			r.DeferToScheduler = tt.deferred
+
+			reconcileDrone(t, r, "scheduled")
+			pod := getPod(t, r, "scheduled")
+			if pod.Spec.SchedulerName != tt.scheduler {
+				t.Errorf("scheduler name = %q, want %q", pod.Spec.SchedulerName, tt.scheduler)
+			}
+			if !reflect.DeepEqual(pod.Spec.NodeSelector, tt.wantSelector) {
+				t.Errorf("node selector = %v, want %v", pod.Spec.NodeSelector, tt.wantSelector)
+			}
+		})
+	}
+}
+
+// pullFailingPod returns a drone pod whose image fails to pull.
+func pullFailingPod(name, node string) *core.Pod {
+	pod := dronePod(name, node, false)
+	pod.Status.Phase = core.PodPending
+	pod.Status.ContainerStatuses = []core.ContainerStatus{{
+		Name:  DroneContainerName,
+		State: core.ContainerState{Waiting: &core.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+	}}
+	return pod
+}
+
+func TestReconcileWaitsOnFakeClock(t *testing.T) {
+	drone := newDrone("patient")
+	drone.Spec.Image, drone.Spec.FallbackImage = "example/missing:v1", "danacr/drone-pod:v1"
+	r, clock := newDroneReconciler(drone, pullFailingPod("patient", "node-1"), droneNode("node-1"))
+	r.ImageFallbackAfter = 5 * time.Minute
+
+	clock.Step(5 * time.Minute)
+	reconcileDrone(t, r, "patient")
+	if getDrone(t, r, "patient").Status.UsingFallbackImage {
+		t.Fatal("drone fell back before its pod failed to pull for longer than the grace period")
+	}
+
+	clock.Step(time.Second)
+	reconcileDrone(t, r, "patient")
+	if !getDrone(t, r, "patient").Status.UsingFallbackImage {
+		t.Error("drone didn't fall back once the grace period passed")
+	}
+}
+
+func TestReconcileRequiredNodeLabels(t *testing.T) {
+	plain, gpu, foreign := droneNode("plain"), droneNode("gpu"), droneNode("foreign")
+	gpu.Labels["gpu"] = "true"
+	// carries the label, but isn't a drone node
+	foreign.Labels = map[string]string{"gpu": "true", hostnameLabel: "foreign"}
+	drone := newDrone("picky")
+	drone.Spec.RequiredNodeLabels = map[string]string{"gpu": "true"}
+	r, _ := newDroneReconciler(drone, plain, foreign, gpu)
+
+	reconcileDrone(t, r, "picky")
+	if node := podNode(getPod(t, r, "picky")); node != "gpu" {
+		t.Errorf("drone is on %q, want the gpu drone node", node)
+	}
+
+	copied := drone.DeepCopy()
+	copied.Spec.RequiredNodeLabels["gpu"] = "false"
+	if drone.Spec.RequiredNodeLabels["gpu"] != "true" {
+		t.Error("deep copy shares the required node labels")
+	}
+}
+
+func TestReconcileTTLAfterFinished(t *testing.T) {
+	ttl := int32(60)
+	drone := newDrone("once")
+	drone.Spec.TTLSecondsAfterFinished = &ttl
+	pod := dronePod("once", "node-1", false)
+	pod.Status.Phase = core.PodSucceeded
+	r, clock := newDroneReconciler(drone, pod, droneNode("node-1"))
+
+	if result := reconcileDrone(t, r, "once"); result.RequeueAfter != time.Minute {
+		t.Errorf("requeue after = %v, want the full TTL", result.RequeueAfter)
+	}
+	clock.Step(40 * time.Second)
+	if result := reconcileDrone(t, r, "once"); result.RequeueAfter != 20*time.Second {
+		t.Errorf("requeue after = %v, want what's left of the TTL", result.RequeueAfter)
+	}
+	getDrone(t, r, "once")
+
+	clock.Step(20 * time.Second)
+	reconcileDrone(t, r, "once")
+	err := r.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: "once"}, &experimentsv1.Drone{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("drone lookup = %v, want it deleted past its TTL", err)
+	}
+}
+
+func TestReconcileTTLKeepsRunningDrones(t *testing.T) {
+	ttl := int32(0)
+	drone := newDrone("running")
+	drone.Spec.TTLSecondsAfterFinished = &ttl
+	r, clock := newDroneReconciler(drone, dronePod("running", "node-1", true), droneNode("node-1"))
+
+	clock.Step(time.Hour)
+	if result := reconcileDrone(t, r, "running"); result.RequeueAfter != 0 {
+		t.Errorf("requeue after = %v, want none for a running drone", result.RequeueAfter)
+	}
+	getDrone(t, r, "running")
+}
+
+func TestReconcileColocation(t *testing.T) {
+	cache := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: testNamespace, Labels: map[string]string{"app": "cache"}},
+		Spec:       core.PodSpec{NodeName: "node-2"},
+		Status:     core.PodStatus{Phase: core.PodRunning},
+	}
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "cache"}}
+	drone := newDrone("near")
+	drone.Spec.RequireColocatedWith = selector
+	// the cache takes up a slot of its node
+	drone.Spec.MaxPerNode = 2
+	r, _ := newDroneReconciler(drone, cache, droneNode("node-1"), droneNode("node-2"), droneNode("node-3"))
+
+	reconcileDrone(t, r, "near")
+	pod := getPod(t, r, "near")
+	if node := podNode(pod); node != "node-2" {
+		t.Errorf("drone is on %q, want the node of the cache", node)
+	}
+	terms := pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	want := []core.PodAffinityTerm{{LabelSelector: selector, TopologyKey: hostnameLabel}}
+	if !equality.Semantic.DeepEqual(terms, want) {
+		t.Errorf("pod affinity = %v, want %v", terms, want)
+	}
+}
+
+func TestReconcileColocationWithoutMatch(t *testing.T) {
+	alone := newDrone("alone")
+	alone.Spec.RequireColocatedWith = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "cache"}}
+	invalid := newDrone("invalid")
+	invalid.Spec.RequireColocatedWith = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "app", Operator: "Near"},
+	}}
+	r, _ := newDroneReconciler(alone, invalid, droneNode("node-1"))
+
+	reconcileDrone(t, r, "alone")
+	if reason := getDrone(t, r, "alone").Status.Reason; reason != reasonNoDroneNodes {
+		t.Errorf("status reason = %q, want %q", reason, reasonNoDroneNodes)
+	}
+	reconcileDrone(t, r, "invalid")
+	expectEvent(t, r.Recorder.(*record.FakeRecorder), "Warning "+reasonInvalidSpec+" ")
+	for _, name := range []string{"alone", "invalid"} {
+		err := r.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: name}, &core.Pod{})
+		if !apierrors.IsNotFound(err) {
+			t.Errorf("pod lookup of %s = %v, want no pod", name, err)
+		}
+	}
+}
+
+func TestReconcileMovesOffCordonedNode(t *testing.T) {
+	r, _ := newDroneReconciler(newDrone("evacuee"), droneNode("node-1"), droneNode("node-2"))
+	reconcileDrone(t, r, "evacuee")
+	from := podNode(getPod(t, r, "evacuee"))
+
+	node := &core.Node{}
+	if err := r.Get(context.Background(), client.ObjectKey{Name: from}, node); err != nil {
+		t.Fatal(err)
+	}
+	node.Spec.Unschedulable = true
+	updateObject(t, r, node)
+	want := []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "evacuee"}}}
+	if requests := r.cordonedDrones(handler.MapObject{Meta: node, Object: node}); !reflect.DeepEqual(requests, want) {
+		t.Errorf("cordoned drones = %v, want %v", requests, want)
+	}
+
+	// the first reconcile takes the pod away, the next one places it anew
+	reconcileDrone(t, r, "evacuee")
+	reconcileDrone(t, r, "evacuee")
+	if to := podNode(getPod(t, r, "evacuee")); to == from {
+		t.Errorf("drone stayed on cordoned %q, want it moved", from)
+	}
+}
+
+func TestReconcileStaysOnCordonedNodeWithoutOtherNode(t *testing.T) {
+	node := droneNode("node-1")
+	node.Spec.Unschedulable = true
+	r, _ := newDroneReconciler(newDrone("stranded"), dronePod("stranded", "node-1", true), node, droneNode("node-2"),
+		dronePod("occupant", "node-2", true))
+
+	if result := reconcileDrone(t, r, "stranded"); result.RequeueAfter != moveRequeueDelay {
+		t.Errorf("requeue after = %v, want %v", result.RequeueAfter, moveRequeueDelay)
+	}
+	if node := podNode(getPod(t, r, "stranded")); node != "node-1" {
+		t.Errorf("drone is on %q, want it left on the cordoned node", node)
+	}
+	if requests := r.cordonedDrones(handler.MapObject{Meta: droneNode("node-2"), Object: droneNode("node-2")}); requests != nil {
+		t.Errorf("drones of a schedulable node = %v, want none", requests)
+	}
+}
+
+func TestReconcileStrandedDroneFollowsItsPod(t *testing.T) {
+	node := droneNode("node-1")
+	node.Spec.Unschedulable = true
+	drone := newDrone("stranded")
+	drone.Status.Phase = experimentsv1.DronePending
+	r, _ := newDroneReconciler(drone, dronePod("stranded", "node-1", true), node)
+
+	if result := reconcileDrone(t, r, "stranded"); result.RequeueAfter != moveRequeueDelay {
+		t.Errorf("requeue after = %v, want %v", result.RequeueAfter, moveRequeueDelay)
+	}
+	if status := getDrone(t, r, "stranded").Status; status.Phase != experimentsv1.DroneRunning || !status.Flying {
+		t.Errorf("phase/flying = %s/%v, want the status of the pod on the cordoned node", status.Phase, status.Flying)
+	}
+}
+
+func TestReconcileLeavesFinishedPodOnCordonedNode(t *testing.T) {
+	node := droneNode("node-1")
+	node.Spec.Unschedulable = true
+	ttl := int32(60)
+	drone := newDrone("done")
+	drone.Spec.RestartPolicy = core.RestartPolicyNever
+	drone.Spec.TTLSecondsAfterFinished = &ttl
+	pod := dronePod("done", "node-1", false)
+	pod.Status.Phase = core.PodSucceeded
+	r, clock := newDroneReconciler(drone, pod, node, droneNode("node-2"))
+
+	if result := reconcileDrone(t, r, "done"); result.RequeueAfter != time.Minute {
+		t.Errorf("requeue after = %v, want the TTL of %v", result.RequeueAfter, time.Minute)
+	}
+	if node := podNode(getPod(t, r, "done")); node != "node-1" {
+		t.Errorf("finished drone pod is on %q, want it left on node-1 rather than run again", node)
+	}
+	if phase := getDrone(t, r, "done").Status.Phase; phase != experimentsv1.DroneSucceeded {
+		t.Errorf("phase = %s, want %s", phase, experimentsv1.DroneSucceeded)
+	}
+
+	clock.Step(time.Minute)
+	reconcileDrone(t, r, "done")
+	if err := r.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: "done"}, &experimentsv1.Drone{}); !apierrors.IsNotFound(err) {
+		t.Errorf("drone lookup = %v, want it deleted past its TTL", err)
+	}
+}
+
+func TestBuildPodHostAliases(t *testing.T) {
+	drone := newDrone("offline")
+	drone.Spec.HostAliases = []core.HostAlias{
+		{IP: "10.0.0.10", Hostnames: []string{"registry.internal", "registry"}},
+		{IP: "10.0.0.11", Hostnames: []string{"telemetry.internal"}},
+	}
+	r, _ := newDroneReconciler(drone, droneNode("node-1"))
+
+	reconcileDrone(t, r, "offline")
+	if got := getPod(t, r, "offline").Spec.HostAliases; !equality.Semantic.DeepEqual(got, drone.Spec.HostAliases) {
+		t.Errorf("host aliases = %v, want %v", got, drone.Spec.HostAliases)
+	}
+
+	copied := drone.DeepCopy()
+	copied.Spec.HostAliases[0].Hostnames[0] = "elsewhere"
+	if drone.Spec.HostAliases[0].Hostnames[0] != "registry.internal" {
+		t.Error("deep copy shares the host aliases")
+	}
+}
+
+func TestBuildPodWorkingDir(t *testing.T) {
+	for _, dir := range []string{"", "/srv/drone"} {
+		drone := newDrone("grounded")
+		drone.Spec.WorkingDir = dir
+		r := &DroneReconciler{Log: logf.NullLogger{}}
+		if got := r.buildPod(*drone, "node-1").Spec.Containers[0].WorkingDir; got != dir {
+			t.Errorf("working dir = %q, want %q", got, dir)
+		}
+	}
+}
+
+func TestReconcileSteadyStateWritesNothing(t *testing.T) {
+	drone := newDrone("steady")
+	r, clock := newDroneReconciler(drone, droneNode("node-1"), droneNode("node-2"))
+	writes := &writeCounter{Client: r.Client}
+	r.Client = writes
+	sr := &SwarmReconciler{Client: writes, Log: logf.NullLogger{}, Scheme: scheme.Scheme, Recorder: record.NewFakeRecorder(100), Clock: clock}
+	swarm := newSwarm("steady", 2)
+	swarm.Spec.Ordinal = true
+	if err := r.Create(context.Background(), swarm); err != nil {
+		t.Fatal(err)
+	}
+
+	// settle both first
+	for i := 0; i < 2; i++ {
+		reconcileDrone(t, r, "steady")
+		reconcileSwarm(t, sr, "steady")
+	}
+	clock.Step(time.Minute)
+	writes.writes = 0
+	reconcileDrone(t, r, "steady")
+	if writes.writes != 0 {
+		t.Errorf("drone reconcile made %d writes in a steady state, want none", writes.writes)
+	}
+	reconcileSwarm(t, sr, "steady")
+	if writes.writes != 0 {
+		t.Errorf("swarm reconcile made %d writes in a steady state, want none", writes.writes)
+	}
+}
+
+func TestReconcileGPUDrones(t *testing.T) {
+	const gpu = core.ResourceName("nvidia.com/gpu")
+	gpuNode := droneNode("gpu-1")
+	gpuNode.Status.Allocatable[gpu] = resource.MustParse("1")
+	requests := core.ResourceList{gpu: resource.MustParse("1")}
+	first, second := newDrone("first"), newDrone("second")
+	for _, d := range []*experimentsv1.Drone{first, second} {
+		d.Spec.Resources = core.ResourceRequirements{Requests: requests, Limits: requests}
+		// room enough but for the GPU
+		d.Spec.MaxPerNode = 2
+	}
+	r, _ := newDroneReconciler(first, second, droneNode("plain-1"), gpuNode)
+
+	reconcileDrone(t, r, "first")
+	pod := getPod(t, r, "first")
+	if node := podNode(pod); node != "gpu-1" {
+		t.Errorf("drone is on %q, want the GPU node", node)
+	}
+	if got := pod.Spec.Containers[0].Resources; !equality.Semantic.DeepEqual(got, first.Spec.Resources) {
+		t.Errorf("container resources = %v, want %v", got, first.Spec.Resources)
+	}
+
+	reconcileDrone(t, r, "second")
+	if reason := getDrone(t, r, "second").Status.Reason; reason != reasonInsufficientCapacity {
+		t.Errorf("status reason = %q, want %q with the only GPU taken", reason, reasonInsufficientCapacity)
+	}
+}
+
+func TestReconcileFallbackImage(t *testing.T) {
+	tests := []struct {
+		reason    string
+		wantImage string
+	}{
+		{reason: "ImagePullBackOff", wantImage: "danacr/drone-pod:v1"},
+		{reason: "ErrImagePull", wantImage: "danacr/drone-pod:v1"},
+		{reason: "CrashLoopBackOff", wantImage: "example/missing:v1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			drone := newDrone("resilient")
+			drone.Spec.Image, drone.Spec.FallbackImage = "example/missing:v1", "danacr/drone-pod:v1"
+			pod := pullFailingPod("resilient", "node-1")
+			pod.Status.ContainerStatuses[0].State.Waiting.Reason = tt.reason
+			r, clock := newDroneReconciler(drone, pod, droneNode("node-1"))
+			clock.Step(time.Minute)
+
+			reconcileDrone(t, r, "resilient")
+			if got := getPod(t, r, "resilient").Spec.Containers[0].Image; got != tt.wantImage {
+				t.Errorf("pod image = %q, want %q", got, tt.wantImage)
+			}
+			fellBack := tt.wantImage == drone.Spec.FallbackImage
+			if got := getDrone(t, r, "resilient").Status.UsingFallbackImage; got != fellBack {
+				t.Errorf("using fallback image = %v, want %v", got, fellBack)
+			}
+			if fellBack {
+				expectEvent(t, r.Recorder.(*record.FakeRecorder), "Warning FallbackImage ")
+			}
+		})
+	}
+}
+
+func TestReconcileDirectAssign(t *testing.T) {
+	first, second := newDrone("first"), newDrone("second")
+	first.Spec.DirectAssign, second.Spec.DirectAssign = true, true
+	r, _ := newDroneReconciler(first, second, droneNode("node-1"), droneNode("node-2"))
+
+	reconcileDrone(t, r, "first")
+	pod := getPod(t, r, "first")
+	if pod.Spec.NodeName == "" || pod.Spec.NodeSelector != nil {
+		t.Errorf("node name = %q, node selector = %v, want only a node name", pod.Spec.NodeName, pod.Spec.NodeSelector)
+	}
+	// directly assigned pods hold on to their node all the same
+	reconcileDrone(t, r, "second")
+	if node := getPod(t, r, "second").Spec.NodeName; node == "" || node == pod.Spec.NodeName {
+		t.Errorf("second drone assigned to %q, want the other node than %q", node, pod.Spec.NodeName)
+	}
+
+	scheduled := newDrone("scheduled")
+	if spec := (&DroneReconciler{Log: logf.NullLogger{}}).buildPod(*scheduled, "node-1").Spec; spec.NodeName != "" || spec.NodeSelector[hostnameLabel] != "node-1" {
+		t.Errorf("node name = %q, node selector = %v, want only a node selector", spec.NodeName, spec.NodeSelector)
+	}
+}
+
+func TestImagePullCondition(t *testing.T) {
+	tests := []struct {
+		name        string
+		statuses    []core.ContainerStatus
+		wantStatus  core.ConditionStatus
+		wantReason  string
+		wantMessage string
+	}{
+		{name: "no status yet", wantStatus: core.ConditionUnknown, wantReason: "Pulling"},
+		{name: "creating", statuses: []core.ContainerStatus{{Name: DroneContainerName,
+			State: core.ContainerState{Waiting: &core.ContainerStateWaiting{Reason: "ContainerCreating"}}}},
+			wantStatus: core.ConditionUnknown, wantReason: "Pulling"},
+		{name: "pull failing", statuses: []core.ContainerStatus{{Name: DroneContainerName,
+			State: core.ContainerState{Waiting: &core.ContainerStateWaiting{Reason: "ErrImagePull", Message: "manifest unknown"}}}},
+			wantStatus: core.ConditionFalse, wantReason: "ErrImagePull", wantMessage: "manifest unknown"},
+		{name: "pulled, crashing", statuses: []core.ContainerStatus{{Name: DroneContainerName, ImageID: "docker-pullable://danacr/drone-pod@sha256:0123",
+			State: core.ContainerState{Waiting: &core.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}}},
+			wantStatus: core.ConditionTrue, wantReason: "Pulled"},
+		{name: "running", statuses: []core.ContainerStatus{{Name: DroneContainerName,
+			State: core.ContainerState{Running: &core.ContainerStateRunning{}}}},
+			wantStatus: core.ConditionTrue, wantReason: "Pulled"},
+		{name: "sidecar failing", statuses: []core.ContainerStatus{{Name: "sidecar",
+			State: core.ContainerState{Waiting: &core.ContainerStateWaiting{Reason: "ImagePullBackOff"}}}},
+			wantStatus: core.ConditionUnknown, wantReason: "Pulling"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &core.Pod{Status: core.PodStatus{ContainerStatuses: tt.statuses}}
+			status, reason, message := imagePullCondition(pod)
+			if status != tt.wantStatus || reason != tt.wantReason || message != tt.wantMessage {
+				t.Errorf("condition = %s %q %q, want %s %q %q", status, reason, message, tt.wantStatus, tt.wantReason, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestReconcileImagePulledCondition(t *testing.T) {
+	pod := pullFailingPod("pulling", "node-1")
+	r, clock := newDroneReconciler(newDrone("pulling"), pod, droneNode("node-1"))
+	condition := func() experimentsv1.DroneCondition {
+		t.Helper()
+		for _, c := range getDrone(t, r, "pulling").Status.Conditions {
+			if c.Type == experimentsv1.DroneImagePulled {
+				return c
+			}
+		}
+		t.Fatal("drone has no ImagePulled condition")
+		return experimentsv1.DroneCondition{}
+	}
+
+	reconcileDrone(t, r, "pulling")
+	if c := condition(); c.Status != core.ConditionFalse || c.Reason != "ImagePullBackOff" {
+		t.Errorf("condition = %s %s, want False ImagePullBackOff", c.Status, c.Reason)
+	}
+
+	clock.Step(time.Minute)
+	pod = getPod(t, r, "pulling")
+	pod.Status.ContainerStatuses[0].State = core.ContainerState{Running: &core.ContainerStateRunning{}}
+	updateObject(t, r, pod)
+	reconcileDrone(t, r, "pulling")
+	if c := condition(); c.Status != core.ConditionTrue || !c.LastTransitionTime.Time.Equal(clock.Now()) {
+		t.Errorf("condition = %s since %v, want True since %v", c.Status, c.LastTransitionTime, clock.Now())
+	}
+}
+
+func TestReconcilePacksByResources(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   experimentsv1.DroneSpec
+		cpu    string
+		wantOn int
+	}{
+		{name: "count allows more", spec: experimentsv1.DroneSpec{MaxPerNode: 3}, cpu: "1500m", wantOn: 2},
+		{name: "packed by resources", spec: experimentsv1.DroneSpec{PackByResources: true}, cpu: "1500m", wantOn: 2},
+		{name: "packed beyond one", spec: experimentsv1.DroneSpec{PackByResources: true}, cpu: "500m", wantOn: 3},
+		{name: "packed up to a count", spec: experimentsv1.DroneSpec{PackByResources: true, MaxPerNode: 2}, cpu: "500m", wantOn: 2},
+	}
+	names := []string{"first", "second", "third"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objs []runtime.Object
+			for _, name := range names {
+				drone := newDrone(name)
+				drone.Spec = *tt.spec.DeepCopy()
+				drone.Spec.Resources.Requests = core.ResourceList{core.ResourceCPU: resource.MustParse(tt.cpu)}
+				objs = append(objs, drone)
+			}
+			r, _ := newDroneReconciler(append(objs, droneNode("node-1"))...)
+
+			for _, name := range names {
+				reconcileDrone(t, r, name)
+			}
+			for i, name := range names {
+				err := r.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: name}, &core.Pod{})
+				if placed := err == nil; placed != (i < tt.wantOn) {
+					t.Errorf("drone %s placed = %v, want %d of 3 placed on a node with 4 CPUs", name, placed, tt.wantOn)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildPodSpecHints(t *testing.T) {
+	drone := newDrone("hinted")
+	drone.Annotations = map[string]string{
+		experimentsv1.PodSpecAnnotationPrefix + "priorityClassName":             "drones-high",
+		experimentsv1.PodSpecAnnotationPrefix + "terminationGracePeriodSeconds": "5",
+		// slipped past the webhook
+		experimentsv1.PodSpecAnnotationPrefix + "hostNetwork": "true",
+	}
+	r := &DroneReconciler{Log: logf.NullLogger{}}
+	spec := r.buildPod(*drone, "node-1").Spec
+	if spec.PriorityClassName != "drones-high" || spec.TerminationGracePeriodSeconds == nil || *spec.TerminationGracePeriodSeconds != 5 {
+		t.Errorf("priority class = %q, grace period = %v, want the hinted ones", spec.PriorityClassName, spec.TerminationGracePeriodSeconds)
+	}
+	if spec.HostNetwork {
+		t.Error("pod is on the host network, want the unsupported hint left out")
+	}
+}
+
+func TestReconcileProjectedToken(t *testing.T) {
+	expiration := int64(3600)
+	for _, tc := range []struct {
+		name      string
+		token     *experimentsv1.ProjectedToken
+		mountPath string
+	}{
+		{name: "without", token: nil},
+		{name: "default", token: &experimentsv1.ProjectedToken{Audience: "vault"}, mountPath: "/var/run/secrets/tokens"},
+		{name: "custom", token: &experimentsv1.ProjectedToken{
+			Audience: "sts.example.com", ExpirationSeconds: &expiration, MountPath: "/identity",
+		}, mountPath: "/identity"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			drone := newDrone("tokened")
+			drone.Spec.ProjectedToken = tc.token
+			r, _ := newDroneReconciler(drone, droneNode("node-1"))
+
+			reconcileDrone(t, r, "tokened")
+			pod := getPod(t, r, "tokened")
+			var volume *core.Volume
+			for i := range pod.Spec.Volumes {
+				if pod.Spec.Volumes[i].Name == projectedTokenVolume {
+					volume = &pod.Spec.Volumes[i]
+				}
+			}
+			var mount *core.VolumeMount
+			for i, m := range pod.Spec.Containers[0].VolumeMounts {
+				if m.Name == projectedTokenVolume {
+					mount = &pod.Spec.Containers[0].VolumeMounts[i]
+				}
+			}
+			if tc.token == nil {
+				if volume != nil || mount != nil {
+					t.Errorf("got token volume %v mounted at %v, want none", volume, mount)
+				}
+				return
+			}
+
+			if volume == nil || volume.Projected == nil || len(volume.Projected.Sources) != 1 {
+				t.Fatalf("token volume = %v, want a projected volume", volume)
+			}
+			projection := volume.Projected.Sources[0].ServiceAccountToken
+			want := &core.ServiceAccountTokenProjection{
+				Audience: tc.token.Audience, ExpirationSeconds: tc.token.ExpirationSeconds, Path: "token",
+			}
+			if !equality.Semantic.DeepEqual(projection, want) {
+				t.Errorf("token projection = %v, want %v", projection, want)
+			}
+			if mount == nil || mount.MountPath != tc.mountPath || !mount.ReadOnly {
+				t.Errorf("token mount = %v, want it read-only at %s", mount, tc.mountPath)
+			}
+			if len(pod.Spec.Volumes) != 1 || len(pod.Spec.Containers[0].VolumeMounts) != 1 {
+				t.Errorf("got volumes %v mounted at %v, want only the token",
+					pod.Spec.Volumes, pod.Spec.Containers[0].VolumeMounts)
+			}
+		})
+	}
+}
+
+func TestReconcileScrapeConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		scrape      *experimentsv1.ScrapeConfig
+		annotations map[string]string
+		ports       []core.ContainerPort
+	}{
+		{name: "without"},
+		{
+			name:   "default path",
+			scrape: &experimentsv1.ScrapeConfig{Port: 9090},
+			annotations: map[string]string{
+				"prometheus.io/scrape": "true", "prometheus.io/port": "9090", "prometheus.io/path": "/metrics",
+			},
+			ports: []core.ContainerPort{{Name: "metrics", ContainerPort: 9090, Protocol: core.ProtocolTCP}},
+		},
+		{
+			name:   "custom path",
+			scrape: &experimentsv1.ScrapeConfig{Port: 8443, Path: "/stats"},
+			annotations: map[string]string{
+				"prometheus.io/scrape": "true", "prometheus.io/port": "8443", "prometheus.io/path": "/stats",
+			},
+			ports: []core.ContainerPort{{Name: "metrics", ContainerPort: 8443, Protocol: core.ProtocolTCP}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			drone := newDrone("scraped")
+			drone.Spec.Scrape = tc.scrape
+			r, _ := newDroneReconciler(drone, droneNode("node-1"))
+
+			reconcileDrone(t, r, "scraped")
+			pod := getPod(t, r, "scraped")
+			var got map[string]string
+			for k, v := range pod.Annotations {
+				if strings.HasPrefix(k, "prometheus.io/") {
+					if got == nil {
+						got = map[string]string{}
+					}
+					got[k] = v
+				}
+			}
+			if !reflect.DeepEqual(got, tc.annotations) {
+				t.Errorf("scrape annotations = %v, want %v", got, tc.annotations)
+			}
+			if got := pod.Spec.Containers[0].Ports; !equality.Semantic.DeepEqual(got, tc.ports) {
+				t.Errorf("container ports = %v, want %v", got, tc.ports)
+			}
+		})
+	}
+}
+
+// drainEvents returns the events recorded so far.
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestReconcileRecreatesMissingPod(t *testing.T) {
+	// the pod went away while no controller was running
+	drone := newDrone("orphan")
+	drone.Status.Phase, drone.Status.Flying = experimentsv1.DroneRunning, true
+	r, _ := newDroneReconciler(drone, droneNode("node-1"))
+
+	reconcileDrone(t, r, "orphan")
+	getPod(t, r, "orphan")
+	expectEvent(t, r.Recorder.(*record.FakeRecorder), "Warning PodMissing")
+}
+
+func TestReconcileMovedPodIsNotMissing(t *testing.T) {
+	drone := newDrone("evacuee")
+	drone.Status.Phase, drone.Status.Flying = experimentsv1.DroneRunning, true
+	node := droneNode("node-1")
+	node.Spec.Unschedulable = true
+	r, _ := newDroneReconciler(drone, dronePod("evacuee", "node-1", true), node, droneNode("node-2"))
+
+	reconcileDrone(t, r, "evacuee")
+	reconcileDrone(t, r, "evacuee")
+	if node := podNode(getPod(t, r, "evacuee")); node != "node-2" {
+		t.Fatalf("drone is on %q, want it moved to node-2", node)
+	}
+	for _, event := range drainEvents(r.Recorder.(*record.FakeRecorder)) {
+		if strings.HasPrefix(event, "Warning PodMissing") {
+			t.Errorf("got event %q for a pod the controller moved", event)
+		}
+	}
+}
+
+func TestBuildPodSubdomain(t *testing.T) {
+	for _, tc := range []struct {
+		subdomain, hostname string
+	}{
+		{subdomain: "", hostname: ""},
+		{subdomain: "fleet", hostname: "fleet-0"},
+	} {
+		drone := newDrone("fleet-0")
+		drone.Spec.Subdomain = tc.subdomain
+		r := &DroneReconciler{Log: logf.NullLogger{}}
+		spec := r.buildPod(*drone, "node-1").Spec
+		if spec.Subdomain != tc.subdomain || spec.Hostname != tc.hostname {
+			t.Errorf("subdomain/hostname = %q/%q, want %q/%q", spec.Subdomain, spec.Hostname, tc.subdomain, tc.hostname)
+		}
+	}
+}
+
+func TestBuildPodTerminationMessage(t *testing.T) {
+	for _, tc := range []struct {
+		path   string
+		policy core.TerminationMessagePolicy
+	}{
+		// left to the defaults of the API server
+		{},
+		{policy: core.TerminationMessageFallbackToLogsOnError},
+		{path: "/var/log/drone/exit", policy: core.TerminationMessageReadFile},
+	} {
+		drone := newDrone("grounded")
+		drone.Spec.TerminationMessagePath, drone.Spec.TerminationMessagePolicy = tc.path, tc.policy
+		r := &DroneReconciler{Log: logf.NullLogger{}}
+		container := r.buildPod(*drone, "node-1").Spec.Containers[0]
+		if container.TerminationMessagePath != tc.path || container.TerminationMessagePolicy != tc.policy {
+			t.Errorf("termination message = %q/%q, want %q/%q",
+				container.TerminationMessagePath, container.TerminationMessagePolicy, tc.path, tc.policy)
+		}
+	}
+}
+
+func TestReconcileDefaultImage(t *testing.T) {
+	pinned := newDrone("pinned")
+	pinned.Spec.Image = "danacr/drone-pod:v2"
+	r, _ := newDroneReconciler(newDrone("plain"), pinned, droneNode("node-1"), droneNode("node-2"))
+	r.DefaultImage = "registry.local/drone:v1"
+
+	for name, want := range map[string]string{"plain": "registry.local/drone:v1", "pinned": "danacr/drone-pod:v2"} {
+		reconcileDrone(t, r, name)
+		if got := getPod(t, r, name).Spec.Containers[0].Image; got != want {
+			t.Errorf("image of %s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestReconcilePodSpecPatch(t *testing.T) {
+	drone := newDrone("patched")
+	drone.Spec.PodSpecPatch = &runtime.RawExtension{Raw: []byte(fmt.Sprintf(
+		`{"shareProcessNamespace":true,"containers":[{"name":%q,"stdin":true},{"name":"sidecar","image":"busybox"}]}`,
+		DroneContainerName))}
+	r, _ := newDroneReconciler(drone, droneNode("node-1"))
+
+	// the second pass brings the existing pod in line
+	reconcileDrone(t, r, "patched")
+	reconcileDrone(t, r, "patched")
+	pod := getPod(t, r, "patched")
+	if share := pod.Spec.ShareProcessNamespace; share == nil || !*share {
+		t.Errorf("share process namespace = %v, want it patched in", share)
+	}
+	images := map[string]string{}
+	for _, c := range pod.Spec.Containers {
+		images[c.Name] = c.Image
+		if c.Name == DroneContainerName && !c.Stdin {
+			t.Errorf("drone container = %+v, want it patched in place", c)
+		}
+	}
+	// the sidecar lands ahead of the drone container
+	if want := map[string]string{DroneContainerName: DefaultDroneImage, "sidecar": "busybox"}; !reflect.DeepEqual(images, want) {
+		t.Errorf("container images = %v, want %v", images, want)
+	}
+}
+
+func TestBuildPodEnableServiceLinks(t *testing.T) {
+	on, off := true, false
+	for _, links := range []*bool{nil, &on, &off} {
+		drone := newDrone("grounded")
+		drone.Spec.EnableServiceLinks = links
+		r := &DroneReconciler{Log: logf.NullLogger{}}
+		got := r.buildPod(*drone, "node-1").Spec.EnableServiceLinks
+		// unset is left to the API server, which enables them
+		if (got == nil) != (links == nil) || (got != nil && *got != *links) {
+			t.Errorf("enable service links = %v, want %v", got, links)
+		}
+	}
+}
+
+func TestRecordStartup(t *testing.T) {
+	at := func(d time.Duration) *metav1.Time {
+		at := metav1.NewTime(testTime.Add(d))
+		return &at
+	}
+	now := *at(time.Hour)
+	pod := func(started, ready *metav1.Time, gated bool) *core.Pod {
+		p := dronePod("timed", "node-1", ready != nil)
+		p.Status.StartTime = started
+		if ready != nil {
+			p.Status.Conditions[0].LastTransitionTime = *ready
+		}
+		if gated {
+			p.Spec.ReadinessGates = []core.PodReadinessGate{{ConditionType: "example.com/registered"}}
+			p.Status.Conditions = append(p.Status.Conditions, core.PodCondition{Type: "example.com/registered", Status: core.ConditionTrue})
+		}
+		return p
+	}
+	tests := []struct {
+		name                 string
+		status               experimentsv1.DroneStatus
+		pod                  *core.Pod
+		wantStart, wantReady *metav1.Time
+		wantChanged          bool
+	}{
+		{name: "not started", pod: pod(nil, nil, false)},
+		{name: "started", pod: pod(at(time.Second), nil, false), wantStart: at(time.Second), wantChanged: true},
+		{name: "ready", pod: pod(at(time.Second), at(time.Minute), false),
+			wantStart: at(time.Second), wantReady: at(time.Minute), wantChanged: true},
+		{name: "ready behind readiness gates", pod: pod(at(time.Second), at(time.Minute), true),
+			wantStart: at(time.Second), wantReady: &now, wantChanged: true},
+		{name: "already recorded", status: experimentsv1.DroneStatus{StartTime: at(time.Second), ReadyTime: at(time.Minute)},
+			pod:       pod(at(10*time.Minute), at(11*time.Minute), false),
+			wantStart: at(time.Second), wantReady: at(time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := tt.status
+			changed := recordStartup(&status, tt.pod, now)
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if !equality.Semantic.DeepEqual(status.StartTime, tt.wantStart) || !equality.Semantic.DeepEqual(status.ReadyTime, tt.wantReady) {
+				t.Errorf("start/ready = %v/%v, want %v/%v", status.StartTime, status.ReadyTime, tt.wantStart, tt.wantReady)
+			}
+		})
+	}
+}
+
+func TestReconcileRecordsStartupOnce(t *testing.T) {
+	pod := dronePod("timed", "node-1", true)
+	started, ready := metav1.NewTime(testTime.Add(time.Second)), metav1.NewTime(testTime.Add(time.Minute))
+	pod.Status.StartTime, pod.Status.Conditions[0].LastTransitionTime = &started, ready
+	r, _ := newDroneReconciler(newDrone("timed"), pod, droneNode("node-1"))
+
+	reconcileDrone(t, r, "timed")
+	expect := func() {
+		t.Helper()
+		status := getDrone(t, r, "timed").Status
+		if status.StartTime == nil || !status.StartTime.Equal(&started) || status.ReadyTime == nil || !status.ReadyTime.Equal(&ready) {
+			t.Errorf("start/ready = %v/%v, want %v/%v", status.StartTime, status.ReadyTime, started, ready)
+		}
+	}
+	expect()
+
+	// the pod restarting doesn't move the first start
+	pod = getPod(t, r, "timed")
+	restarted := metav1.NewTime(testTime.Add(time.Hour))
+	pod.Status.StartTime, pod.Status.Conditions[0].LastTransitionTime = &restarted, restarted
+	updateObject(t, r, pod)
+	reconcileDrone(t, r, "timed")
+	expect()
+}
+
+func TestReconcilePacesStartsPerNode(t *testing.T) {
+	objs := []runtime.Object{droneNode("node-1")}
+	names := []string{"first", "second", "third"}
+	for _, name := range names {
+		drone := newDrone(name)
+		drone.Spec.MaxPerNode = 3
+		objs = append(objs, drone)
+		defer pendingDrones.Delete(types.NamespacedName{Namespace: testNamespace, Name: name})
+	}
+	r, _ := newDroneReconciler(objs...)
+	r.MaxStartingPerNode = 1
+
+	// one drone pod starts at a time, the next follows once it is ready
+	for i, name := range names {
+		for _, waiting := range names[i:] {
+			reconcileDrone(t, r, waiting)
+		}
+		pod := getPod(t, r, name)
+		for _, waiting := range names[i+1:] {
+			if drone := getDrone(t, r, waiting); drone.Status.Reason != reasonNodesBusy {
+				t.Errorf("%s status reason = %q while %s starts, want %q", waiting, drone.Status.Reason, name, reasonNodesBusy)
+			}
+		}
+		pod.Status = dronePod(name, "node-1", true).Status
+		updateObject(t, r, pod)
+	}
+}
+
+func TestPickNodePreferredNode(t *testing.T) {
+	small, big := droneNode("small"), droneNode("big")
+	small.Status.Allocatable[core.ResourceCPU] = resource.MustParse("2")
+	big.Status.Allocatable[core.ResourceCPU] = resource.MustParse("8")
+	tests := []struct {
+		name               string
+		preferred          string
+		requests           core.ResourceList
+		pods               []core.Pod
+		maxStartingPerNode int32
+		want               string
+	}{
+		{name: "no preference", want: "big"},
+		{name: "free", preferred: "small", want: "small"},
+		{name: "occupied", preferred: "small", pods: []core.Pod{*dronePod("occupant", "small", true)}, want: "big"},
+		{name: "unknown", preferred: "elsewhere", want: "big"},
+		{name: "too small", preferred: "small", requests: core.ResourceList{core.ResourceCPU: resource.MustParse("4")}, want: "big"},
+		{name: "busy starting", preferred: "small", pods: []core.Pod{*dronePod("starting", "small", false)},
+			maxStartingPerNode: 1, want: "big"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drone := newDrone("picky")
+			drone.Spec.PreferredNode = tt.preferred
+			drone.Spec.Resources.Requests = tt.requests
+			// room for two, so that only starting pods keep a node busy
+			if tt.maxStartingPerNode > 0 {
+				drone.Spec.MaxPerNode = 2
+			}
+			pool := &dronePool{Nodes: []core.Node{*small, *big}, Pods: tt.pods, PodsPerNode: map[string]int32{},
+				NodePods: tt.pods, TakenPerNode: map[string]int32{}, MaxStartingPerNode: tt.maxStartingPerNode}
+			for i := range tt.pods {
+				pool.PodsPerNode[podNode(&tt.pods[i])]++
+				pool.TakenPerNode[podNode(&tt.pods[i])]++
+			}
+			if got, err := pickNode(pool, drone); err != nil || got != tt.want {
+				t.Errorf("pickNode() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcilePreferredNode(t *testing.T) {
+	free, taken := newDrone("free"), newDrone("taken")
+	free.Spec.PreferredNode, taken.Spec.PreferredNode = "node-2", "node-1"
+	r, _ := newDroneReconciler(free, taken, droneNode("node-1"), droneNode("node-2"), droneNode("node-3"),
+		dronePod("occupant", "node-1", true))
+
+	reconcileDrone(t, r, "free")
+	if node := podNode(getPod(t, r, "free")); node != "node-2" {
+		t.Errorf("drone preferring a free node is on %q, want node-2", node)
+	}
+	reconcileDrone(t, r, "taken")
+	if node := podNode(getPod(t, r, "taken")); node != "node-3" {
+		t.Errorf("drone preferring an occupied node is on %q, want the free node-3", node)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNoDroneNodes means no node matches the node selector of a drone.
+	ErrNoDroneNodes = errors.New("no drone nodes")
+
+	// ErrNoFreeNode means every drone node already carries as many drones
+	// as it may, or is under resource pressure.
+	ErrNoFreeNode = errors.New("no free drone node")
+
+	// ErrInsufficientCapacity means there are free drone nodes, but none
+	// with enough spare resources (CPU, memory or extended resources like
+	// GPUs) for the drone's requests.
+	ErrInsufficientCapacity = errors.New("insufficient capacity on free drone nodes")
+
+	// ErrNodesBusy means the free drone nodes fitting the drone are all
+	// still starting as many drone pods as they may at once.
+	ErrNodesBusy = errors.New("free drone nodes are busy starting other drones")
+
+	// errInvalidColocation means the RequireColocatedWith selector of a
+	// drone doesn't parse.
+	errInvalidColocation = errors.New("invalid colocation selector")
+
+	// errInvalidHowMany means the HowManyFrom ConfigMap key of a swarm holds
+	// something other than a drone count.
+	errInvalidHowMany = errors.New("invalid howManyFrom value")
+)
+
+// Status reasons of drones waiting for a node.
+const (
+	reasonNoDroneNodes         = "NoDroneNodes"
+	reasonNoFreeNode           = "NoFreeNode"
+	reasonInsufficientCapacity = "InsufficientCapacity"
+	reasonNodesBusy            = "NodesBusy"
+	reasonMoving               = "Moving"
+)
+
+// pendingReason returns the status reason of a drone that couldn't be placed
+// because of err.
+func pendingReason(err error) string {
+	switch {
+	case errors.Is(err, ErrNoDroneNodes):
+		return reasonNoDroneNodes
+	case errors.Is(err, ErrInsufficientCapacity):
+		return reasonInsufficientCapacity
+	case errors.Is(err, ErrNodesBusy):
+		return reasonNodesBusy
+	}
+	return reasonNoFreeNode
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestPlacementErrors(t *testing.T) {
+	tests := []struct {
+		name               string
+		objs               []runtime.Object
+		requests           core.ResourceList
+		maxStartingPerNode int32
+		want               error
+		wantReason         string
+	}{
+		{name: "no drone nodes", want: ErrNoDroneNodes, wantReason: reasonNoDroneNodes},
+		{name: "no free node", objs: []runtime.Object{droneNode("node-1"), dronePod("occupant", "node-1", true)},
+			want: ErrNoFreeNode, wantReason: reasonNoFreeNode},
+		{name: "insufficient capacity", objs: []runtime.Object{droneNode("node-1")},
+			requests: core.ResourceList{core.ResourceCPU: resource.MustParse("5")},
+			want:     ErrInsufficientCapacity, wantReason: reasonInsufficientCapacity},
+		{name: "nodes busy", objs: []runtime.Object{droneNode("node-1"), dronePod("starting", "node-1", false)},
+			maxStartingPerNode: 1, want: ErrNodesBusy, wantReason: reasonNodesBusy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drone := newDrone("waiting")
+			drone.Spec.Resources.Requests = tt.requests
+			// room for two, so that only starting pods keep a node busy
+			if tt.maxStartingPerNode > 0 {
+				drone.Spec.MaxPerNode = 2
+			}
+			r, _ := newDroneReconciler(append(tt.objs, drone)...)
+			r.MaxStartingPerNode = tt.maxStartingPerNode
+
+			pool, err := r.placementPool(context.Background(), drone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := pickNode(pool, drone); !errors.Is(err, tt.want) {
+				t.Errorf("pickNode() error = %v, want %v", err, tt.want)
+			}
+			reconcileDrone(t, r, "waiting")
+			if reason := getDrone(t, r, "waiting").Status.Reason; reason != tt.wantReason {
+				t.Errorf("status reason = %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestPendingReasonWrapped(t *testing.T) {
+	for err, want := range map[error]string{
+		ErrNoDroneNodes:         reasonNoDroneNodes,
+		ErrNoFreeNode:           reasonNoFreeNode,
+		ErrInsufficientCapacity: reasonInsufficientCapacity,
+		ErrNodesBusy:            reasonNodesBusy,
+	} {
+		if got := pendingReason(fmt.Errorf("placing drone: %w", err)); got != want {
+			t.Errorf("pendingReason(%v) = %q, want %q", err, got, want)
+		}
+	}
+}
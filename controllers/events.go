@@ -0,0 +1,119 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/record"
+)
+
+// ThrottleEvents wraps recorder so it records an event of a given type and
+// reason on an object at most once per interval. The event broadcaster only
+// aggregates events after the fact, but reconciles of a stuck drone would
+// still send the API server the same event over and over. Zero disables
+// throttling.
+func ThrottleEvents(recorder record.EventRecorder, interval time.Duration) record.EventRecorder {
+	if interval <= 0 {
+		return recorder
+	}
+	return &throttledRecorder{
+		recorder: recorder,
+		interval: interval,
+		clock:    clock.RealClock{},
+		last:     map[eventKey]time.Time{},
+	}
+}
+
+// eventKey identifies the events throttled together.
+type eventKey struct {
+	object    types.UID
+	eventtype string
+	reason    string
+}
+
+type throttledRecorder struct {
+	recorder record.EventRecorder
+	interval time.Duration
+	clock    clock.Clock
+
+	mu        sync.Mutex
+	last      map[eventKey]time.Time
+	lastSweep time.Time
+}
+
+// allow reports whether the event may be recorded now, and if so remembers
+// that it was.
+func (r *throttledRecorder) allow(object runtime.Object, eventtype, reason string) bool {
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return true
+	}
+	key := eventKey{object: accessor.GetUID(), eventtype: eventtype, reason: reason}
+	now := r.clock.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if last, ok := r.last[key]; ok && now.Sub(last) < r.interval {
+		return false
+	}
+	r.last[key] = now
+	// forget objects that stopped recording, e.g. deleted ones
+	if now.Sub(r.lastSweep) >= r.interval {
+		for k, last := range r.last {
+			if now.Sub(last) >= r.interval {
+				delete(r.last, k)
+			}
+		}
+		r.lastSweep = now
+	}
+	return true
+}
+
+// Event implements record.EventRecorder.
+func (r *throttledRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if r.allow(object, eventtype, reason) {
+		r.recorder.Event(object, eventtype, reason, message)
+	}
+}
+
+// Eventf implements record.EventRecorder.
+func (r *throttledRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.allow(object, eventtype, reason) {
+		r.recorder.Eventf(object, eventtype, reason, messageFmt, args...)
+	}
+}
+
+// PastEventf implements record.EventRecorder.
+func (r *throttledRecorder) PastEventf(object runtime.Object, timestamp metav1.Time, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.allow(object, eventtype, reason) {
+		r.recorder.PastEventf(object, timestamp, eventtype, reason, messageFmt, args...)
+	}
+}
+
+// AnnotatedEventf implements record.EventRecorder.
+func (r *throttledRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.allow(object, eventtype, reason) {
+		r.recorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/record"
+)
+
+// throttledOn returns a recorder throttling events to recorder on clock.
+func throttledOn(recorder record.EventRecorder, clock clock.Clock, interval time.Duration) record.EventRecorder {
+	throttled := ThrottleEvents(recorder, interval).(*throttledRecorder)
+	throttled.clock = clock
+	return throttled
+}
+
+func TestThrottleEvents(t *testing.T) {
+	clock := clock.NewFakeClock(testTime)
+	recorder := record.NewFakeRecorder(100)
+	throttled := throttledOn(recorder, clock, time.Minute)
+	drone, other := newDrone("blocked"), newDrone("other")
+	other.UID = types.UID("other")
+
+	for i := 0; i < 10; i++ {
+		throttled.Event(drone, core.EventTypeWarning, reasonNoFreeNode, "no free drone node")
+	}
+	// other reasons, types and objects aren't held back
+	throttled.Eventf(drone, core.EventTypeWarning, reasonNoDroneNodes, "no drone nodes")
+	throttled.Event(drone, core.EventTypeNormal, reasonNoFreeNode, "no free drone node")
+	throttled.Event(other, core.EventTypeWarning, reasonNoFreeNode, "no free drone node")
+	if n := len(recorder.Events); n != 4 {
+		t.Errorf("recorded %d events, want 4", n)
+	}
+
+	clock.Step(59 * time.Second)
+	throttled.Event(drone, core.EventTypeWarning, reasonNoFreeNode, "no free drone node")
+	if n := len(recorder.Events); n != 4 {
+		t.Errorf("recorded %d events within the interval, want still 4", n)
+	}
+	clock.Step(time.Second)
+	throttled.Event(drone, core.EventTypeWarning, reasonNoFreeNode, "no free drone node")
+	if n := len(recorder.Events); n != 5 {
+		t.Errorf("recorded %d events after the interval, want 5", n)
+	}
+
+	if ThrottleEvents(recorder, 0) != record.EventRecorder(recorder) {
+		t.Error("a zero interval throttles, want the recorder as is")
+	}
+}
+
+func TestReconcileBlockedDroneThrottlesEvents(t *testing.T) {
+	r, clock := newDroneReconciler(newDrone("blocked"), dronePod("occupant", "node-1", true), droneNode("node-1"))
+	recorder := r.Recorder.(*record.FakeRecorder)
+	r.Recorder = throttledOn(recorder, clock, time.Minute)
+	defer pendingDrones.Delete(types.NamespacedName{Namespace: testNamespace, Name: "blocked"})
+
+	for i := 0; i < 10; i++ {
+		reconcileDrone(t, r, "blocked")
+		clock.Step(time.Second)
+	}
+	expectEvent(t, recorder, "Warning "+reasonNoFreeNode+" ")
+	if n := len(recorder.Events); n != 0 {
+		t.Errorf("recorded %d more events for the stuck drone, want just the one", n)
+	}
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultFinalizerPrefix is the domain the finalizers of the controllers live
+// under unless configured otherwise.
+const DefaultFinalizerPrefix = "experiments.mad.md"
+
+// metricsFinalizer holds Drones back until their metric series and their pod
+// are deleted.
+const metricsFinalizer = "metrics"
+
+// droneCleanupFinalizer holds Swarms with a target namespace of their own back
+// until their drones there are deleted, which garbage collection can't do
+// across namespaces.
+const droneCleanupFinalizer = "drones"
+
+// finalizerName returns the finalizer called name under prefix, or under
+// DefaultFinalizerPrefix if that is empty.
+func finalizerName(prefix, name string) string {
+	if prefix == "" {
+		prefix = DefaultFinalizerPrefix
+	}
+	return prefix + "/" + name
+}
+
+// hasFinalizer reports whether the object carries the finalizer.
+func hasFinalizer(o metav1.Object, finalizer string) bool {
+	for _, f := range o.GetFinalizers() {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFinalizerName(t *testing.T) {
+	tests := []struct {
+		prefix, name, want string
+	}{
+		{prefix: "", name: metricsFinalizer, want: "experiments.mad.md/metrics"},
+		{prefix: "drones.example.com", name: metricsFinalizer, want: "drones.example.com/metrics"},
+		{prefix: "drones.example.com", name: droneCleanupFinalizer, want: "drones.example.com/drones"},
+	}
+	for _, tt := range tests {
+		if got := finalizerName(tt.prefix, tt.name); got != tt.want {
+			t.Errorf("finalizerName(%q, %q) = %q, want %q", tt.prefix, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReconcileCustomFinalizerPrefix(t *testing.T) {
+	const foreign = "example.com/keep"
+	drone := newDrone("custom")
+	drone.Finalizers = []string{foreign}
+	r, _ := newDroneReconciler(drone, droneNode("node-1"))
+	r.FinalizerPrefix = "drones.example.com"
+
+	reconcileDrone(t, r, "custom")
+	want := []string{foreign, "drones.example.com/metrics"}
+	if got := getDrone(t, r, "custom").Finalizers; !reflect.DeepEqual(got, want) {
+		t.Fatalf("finalizers = %v, want %v", got, want)
+	}
+
+	drone = getDrone(t, r, "custom")
+	now := metav1.NewTime(testTime)
+	drone.DeletionTimestamp = &now
+	updateObject(t, r, drone)
+	reconcileDrone(t, r, "custom")
+	if got := getDrone(t, r, "custom").Finalizers; !reflect.DeepEqual(got, []string{foreign}) {
+		t.Errorf("finalizers = %v, want only the foreign one left", got)
+	}
+}
+
+func TestReconcileSwarmCustomFinalizerPrefix(t *testing.T) {
+	swarm := newSwarm("custom", 1)
+	swarm.Spec.TargetNamespace = "drones"
+	r, _ := newSwarmReconciler(swarm, &core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "drones"}})
+	r.FinalizerPrefix = "drones.example.com"
+
+	reconcileSwarm(t, r, "custom")
+	if got := getSwarm(t, r, "custom").Finalizers; !reflect.DeepEqual(got, []string{"drones.example.com/drones"}) {
+		t.Fatalf("finalizers = %v, want the custom one only", got)
+	}
+
+	swarm = getSwarm(t, r, "custom")
+	now := metav1.NewTime(testTime)
+	swarm.DeletionTimestamp = &now
+	updateObject(t, r, swarm)
+	reconcileSwarm(t, r, "custom")
+	if got := getSwarm(t, r, "custom").Finalizers; len(got) != 0 {
+		t.Errorf("finalizers = %v, want the custom one removed", got)
+	}
+	if drones := listDrones(t, r, "drones"); len(drones) != 0 {
+		t.Errorf("drones = %v, want them cleaned up", droneNames(drones))
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+// reasonInvalidSpec is the reason of events on objects the controllers can't
+// act on.
+const reasonInvalidSpec = "InvalidSpec"
+
+// recoverReconcile turns a panic during a reconcile, e.g. on a malformed object
+// the guards below missed, into an error so the manager keeps running and the
+// object is retried with backoff. It must be deferred.
+func recoverReconcile(log logr.Logger, err *error) {
+	if p := recover(); p != nil {
+		*err = fmt.Errorf("reconcile panicked: %v", p)
+		log.Error(*err, "recovered from panic")
+	}
+}
+
+// invalidDroneSpec returns why no pod can be built from the spec, or "" if one
+// can. The webhooks and CRD schema reject such specs, but objects created
+// before those were installed may still carry them.
+func invalidDroneSpec(spec *experimentsv1.DroneSpec) string {
+	if spec.ActiveDeadlineSeconds != nil && *spec.ActiveDeadlineSeconds <= 0 {
+		return "activeDeadlineSeconds must be positive"
+	}
+	if spec.MaxPerNode < 0 {
+		return "maxPerNode must not be negative"
+	}
+	if spec.TTLSecondsAfterFinished != nil && *spec.TTLSecondsAfterFinished < 0 {
+		return "ttlSecondsAfterFinished must not be negative"
+	}
+	return ""
+}
+
+// invalidSwarmSpec is like invalidDroneSpec for swarms.
+func invalidSwarmSpec(spec *experimentsv1.SwarmSpec) string {
+	if spec.HowMany < 0 {
+		return "howmany must not be negative"
+	}
+	return invalidDroneSpec(&spec.Template)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+func TestReconcileZeroValues(t *testing.T) {
+	meta := metav1.ObjectMeta{Name: "zero", Namespace: testNamespace}
+	droneReconciler, _ := newDroneReconciler(&experimentsv1.Drone{ObjectMeta: meta}, droneNode("node-1"))
+	reconcileDrone(t, droneReconciler, "zero")
+	getPod(t, droneReconciler, "zero")
+
+	swarmReconciler, _ := newSwarmReconciler(&experimentsv1.Swarm{ObjectMeta: meta}, droneNode("node-1"))
+	reconcileSwarm(t, swarmReconciler, "zero")
+}
+
+func TestReconcileInvalidSpecs(t *testing.T) {
+	zero, negative := int64(0), int32(-1)
+	tests := []struct {
+		name string
+		spec experimentsv1.DroneSpec
+	}{
+		{name: "zero active deadline", spec: experimentsv1.DroneSpec{ActiveDeadlineSeconds: &zero}},
+		{name: "negative maxPerNode", spec: experimentsv1.DroneSpec{MaxPerNode: -1}},
+		{name: "negative TTL", spec: experimentsv1.DroneSpec{TTLSecondsAfterFinished: &negative}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drone := newDrone("invalid")
+			drone.Spec = tt.spec
+			r, _ := newDroneReconciler(drone, droneNode("node-1"))
+
+			reconcileDrone(t, r, "invalid")
+			expectEvent(t, r.Recorder.(*record.FakeRecorder), "Warning "+reasonInvalidSpec+" ")
+			err := r.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: "invalid"}, &core.Pod{})
+			if !apierrors.IsNotFound(err) {
+				t.Errorf("pod lookup = %v, want no pod for an invalid spec", err)
+			}
+		})
+	}
+
+	swarm := newSwarm("invalid", -1)
+	r, _ := newSwarmReconciler(swarm)
+	reconcileSwarm(t, r, "invalid")
+	expectEvent(t, r.Recorder.(*record.FakeRecorder), "Warning "+reasonInvalidSpec+" ")
+}
+
+func TestRecoverReconcile(t *testing.T) {
+	reconcile := func() (err error) {
+		defer recoverReconcile(logf.NullLogger{}, &err)
+		var drone *experimentsv1.Drone
+		_ = drone.Spec
+		return errors.New("unreachable")
+	}
+	if err := reconcile(); err == nil || err.Error() == "unreachable" {
+		t.Errorf("error = %v, want the recovered panic", err)
+	}
+}
@@ -0,0 +1,338 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+func init() {
+	// the fake client decodes everything with the client-go scheme
+	if err := experimentsv1.AddToScheme(scheme.Scheme); err != nil {
+		panic(err)
+	}
+}
+
+// testNamespace is where the objects of the tests live.
+const testNamespace = "default"
+
+// zoneLabel is the label the tests spread drones over zones by.
+const zoneLabel = "topology.kubernetes.io/zone"
+
+// testTime is when the fake clocks of the tests start.
+var testTime = time.Date(2019, time.October, 1, 12, 0, 0, 0, time.UTC)
+
+// apiClient is a fake client stamping created objects with a UID and a
+// creation timestamp, like the API server does.
+type apiClient struct {
+	client.Client
+	clock clock.Clock
+}
+
+func newFakeClient(clock clock.Clock, objs ...runtime.Object) *apiClient {
+	return &apiClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, objs...), clock: clock}
+}
+
+func (c *apiClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	if accessor.GetUID() == "" {
+		accessor.SetUID(types.UID(accessor.GetNamespace() + "/" + accessor.GetName()))
+	}
+	if created := accessor.GetCreationTimestamp(); created.IsZero() {
+		accessor.SetCreationTimestamp(metav1.NewTime(c.clock.Now()))
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+// newDroneReconciler returns a DroneReconciler on a fake client holding objs.
+func newDroneReconciler(objs ...runtime.Object) (*DroneReconciler, *clock.FakeClock) {
+	clock := clock.NewFakeClock(testTime)
+	return droneReconcilerOn(newFakeClient(clock, objs...), clock), clock
+}
+
+// droneReconcilerOn returns a DroneReconciler sharing the client and clock of
+// another reconciler.
+func droneReconcilerOn(c client.Client, clock clock.Clock) *DroneReconciler {
+	return &DroneReconciler{
+		Client:   c,
+		Log:      logf.NullLogger{},
+		Scheme:   scheme.Scheme,
+		Recorder: record.NewFakeRecorder(100),
+		Clock:    clock,
+	}
+}
+
+// newSwarmReconciler returns a SwarmReconciler on a fake client holding objs.
+func newSwarmReconciler(objs ...runtime.Object) (*SwarmReconciler, *clock.FakeClock) {
+	clock := clock.NewFakeClock(testTime)
+	return &SwarmReconciler{
+		Client:   newFakeClient(clock, objs...),
+		Log:      logf.NullLogger{},
+		Scheme:   scheme.Scheme,
+		Recorder: record.NewFakeRecorder(100),
+		Clock:    clock,
+	}, clock
+}
+
+// droneNode returns a Ready drone node with room for a couple of drones.
+func droneNode(name string) *core.Node {
+	return &core.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{droneNodeLabel: "drone", hostnameLabel: name},
+		},
+		Status: core.NodeStatus{
+			Allocatable: core.ResourceList{
+				core.ResourceCPU:    resource.MustParse("4"),
+				core.ResourceMemory: resource.MustParse("8Gi"),
+			},
+			Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+		},
+	}
+}
+
+// newDrone returns a Drone with an empty spec.
+func newDrone(name string) *experimentsv1.Drone {
+	return &experimentsv1.Drone{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+			UID:       types.UID(testNamespace + "/" + name),
+		},
+	}
+}
+
+// dronePod returns a running drone pod on the node, ready if flying.
+func dronePod(name, node string, flying bool) *core.Pod {
+	ready := core.ConditionFalse
+	if flying {
+		ready = core.ConditionTrue
+	}
+	return &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         testNamespace,
+			Labels:            map[string]string{experimentsv1.DroneNameLabel: name},
+			CreationTimestamp: metav1.NewTime(testTime),
+		},
+		Spec: core.PodSpec{
+			NodeSelector: map[string]string{hostnameLabel: node},
+			Containers:   []core.Container{{Name: DroneContainerName}},
+		},
+		Status: core.PodStatus{
+			Phase:      core.PodRunning,
+			Conditions: []core.PodCondition{{Type: core.PodReady, Status: ready}},
+		},
+	}
+}
+
+// reconcileDrone runs a reconcile of the named Drone, failing the test on
+// error.
+func reconcileDrone(t *testing.T, r *DroneReconciler, name string) ctrl.Result {
+	t.Helper()
+	result, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: name}})
+	if err != nil {
+		t.Fatalf("reconcile of drone %s failed: %v", name, err)
+	}
+	return result
+}
+
+// getDrone returns the named Drone, failing the test if it is missing.
+func getDrone(t *testing.T, c client.Client, name string) *experimentsv1.Drone {
+	t.Helper()
+	drone := &experimentsv1.Drone{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: name}, drone); err != nil {
+		t.Fatalf("failed to get drone %s: %v", name, err)
+	}
+	return drone
+}
+
+// getPod returns the pod of the named Drone, failing the test if it is
+// missing.
+func getPod(t *testing.T, c client.Client, name string) *core.Pod {
+	t.Helper()
+	pod := &core.Pod{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: name}, pod); err != nil {
+		t.Fatalf("failed to get pod of drone %s: %v", name, err)
+	}
+	return pod
+}
+
+// newSwarm returns a Swarm asking for howMany drones.
+func newSwarm(name string, howMany int32) *experimentsv1.Swarm {
+	return &experimentsv1.Swarm{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+			UID:       types.UID(testNamespace + "/" + name),
+		},
+		Spec: experimentsv1.SwarmSpec{HowMany: howMany},
+	}
+}
+
+// reconcileSwarm runs a reconcile of the named Swarm, failing the test on
+// error.
+func reconcileSwarm(t *testing.T, r *SwarmReconciler, name string) ctrl.Result {
+	t.Helper()
+	result, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: name}})
+	if err != nil {
+		t.Fatalf("reconcile of swarm %s failed: %v", name, err)
+	}
+	return result
+}
+
+// getSwarm returns the named Swarm, failing the test if it is missing.
+func getSwarm(t *testing.T, c client.Client, name string) *experimentsv1.Swarm {
+	t.Helper()
+	swarm := &experimentsv1.Swarm{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: name}, swarm); err != nil {
+		t.Fatalf("failed to get swarm %s: %v", name, err)
+	}
+	return swarm
+}
+
+// listDrones returns the drones in namespace.
+func listDrones(t *testing.T, c client.Client, namespace string) []experimentsv1.Drone {
+	t.Helper()
+	drones := experimentsv1.DroneList{}
+	if err := c.List(context.Background(), &drones, client.InNamespace(namespace)); err != nil {
+		t.Fatalf("failed to list drones: %v", err)
+	}
+	return drones.Items
+}
+
+// updateObject updates obj, failing the test on error.
+func updateObject(t *testing.T, c client.Client, obj runtime.Object) {
+	t.Helper()
+	if err := c.Update(context.Background(), obj); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+}
+
+// expectEvent fails the test unless one of the events recorded so far starts
+// with prefix, e.g. "Warning PodMissing ".
+func expectEvent(t *testing.T, recorder *record.FakeRecorder, prefix string) {
+	t.Helper()
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			if strings.HasPrefix(event, prefix) {
+				return
+			}
+			events = append(events, event)
+		default:
+			t.Errorf("events = %q, want one starting with %q", events, prefix)
+			return
+		}
+	}
+}
+
+// writeCounter is a client counting the writes going through it.
+type writeCounter struct {
+	client.Client
+	writes int
+}
+
+func (c *writeCounter) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	c.writes++
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *writeCounter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	c.writes++
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *writeCounter) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.writes++
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *writeCounter) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	c.writes++
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+// finalizingClient is a fake client honoring finalizers like the API server
+// does: deleting an object carrying any only marks it deleted, and it is gone
+// once an update removes the last of them.
+type finalizingClient struct {
+	client.Client
+	clock clock.Clock
+}
+
+func (c *finalizingClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	// the finalizers of the stored object count, not those of the caller's copy
+	stored := obj.DeepCopyObject()
+	if err := c.Get(ctx, client.ObjectKey{Namespace: accessor.GetNamespace(), Name: accessor.GetName()}, stored); err != nil {
+		return err
+	}
+	storedMeta, err := meta.Accessor(stored)
+	if err != nil {
+		return err
+	}
+	if len(storedMeta.GetFinalizers()) == 0 {
+		return c.Client.Delete(ctx, obj, opts...)
+	}
+	if storedMeta.GetDeletionTimestamp() == nil {
+		now := metav1.NewTime(c.clock.Now())
+		storedMeta.SetDeletionTimestamp(&now)
+		return c.Client.Update(ctx, stored)
+	}
+	return nil
+}
+
+func (c *finalizingClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if err := c.Client.Update(ctx, obj, opts...); err != nil {
+		return err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	if accessor.GetDeletionTimestamp() != nil && len(accessor.GetFinalizers()) == 0 {
+		return c.Client.Delete(ctx, obj)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+// desiredDrones returns how many drones the swarm should have: its HowMany,
+// or the value of its HowManyFrom ConfigMap key. Swarms filling the drone
+// nodes ignore it.
+func (r *SwarmReconciler) desiredDrones(ctx context.Context, swarm *experimentsv1.Swarm) (int32, error) {
+	ref := swarm.Spec.HowManyFrom
+	if ref == nil {
+		return swarm.Spec.HowMany, nil
+	}
+	optional := ref.Optional != nil && *ref.Optional
+
+	cm := core.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: swarm.Namespace, Name: ref.Name}, &cm); err != nil {
+		if apierrors.IsNotFound(err) && optional {
+			return swarm.Spec.HowMany, nil
+		}
+		return 0, err
+	}
+	value, ok := cm.Data[ref.Key]
+	if !ok {
+		if optional {
+			return swarm.Spec.HowMany, nil
+		}
+		return 0, fmt.Errorf("%w: ConfigMap %s has no key %s", errInvalidHowMany, ref.Name, ref.Key)
+	}
+	howMany, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+	if err != nil || howMany < 0 {
+		return 0, fmt.Errorf("%w: key %s of ConfigMap %s is %q, not a drone count", errInvalidHowMany, ref.Key, ref.Name, value)
+	}
+	return int32(howMany), nil
+}
+
+// swarmsCountingFrom maps a ConfigMap to the swarms reading their drone count
+// from it.
+func (r *SwarmReconciler) swarmsCountingFrom(o handler.MapObject) []reconcile.Request {
+	swarms := experimentsv1.SwarmList{}
+	if err := r.List(context.Background(), &swarms, client.InNamespace(o.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "failed to list swarms for ConfigMap", "ConfigMap", o.Meta.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, s := range swarms.Items {
+		if s.Spec.HowManyFrom != nil && s.Spec.HowManyFrom.Name == o.Meta.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: s.Namespace, Name: s.Name},
+			})
+		}
+	}
+	return requests
+}
+
+// nodeDependentSwarms maps a node to the swarms filling the drone nodes, whose
+// drone count changes as nodes join, leave or get cordoned, and those waiting
+// for enough of them to be ready.
+func (r *SwarmReconciler) nodeDependentSwarms(o handler.MapObject) []reconcile.Request {
+	swarms := experimentsv1.SwarmList{}
+	if err := r.List(context.Background(), &swarms); err != nil {
+		r.Log.Error(err, "failed to list swarms for node", "node", o.Meta.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, s := range swarms.Items {
+		if s.Spec.FillNodes || s.Spec.MinReadyNodes > 0 {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: s.Namespace, Name: s.Name},
+			})
+		}
+	}
+	return requests
+}
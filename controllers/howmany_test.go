@@ -0,0 +1,126 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// scaleConfigMap returns a ConfigMap holding the drone count under "drones".
+func scaleConfigMap(drones string) *core.ConfigMap {
+	return &core.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "scale", Namespace: testNamespace},
+		Data:       map[string]string{"drones": drones},
+	}
+}
+
+func TestDesiredDrones(t *testing.T) {
+	optional := true
+	tests := []struct {
+		name         string
+		cm           *core.ConfigMap
+		key          string
+		optional     bool
+		want         int32
+		wantErr      error
+		wantNotFound bool
+	}{
+		{name: "count", cm: scaleConfigMap("4"), key: "drones", want: 4},
+		{name: "padded count", cm: scaleConfigMap(" 4\n"), key: "drones", want: 4},
+		{name: "zero", cm: scaleConfigMap("0"), key: "drones", want: 0},
+		{name: "not a number", cm: scaleConfigMap("many"), key: "drones", wantErr: errInvalidHowMany},
+		{name: "negative", cm: scaleConfigMap("-1"), key: "drones", wantErr: errInvalidHowMany},
+		{name: "missing key", cm: scaleConfigMap("4"), key: "count", wantErr: errInvalidHowMany},
+		{name: "missing optional key", cm: scaleConfigMap("4"), key: "count", optional: true, want: 2},
+		{name: "missing optional ConfigMap", key: "drones", optional: true, want: 2},
+		{name: "missing ConfigMap", key: "drones", wantNotFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			swarm := newSwarm("fleet", 2)
+			swarm.Spec.HowManyFrom = &core.ConfigMapKeySelector{LocalObjectReference: core.LocalObjectReference{Name: "scale"}, Key: tt.key}
+			if tt.optional {
+				swarm.Spec.HowManyFrom.Optional = &optional
+			}
+			var objs []runtime.Object
+			if tt.cm != nil {
+				objs = append(objs, tt.cm)
+			}
+			r, _ := newSwarmReconciler(objs...)
+
+			got, err := r.desiredDrones(context.Background(), swarm)
+			switch {
+			case tt.wantNotFound:
+				if !apierrors.IsNotFound(err) {
+					t.Errorf("error = %v, want not found", err)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case got != tt.want:
+				t.Errorf("desired drones = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileSwarmHowManyFrom(t *testing.T) {
+	swarm := newSwarm("fleet", 1)
+	swarm.Spec.Ordinal = true
+	swarm.Spec.HowManyFrom = &core.ConfigMapKeySelector{LocalObjectReference: core.LocalObjectReference{Name: "scale"}, Key: "drones"}
+	cm := scaleConfigMap("3")
+	r, _ := newSwarmReconciler(swarm, cm, newSwarm("other", 1))
+
+	reconcileSwarm(t, r, "fleet")
+	if n := len(listDrones(t, r, testNamespace)); n != 3 {
+		t.Fatalf("got %d drones, want the 3 of the ConfigMap", n)
+	}
+
+	cm.Data["drones"] = "1"
+	updateObject(t, r, cm)
+	want := []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "fleet"}}}
+	if requests := r.swarmsCountingFrom(handler.MapObject{Meta: cm, Object: cm}); !reflect.DeepEqual(requests, want) {
+		t.Errorf("ConfigMap maps to %v, want %v", requests, want)
+	}
+	reconcileSwarm(t, r, "fleet")
+	if n := len(listDrones(t, r, testNamespace)); n != 1 {
+		t.Errorf("got %d drones, want 1 once the ConfigMap changed", n)
+	}
+
+	cm.Data["drones"] = "lots"
+	updateObject(t, r, cm)
+	reconcileSwarm(t, r, "fleet")
+	expectEvent(t, r.Recorder.(*record.FakeRecorder), "Warning "+reasonInvalidSpec+" ")
+	if n := len(listDrones(t, r, testNamespace)); n != 1 {
+		t.Errorf("got %d drones, want the swarm left alone on an invalid count", n)
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// rewriteImage replaces the longest matching prefix of image according to
+// rewrites. Images matching no prefix are returned as is.
+func rewriteImage(image string, rewrites map[string]string) string {
+	var match string
+	for prefix := range rewrites {
+		if strings.HasPrefix(image, prefix) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	if match == "" {
+		return image
+	}
+	return rewrites[match] + strings.TrimPrefix(image, match)
+}
+
+// ParseImageRewrites parses a comma separated list of prefix=replacement
+// pairs, e.g. "danacr/=mirror.local/danacr/,docker.io/=mirror.local/".
+func ParseImageRewrites(s string) (map[string]string, error) {
+	rewrites := map[string]string{}
+	if s == "" {
+		return rewrites, nil
+	}
+	for _, rule := range strings.Split(s, ",") {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid image rewrite %q, expected prefix=replacement", rule)
+		}
+		rewrites[parts[0]] = parts[1]
+	}
+	return rewrites, nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestRewriteImage(t *testing.T) {
+	rewrites := map[string]string{
+		"danacr/":          "mirror.local/danacr/",
+		"danacr/drone-pod": "mirror.local/drones/pod",
+		"docker.io/":       "mirror.local/",
+	}
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "danacr/drone-pod:latest", want: "mirror.local/drones/pod:latest"},
+		{image: "danacr/other:v1", want: "mirror.local/danacr/other:v1"},
+		{image: "docker.io/library/busybox", want: "mirror.local/library/busybox"},
+		{image: "quay.io/team/drone", want: "quay.io/team/drone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if got := rewriteImage(tt.image, rewrites); got != tt.want {
+				t.Errorf("rewriteImage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseImageRewrites(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{in: "", want: map[string]string{}},
+		{in: "danacr/=mirror.local/danacr/", want: map[string]string{"danacr/": "mirror.local/danacr/"}},
+		{in: "danacr/=mirror.local/danacr/,docker.io/=", want: map[string]string{"danacr/": "mirror.local/danacr/", "docker.io/": ""}},
+		{in: "danacr/", wantErr: true},
+		{in: "=mirror.local/", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseImageRewrites(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseImageRewrites() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseImageRewrites() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildPodRewritesImage(t *testing.T) {
+	r := &DroneReconciler{Log: logf.NullLogger{}, ImageRewrites: map[string]string{
+		"danacr/":  "mirror.local/danacr/",
+		"example/": "mirror.local/example/",
+	}}
+	tests := []struct {
+		name  string
+		image string
+		want  string
+	}{
+		{name: "default image", want: "mirror.local/danacr/drone-pod:latest"},
+		{name: "custom image", image: "example/drone:v2", want: "mirror.local/example/drone:v2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drone := newDrone("mirrored")
+			drone.Spec.Image = tt.image
+			if got := r.buildPod(*drone, "node-1").Spec.Containers[0].Image; got != tt.want {
+				t.Errorf("image = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
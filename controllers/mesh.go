@@ -0,0 +1,43 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultMeshAnnotations asks both Istio and Linkerd to inject their sidecar.
+const DefaultMeshAnnotations = "sidecar.istio.io/inject=true,linkerd.io/inject=enabled"
+
+// ParseMeshAnnotations parses a comma separated list of key=value annotations
+// stamped on the pods of drones with mesh injection, e.g.
+// "sidecar.istio.io/inject=true".
+func ParseMeshAnnotations(s string) (map[string]string, error) {
+	annotations := map[string]string{}
+	if s == "" {
+		return annotations, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid mesh annotation %q, expected key=value", pair)
+		}
+		annotations[parts[0]] = parts[1]
+	}
+	return annotations, nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+func TestParseMeshAnnotations(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{in: "", want: map[string]string{}},
+		{in: DefaultMeshAnnotations, want: map[string]string{"sidecar.istio.io/inject": "true", "linkerd.io/inject": "enabled"}},
+		{in: "sidecar.istio.io/inject=", want: map[string]string{"sidecar.istio.io/inject": ""}},
+		{in: "sidecar.istio.io/inject", wantErr: true},
+		{in: "=true", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseMeshAnnotations(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMeshAnnotations() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMeshAnnotations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildPodMeshAnnotations(t *testing.T) {
+	mesh, err := ParseMeshAnnotations(DefaultMeshAnnotations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &DroneReconciler{Log: logf.NullLogger{}, MeshAnnotations: mesh}
+	for _, injection := range []bool{false, true} {
+		drone := newDrone("meshed")
+		drone.Spec.MeshInjection = injection
+		drone.Annotations = map[string]string{experimentsv1.RescheduleAnnotation: "1"}
+		annotations := r.buildPod(*drone, "node-1").Annotations
+		for k, v := range mesh {
+			if got, ok := annotations[k]; ok != injection || (injection && got != v) {
+				t.Errorf("with mesh injection %v annotation %s = %q (set %v)", injection, k, got, ok)
+			}
+		}
+		if annotations[experimentsv1.RescheduleAnnotation] != "1" {
+			t.Errorf("annotations = %v, want the reschedule request kept", annotations)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+// pendingDrones exports how long drones have been waiting for a free drone
+// node.
+var pendingDrones = newPendingCollector(clock.RealClock{})
+
+func init() {
+	metrics.Registry.MustRegister(pendingDrones)
+}
+
+// pendingCollector remembers since when drones are pending and reports the
+// duration at scrape time, so it keeps growing between reconciles.
+type pendingCollector struct {
+	desc  *prometheus.Desc
+	clock clock.Clock
+
+	mu    sync.Mutex
+	since map[types.NamespacedName]time.Time
+}
+
+func newPendingCollector(c clock.Clock) *pendingCollector {
+	return &pendingCollector{
+		desc: prometheus.NewDesc("drone_pending_seconds",
+			"How long a drone has been waiting for a free drone node.",
+			[]string{"namespace", "drone"}, nil),
+		clock: c,
+		since: map[types.NamespacedName]time.Time{},
+	}
+}
+
+// Set records that the drone is pending since the given time.
+func (c *pendingCollector) Set(drone types.NamespacedName, since time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.since[drone] = since
+}
+
+// Delete stops reporting the drone.
+func (c *pendingCollector) Delete(drone types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.since, drone)
+}
+
+// Describe implements prometheus.Collector.
+func (c *pendingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.
+func (c *pendingCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for drone, since := range c.since {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue,
+			c.clock.Since(since).Seconds(), drone.Namespace, drone.Name)
+	}
+}
+
+// occupancyCollector reports how many drone pods each drone node carries. It
+// counts from the cache at scrape time, so the numbers follow the pods as
+// they come and go without any bookkeeping in the reconciles. Drone nodes are
+// those of the drone role and those the node selectors of swarms pick.
+type occupancyCollector struct {
+	desc   *prometheus.Desc
+	reader client.Reader
+	log    logr.Logger
+}
+
+func newOccupancyCollector(reader client.Reader, log logr.Logger) *occupancyCollector {
+	return &occupancyCollector{
+		desc: prometheus.NewDesc("drone_node_occupancy",
+			"How many drones run on a drone node.",
+			[]string{"node"}, nil),
+		reader: reader,
+		log:    log,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *occupancyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.
+func (c *occupancyCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	// drone nodes without drones are reported too, they're the other end of
+	// an imbalance
+	perNode := map[string]int{}
+	swarms := experimentsv1.SwarmList{}
+	if err := c.reader.List(ctx, &swarms); err != nil {
+		c.log.Error(err, "failed to list swarms for metrics")
+		return
+	}
+	selectors := []labels.Selector{labels.SelectorFromSet(labels.Set(droneNodeSelector(nil, nil)))}
+	for _, s := range swarms.Items {
+		selectors = append(selectors, labels.SelectorFromSet(labels.Set(droneNodeSelector(s.Spec.NodeSelector, s.Spec.Template.RequiredNodeLabels))))
+	}
+	nodes := core.NodeList{}
+	if err := c.reader.List(ctx, &nodes); err != nil {
+		c.log.Error(err, "failed to list drone nodes for metrics")
+		return
+	}
+	for _, n := range nodes.Items {
+		for _, selector := range selectors {
+			if selector.Matches(labels.Set(n.Labels)) {
+				perNode[n.Name] = 0
+				break
+			}
+		}
+	}
+	isDrone, err := labels.NewRequirement(experimentsv1.DroneNameLabel, selection.Exists, nil)
+	if err != nil {
+		c.log.Error(err, "failed to select drone pods for metrics")
+		return
+	}
+	pods := core.PodList{}
+	if err := c.reader.List(ctx, &pods, client.MatchingLabelsSelector{Selector: labels.NewSelector().Add(*isDrone)}); err != nil {
+		c.log.Error(err, "failed to list drone pods for metrics")
+		return
+	}
+	for _, p := range pods.Items {
+		if node := podNode(&p); node != "" && !podTerminated(&p) {
+			perNode[node]++
+		}
+	}
+	for node, drones := range perNode {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(drones), node)
+	}
+}
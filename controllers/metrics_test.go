@@ -0,0 +1,177 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+func TestPendingCollector(t *testing.T) {
+	clock := clock.NewFakeClock(testTime)
+	collector := newPendingCollector(clock)
+	drone := types.NamespacedName{Namespace: testNamespace, Name: "starved"}
+	collector.Set(drone, clock.Now())
+
+	expectPending := func(seconds string) {
+		t.Helper()
+		expected := `
+# HELP drone_pending_seconds How long a drone has been waiting for a free drone node.
+# TYPE drone_pending_seconds gauge
+drone_pending_seconds{drone="starved",namespace="default"} ` + seconds + `
+`
+		if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+			t.Error(err)
+		}
+	}
+	expectPending("0")
+	// the duration grows between reconciles
+	clock.Step(90 * time.Second)
+	expectPending("90")
+
+	collector.Delete(drone)
+	if err := testutil.CollectAndCompare(collector, strings.NewReader("")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReconcilePendingSince(t *testing.T) {
+	r, clock := newDroneReconciler(newDrone("starved"), newDrone("occupant"), dronePod("occupant", "node-1", true), droneNode("node-1"))
+	key := types.NamespacedName{Namespace: testNamespace, Name: "starved"}
+	defer pendingDrones.Delete(key)
+
+	reconcileDrone(t, r, "starved")
+	clock.Step(time.Minute)
+	reconcileDrone(t, r, "starved")
+	since := getDrone(t, r, "starved").Status.PendingSince
+	if since == nil || !since.Time.Equal(testTime) {
+		t.Fatalf("pending since = %v, want the first failed attempt at %v", since, testTime)
+	}
+	if got := pendingSince(key); !got.Equal(testTime) {
+		t.Errorf("exported pending since = %v, want %v", got, testTime)
+	}
+
+	if err := r.Delete(context.Background(), getPod(t, r, "occupant")); err != nil {
+		t.Fatal(err)
+	}
+	reconcileDrone(t, r, "starved")
+	if since := getDrone(t, r, "starved").Status.PendingSince; since != nil {
+		t.Errorf("pending since = %v once scheduled, want it cleared", since)
+	}
+	if got := pendingSince(key); !got.IsZero() {
+		t.Errorf("exported pending since = %v once scheduled, want it gone", got)
+	}
+}
+
+// pendingSince returns since when the exported metric has the drone pending,
+// or the zero time if it doesn't.
+func pendingSince(drone types.NamespacedName) time.Time {
+	pendingDrones.mu.Lock()
+	defer pendingDrones.mu.Unlock()
+	return pendingDrones.since[drone]
+}
+
+func TestReconcileDeletedDroneDropsMetrics(t *testing.T) {
+	tests := []struct {
+		name   string
+		delete func(t *testing.T, r *DroneReconciler, drone *experimentsv1.Drone)
+	}{
+		{name: "being deleted", delete: func(t *testing.T, r *DroneReconciler, drone *experimentsv1.Drone) {
+			now := metav1.NewTime(testTime)
+			drone.DeletionTimestamp = &now
+			updateObject(t, r, drone)
+		}},
+		{name: "gone", delete: func(t *testing.T, r *DroneReconciler, drone *experimentsv1.Drone) {
+			if err := r.Delete(context.Background(), drone); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newDroneReconciler(newDrone("starved"), dronePod("occupant", "node-1", true), droneNode("node-1"))
+			key := types.NamespacedName{Namespace: testNamespace, Name: "starved"}
+			defer pendingDrones.Delete(key)
+
+			reconcileDrone(t, r, "starved")
+			if pendingSince(key).IsZero() {
+				t.Fatal("pending drone isn't exported")
+			}
+			drone := getDrone(t, r, "starved")
+			if !hasFinalizer(drone, finalizerName("", metricsFinalizer)) {
+				t.Fatalf("finalizers = %v, want the metrics one", drone.Finalizers)
+			}
+
+			tt.delete(t, r, drone)
+			reconcileDrone(t, r, "starved")
+			if got := pendingSince(key); !got.IsZero() {
+				t.Errorf("exported pending since = %v once deleted, want it gone", got)
+			}
+			drone = &experimentsv1.Drone{}
+			if err := r.Get(context.Background(), key, drone); err == nil && len(drone.Finalizers) > 0 {
+				t.Errorf("finalizers = %v once deleted, want them removed", drone.Finalizers)
+			}
+		})
+	}
+}
+
+func TestOccupancyCollector(t *testing.T) {
+	other := dronePod("other", "node-1", true)
+	other.Namespace = "elsewhere"
+	done := dronePod("done", "node-2", false)
+	done.Status.Phase = core.PodSucceeded
+	unscheduled := dronePod("unscheduled", "", false)
+	unscheduled.Spec.NodeSelector = nil
+	plain := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: testNamespace},
+		Spec:       core.PodSpec{NodeName: "node-2"},
+	}
+	worker := droneNode("worker")
+	worker.Labels = map[string]string{hostnameLabel: "worker"}
+	gpu := droneNode("gpu-1")
+	gpu.Labels = map[string]string{hostnameLabel: "gpu-1", "pool": "gpu"}
+	// drones of a swarm with a node selector of its own run outside the
+	// drone role
+	selective := newSwarm("selective", 1)
+	selective.Spec.NodeSelector = map[string]string{"pool": "gpu"}
+	c := newFakeClient(clock.NewFakeClock(testTime),
+		droneNode("node-1"), droneNode("node-2"), droneNode("node-3"), worker, gpu, selective,
+		dronePod("a", "node-1", true), dronePod("b", "node-1", false), other, done, unscheduled, plain)
+
+	expected := `
+# HELP drone_node_occupancy How many drones run on a drone node.
+# TYPE drone_node_occupancy gauge
+drone_node_occupancy{node="gpu-1"} 0
+drone_node_occupancy{node="node-1"} 3
+drone_node_occupancy{node="node-2"} 0
+drone_node_occupancy{node="node-3"} 0
+`
+	if err := testutil.CollectAndCompare(newOccupancyCollector(c, logf.NullLogger{}), strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	core "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// droneNodeTaintKey is the key of the taint keeping everything but drones off
+// drone nodes.
+const droneNodeTaintKey = "experiments.mad.md/drone"
+
+// droneNodeToleration lets drone pods onto tainted drone nodes.
+var droneNodeToleration = core.Toleration{
+	Key:      droneNodeTaintKey,
+	Operator: core.TolerationOpExists,
+	Effect:   core.TaintEffectNoSchedule,
+}
+
+// NodeReconciler taints drone nodes so they are reserved for drones
+type NodeReconciler struct {
+	client.Client
+	Log logr.Logger
+}
+
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;update;patch
+
+// Reconcile taints the node if it has the drone role and lacks the taint.
+func (r *NodeReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("Node", req.Name)
+
+	node := core.Node{}
+	if err := r.Get(ctx, req.NamespacedName, &node); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if node.Labels[droneNodeLabel] != "drone" {
+		return ctrl.Result{}, nil
+	}
+	for _, t := range node.Spec.Taints {
+		if t.Key == droneNodeTaintKey {
+			return ctrl.Result{}, nil
+		}
+	}
+
+	log.Info("tainting drone node")
+	node.Spec.Taints = append(node.Spec.Taints, core.Taint{
+		Key:    droneNodeTaintKey,
+		Value:  "true",
+		Effect: core.TaintEffectNoSchedule,
+	})
+	if err := r.Update(ctx, &node); err != nil {
+		log.Error(err, "failed to taint drone node")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager stuff
+func (r *NodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&core.Node{}).
+		Complete(r)
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/sets"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestNodeReconcilerTaintsDroneNodes(t *testing.T) {
+	plain := droneNode("plain")
+	delete(plain.Labels, droneNodeLabel)
+	tainted := droneNode("tainted")
+	tainted.Spec.Taints = []core.Taint{{Key: droneNodeTaintKey, Value: "true", Effect: core.TaintEffectNoSchedule}}
+	c := newFakeClient(clock.NewFakeClock(testTime), droneNode("drone"), plain, tainted)
+	r := &NodeReconciler{Client: c, Log: logf.NullLogger{}}
+
+	tests := []struct {
+		node   string
+		taints int
+	}{
+		{node: "drone", taints: 1},
+		{node: "plain", taints: 0},
+		{node: "tainted", taints: 1},
+		{node: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.node, func(t *testing.T) {
+			// twice, the second time finds the taint in place
+			for i := 0; i < 2; i++ {
+				if _, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: tt.node}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.node == "missing" {
+				return
+			}
+			node := core.Node{}
+			if err := c.Get(context.Background(), client.ObjectKey{Name: tt.node}, &node); err != nil {
+				t.Fatal(err)
+			}
+			if len(node.Spec.Taints) != tt.taints {
+				t.Fatalf("taints = %v, want %d", node.Spec.Taints, tt.taints)
+			}
+			if tt.taints > 0 && !droneNodeToleration.ToleratesTaint(&node.Spec.Taints[0]) {
+				t.Errorf("taint %v isn't tolerated by drone pods", node.Spec.Taints[0])
+			}
+		})
+	}
+}
+
+func TestReconcileToleratesNodeTaint(t *testing.T) {
+	for _, tolerate := range []bool{false, true} {
+		r, _ := newDroneReconciler(newDrone("tolerant"), droneNode("node-1"))
+		r.TolerateNodeTaint = tolerate
+		reconcileDrone(t, r, "tolerant")
+
+		keys := sets.NewString()
+		for _, toleration := range getPod(t, r, "tolerant").Spec.Tolerations {
+			keys.Insert(toleration.Key)
+		}
+		if keys.Has(droneNodeTaintKey) != tolerate {
+			t.Errorf("with TolerateNodeTaint %v the pod tolerations are %v", tolerate, keys.List())
+		}
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+// ordinalName returns the stable name of the drone with the given ordinal.
+func ordinalName(swarmName string, ordinal int) string {
+	return fmt.Sprintf("%s-%d", swarmName, ordinal)
+}
+
+// droneOrdinal returns the ordinal of a drone of the swarm, or -1 if its name
+// doesn't carry one.
+func droneOrdinal(swarmName string, drone *experimentsv1.Drone) int {
+	suffix := strings.TrimPrefix(drone.Name, swarmName+"-")
+	if suffix == drone.Name {
+		return -1
+	}
+	ordinal, err := strconv.Atoi(suffix)
+	if err != nil || ordinal < 0 {
+		return -1
+	}
+	return ordinal
+}
+
+// missingOrdinalNames returns the names of the n lowest ordinals not taken by
+// any of the drones.
+func missingOrdinalNames(swarmName string, drones []experimentsv1.Drone, n int32) []string {
+	taken := map[int]bool{}
+	for i := range drones {
+		taken[droneOrdinal(swarmName, &drones[i])] = true
+	}
+	var names []string
+	for ordinal := 0; int32(len(names)) < n; ordinal++ {
+		if !taken[ordinal] {
+			names = append(names, ordinalName(swarmName, ordinal))
+		}
+	}
+	return names
+}
+
+// orderedScaleDownVictims picks the surplus drones with the highest ordinals,
+// drones without an ordinal first. Like scaleDownVictims it keeps minAvailable
+// drones flying, but it never skips over a drone to get to a lower ordinal.
+func orderedScaleDownVictims(swarmName string, drones []experimentsv1.Drone, surplus, minAvailable int32) ([]experimentsv1.Drone, bool) {
+	sorted := append([]experimentsv1.Drone(nil), drones...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		oi, oj := droneOrdinal(swarmName, &sorted[i]), droneOrdinal(swarmName, &sorted[j])
+		if oi < 0 || oj < 0 {
+			return oi < 0 && oj >= 0
+		}
+		return oi > oj
+	})
+
+	var flying int32
+	for _, d := range sorted {
+		if d.Status.Flying {
+			flying++
+		}
+	}
+
+	var victims []experimentsv1.Drone
+	for _, d := range sorted[:surplus] {
+		if d.Status.Flying {
+			if flying <= minAvailable {
+				return victims, true
+			}
+			flying--
+		}
+		victims = append(victims, d)
+	}
+	return victims, false
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+// ordinalDrones returns drones of the given names, flying unless listed in
+// grounded.
+func ordinalDrones(names []string, grounded ...string) []experimentsv1.Drone {
+	var drones []experimentsv1.Drone
+	for _, name := range names {
+		d := newDrone(name)
+		d.Status.Flying = true
+		for _, g := range grounded {
+			if g == name {
+				d.Status.Flying = false
+			}
+		}
+		drones = append(drones, *d)
+	}
+	return drones
+}
+
+func TestDroneOrdinal(t *testing.T) {
+	tests := []struct {
+		name string
+		want int
+	}{
+		{name: "fleet-0", want: 0},
+		{name: "fleet-12", want: 12},
+		{name: "fleet-x", want: -1},
+		{name: "fleet--1", want: -1},
+		{name: "other-1", want: -1},
+		{name: "fleet", want: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := droneOrdinal("fleet", newDrone(tt.name)); got != tt.want {
+				t.Errorf("droneOrdinal() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingOrdinalNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		taken []string
+		n     int32
+		want  []string
+	}{
+		{name: "none taken", n: 3, want: []string{"fleet-0", "fleet-1", "fleet-2"}},
+		{name: "gaps first", taken: []string{"fleet-0", "fleet-2", "random"}, n: 3, want: []string{"fleet-1", "fleet-3", "fleet-4"}},
+		{name: "nothing missing", taken: []string{"fleet-0"}, n: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := missingOrdinalNames("fleet", ordinalDrones(tt.taken), tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("missingOrdinalNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderedScaleDownVictims(t *testing.T) {
+	all := []string{"fleet-0", "fleet-3", "random", "fleet-1", "fleet-2"}
+	tests := []struct {
+		name         string
+		grounded     []string
+		surplus      int32
+		minAvailable int32
+		want         []string
+		wantPaced    bool
+	}{
+		{name: "highest first", surplus: 2, want: []string{"random", "fleet-3"}},
+		{name: "all", surplus: 5, want: []string{"random", "fleet-3", "fleet-2", "fleet-1", "fleet-0"}},
+		{name: "down to the floor", surplus: 4, minAvailable: 3, want: []string{"random", "fleet-3"}, wantPaced: true},
+		{name: "none at the floor", surplus: 4, minAvailable: 5, wantPaced: true},
+		// grounded drones don't count against the floor, but aren't jumped to
+		{name: "no skipping to grounded drones", grounded: []string{"fleet-0"}, surplus: 5, minAvailable: 3,
+			want: []string{"random"}, wantPaced: true},
+		{name: "grounded drones at the floor", grounded: []string{"random", "fleet-3"}, surplus: 2, minAvailable: 3,
+			want: []string{"random", "fleet-3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			victims, paced := orderedScaleDownVictims("fleet", ordinalDrones(all, tt.grounded...), tt.surplus, tt.minAvailable)
+			if got := droneNames(victims); !reflect.DeepEqual(got, tt.want) || paced != tt.wantPaced {
+				t.Errorf("orderedScaleDownVictims() = %v, %v, want %v, %v", got, paced, tt.want, tt.wantPaced)
+			}
+		})
+	}
+}
+
+func TestReconcileSwarmOrdinal(t *testing.T) {
+	swarm := newSwarm("fleet", 3)
+	swarm.Spec.Ordinal = true
+	r, _ := newSwarmReconciler(swarm)
+
+	reconcileSwarm(t, r, "fleet")
+	if got, want := sortedDroneNames(t, r), []string{"fleet-0", "fleet-1", "fleet-2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("drones = %v, want %v", got, want)
+	}
+
+	swarm = getSwarm(t, r, "fleet")
+	swarm.Spec.HowMany = 5
+	updateObject(t, r, swarm)
+	reconcileSwarm(t, r, "fleet")
+	if got, want := sortedDroneNames(t, r), []string{"fleet-0", "fleet-1", "fleet-2", "fleet-3", "fleet-4"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("drones = %v, want %v", got, want)
+	}
+
+	swarm = getSwarm(t, r, "fleet")
+	swarm.Spec.HowMany = 2
+	updateObject(t, r, swarm)
+	reconcileSwarm(t, r, "fleet")
+	if got, want := sortedDroneNames(t, r), []string{"fleet-0", "fleet-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("drones = %v, want the highest ordinals gone", got)
+	}
+}
+
+// sortedDroneNames returns the sorted names of the drones in the test
+// namespace.
+func sortedDroneNames(t *testing.T, c client.Client) []string {
+	t.Helper()
+	names := droneNames(listDrones(t, c, testNamespace))
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	policy "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+// reconcilePDB creates or updates the PodDisruptionBudget of the swarm, named
+// after it, or deletes the one it controls when the swarm no longer asks for
+// one. howMany is the number of drones the swarm should have.
+func (r *SwarmReconciler) reconcilePDB(ctx context.Context, swarm *experimentsv1.Swarm, namespace string, howMany int32) error {
+	pdb := policy.PodDisruptionBudget{}
+	pdb.Name, pdb.Namespace = swarm.Name, namespace
+
+	if swarm.Spec.PDB == nil {
+		return r.deletePDB(ctx, swarm, namespace)
+	}
+
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, &pdb, func() error {
+		if pdb.Labels == nil {
+			pdb.Labels = map[string]string{}
+		}
+		for k, v := range swarmLabels(swarm, namespace) {
+			pdb.Labels[k] = v
+		}
+		pdb.Spec = pdbSpecForSwarm(swarm, howMany)
+		// like drones, a PDB in another namespace can't be owned by the swarm
+		if namespace == swarm.Namespace {
+			return controllerutil.SetControllerReference(swarm, &pdb, r.Scheme)
+		}
+		return nil
+	})
+	if apierrors.IsAlreadyExists(err) {
+		// a stale cache, the next reconcile updates it
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if op != controllerutil.OperationResultNone {
+		r.Log.Info("reconciled pod disruption budget", "swarm", swarm.Name, "operation", op)
+	}
+	return nil
+}
+
+// deletePDB deletes the PodDisruptionBudget of the swarm in namespace, if
+// there is one belonging to it.
+func (r *SwarmReconciler) deletePDB(ctx context.Context, swarm *experimentsv1.Swarm, namespace string) error {
+	pdb := policy.PodDisruptionBudget{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: swarm.Name}, &pdb); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	// leave PDBs of the same name not created for the swarm alone
+	if metav1.GetControllerOf(&pdb) == nil && pdb.Labels[experimentsv1.SwarmNameLabel] != swarm.Name {
+		return nil
+	}
+	if !ownedBySwarm(&pdb, swarm) {
+		return nil
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, &pdb))
+}
+
+// pdbSpecForSwarm returns the PodDisruptionBudget spec selecting the drone
+// pods of the swarm, which should have howMany drones.
+func pdbSpecForSwarm(swarm *experimentsv1.Swarm, howMany int32) policy.PodDisruptionBudgetSpec {
+	spec := policy.PodDisruptionBudgetSpec{
+		Selector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{experimentsv1.SwarmNameLabel: swarm.Name},
+		},
+	}
+	switch {
+	case swarm.Spec.PDB.MinAvailable != nil:
+		minAvailable := *swarm.Spec.PDB.MinAvailable
+		// a budget above the size of the swarm would block every eviction
+		if minAvailable.Type == intstr.Int && minAvailable.IntVal > howMany {
+			minAvailable = intstr.FromInt(int(howMany))
+		}
+		spec.MinAvailable = &minAvailable
+	case swarm.Spec.PDB.MaxUnavailable != nil:
+		maxUnavailable := *swarm.Spec.PDB.MaxUnavailable
+		spec.MaxUnavailable = &maxUnavailable
+	default:
+		maxUnavailable := intstr.FromInt(1)
+		spec.MaxUnavailable = &maxUnavailable
+	}
+	return spec
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	policy "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+func TestPDBSpecForSwarm(t *testing.T) {
+	intOrString := func(v intstr.IntOrString) *intstr.IntOrString { return &v }
+	tests := []struct {
+		name               string
+		pdb                experimentsv1.PDBSpec
+		howMany            int32
+		wantMinAvailable   *intstr.IntOrString
+		wantMaxUnavailable *intstr.IntOrString
+	}{
+		{name: "default", howMany: 3, wantMaxUnavailable: intOrString(intstr.FromInt(1))},
+		{name: "min available", pdb: experimentsv1.PDBSpec{MinAvailable: intOrString(intstr.FromInt(2))}, howMany: 3,
+			wantMinAvailable: intOrString(intstr.FromInt(2))},
+		{name: "min available above howmany", pdb: experimentsv1.PDBSpec{MinAvailable: intOrString(intstr.FromInt(5))}, howMany: 3,
+			wantMinAvailable: intOrString(intstr.FromInt(3))},
+		{name: "min available percentage", pdb: experimentsv1.PDBSpec{MinAvailable: intOrString(intstr.FromString("50%"))}, howMany: 3,
+			wantMinAvailable: intOrString(intstr.FromString("50%"))},
+		{name: "max unavailable", pdb: experimentsv1.PDBSpec{MaxUnavailable: intOrString(intstr.FromString("25%"))}, howMany: 3,
+			wantMaxUnavailable: intOrString(intstr.FromString("25%"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			swarm := newSwarm("budget", tt.howMany)
+			swarm.Spec.PDB = &tt.pdb
+			spec := pdbSpecForSwarm(swarm, tt.howMany)
+			if !equalIntOrString(spec.MinAvailable, tt.wantMinAvailable) || !equalIntOrString(spec.MaxUnavailable, tt.wantMaxUnavailable) {
+				t.Errorf("minAvailable/maxUnavailable = %v/%v, want %v/%v", spec.MinAvailable, spec.MaxUnavailable, tt.wantMinAvailable, tt.wantMaxUnavailable)
+			}
+			if got := spec.Selector.MatchLabels[experimentsv1.SwarmNameLabel]; got != "budget" {
+				t.Errorf("selector = %v, want the drones of the swarm", spec.Selector)
+			}
+		})
+	}
+}
+
+func equalIntOrString(a, b *intstr.IntOrString) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func TestReconcileSwarmPDB(t *testing.T) {
+	five := intstr.FromInt(5)
+	swarm := newSwarm("budget", 3)
+	swarm.Spec.PDB = &experimentsv1.PDBSpec{MinAvailable: &five}
+	r, _ := newSwarmReconciler(swarm)
+
+	reconcileSwarm(t, r, "budget")
+	pdb := getPDB(t, r, "budget")
+	if pdb.Spec.MinAvailable == nil || pdb.Spec.MinAvailable.IntValue() != 3 {
+		t.Errorf("minAvailable = %v, want it capped at the 3 drones", pdb.Spec.MinAvailable)
+	}
+	if owner := metav1.GetControllerOf(pdb); owner == nil || owner.UID != swarm.UID {
+		t.Errorf("owner = %v, want the swarm", owner)
+	}
+
+	swarm = getSwarm(t, r, "budget")
+	swarm.Spec.HowMany = 6
+	updateObject(t, r, swarm)
+	reconcileSwarm(t, r, "budget")
+	if pdb := getPDB(t, r, "budget"); pdb.Spec.MinAvailable == nil || pdb.Spec.MinAvailable.IntValue() != 5 {
+		t.Errorf("minAvailable = %v after scaling up, want 5", pdb.Spec.MinAvailable)
+	}
+
+	swarm = getSwarm(t, r, "budget")
+	swarm.Spec.PDB = nil
+	updateObject(t, r, swarm)
+	reconcileSwarm(t, r, "budget")
+	err := r.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: "budget"}, &policy.PodDisruptionBudget{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("PDB lookup = %v, want it deleted with the swarm no longer asking for one", err)
+	}
+}
+
+func TestReconcileSwarmLeavesForeignPDB(t *testing.T) {
+	foreign := &policy.PodDisruptionBudget{ObjectMeta: metav1.ObjectMeta{Name: "budget", Namespace: testNamespace}}
+	r, _ := newSwarmReconciler(newSwarm("budget", 1), foreign)
+
+	reconcileSwarm(t, r, "budget")
+	getPDB(t, r, "budget")
+}
+
+// getPDB returns the named PodDisruptionBudget, failing the test if it is
+// missing.
+func getPDB(t *testing.T, c client.Client, name string) *policy.PodDisruptionBudget {
+	t.Helper()
+	pdb := &policy.PodDisruptionBudget{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: name}, pdb); err != nil {
+		t.Fatalf("failed to get PDB %s: %v", name, err)
+	}
+	return pdb
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+// checkPlacement sets the HeterogeneousPlacement condition of a swarm with
+// HomogeneousBy, and warns when its drones just ended up on nodes with
+// differing values of that label. Nodes lacking the label count as a value of
+// their own.
+func (r *SwarmReconciler) checkPlacement(ctx context.Context, swarm *experimentsv1.Swarm, drones []experimentsv1.Drone, pool *dronePool) error {
+	key := swarm.Spec.HomogeneousBy
+	if key == "" {
+		return nil
+	}
+	names := map[string]bool{}
+	for _, d := range drones {
+		names[d.Name] = true
+	}
+	nodes := map[string]*core.Node{}
+	for i := range pool.Nodes {
+		nodes[pool.Nodes[i].Name] = &pool.Nodes[i]
+	}
+	values := map[string]bool{}
+	for i := range pool.Pods {
+		pod := &pool.Pods[i]
+		name := podNode(pod)
+		if name == "" || podTerminated(pod) || !names[pod.Labels[experimentsv1.DroneNameLabel]] {
+			continue
+		}
+		// pods left to their scheduler may run outside the drone pool
+		node, ok := nodes[name]
+		if !ok {
+			node = &core.Node{}
+			if err := r.Get(ctx, client.ObjectKey{Name: name}, node); apierrors.IsNotFound(err) {
+				// the pod is about to go with its node
+				continue
+			} else if err != nil {
+				return err
+			}
+			nodes[name] = node
+		}
+		values[node.Labels[key]] = true
+	}
+
+	now := metav1.NewTime(r.Clock.Now())
+	if len(values) <= 1 {
+		setSwarmCondition(&swarm.Status, experimentsv1.SwarmHeterogeneousPlacement, core.ConditionFalse, "SinglePool", "", now)
+		return nil
+	}
+	var seen []string
+	for v := range values {
+		if v == "" {
+			v = "<none>"
+		}
+		seen = append(seen, v)
+	}
+	sort.Strings(seen)
+	msg := fmt.Sprintf("drones run on nodes with %s %s", key, strings.Join(seen, ", "))
+	if !swarmConditionTrue(&swarm.Status, experimentsv1.SwarmHeterogeneousPlacement) {
+		r.Recorder.Event(swarm, core.EventTypeWarning, string(experimentsv1.SwarmHeterogeneousPlacement), msg)
+	}
+	setSwarmCondition(&swarm.Status, experimentsv1.SwarmHeterogeneousPlacement, core.ConditionTrue, "MultiplePools", msg, now)
+	return nil
+}
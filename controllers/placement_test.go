@@ -0,0 +1,131 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+// pooledNode returns a drone node in the given pool, or in none if empty.
+func pooledNode(name, pool string) *core.Node {
+	node := droneNode(name)
+	if pool != "" {
+		node.Labels["pool"] = pool
+	}
+	return node
+}
+
+func TestCheckPlacement(t *testing.T) {
+	done := dronePod("done", "b-1", false)
+	done.Status.Phase = core.PodSucceeded
+	tests := []struct {
+		name    string
+		pods    []*core.Pod
+		status  core.ConditionStatus
+		message string
+	}{
+		{name: "single pool", pods: []*core.Pod{dronePod("fleet-0", "a-1", true), dronePod("fleet-1", "a-2", true)},
+			status: core.ConditionFalse},
+		{name: "mixed pools", pods: []*core.Pod{dronePod("fleet-0", "a-1", true), dronePod("fleet-1", "b-1", true)},
+			status: core.ConditionTrue, message: "drones run on nodes with pool a, b"},
+		{name: "unlabelled node", pods: []*core.Pod{dronePod("fleet-0", "a-1", true), dronePod("fleet-1", "bare", true)},
+			status: core.ConditionTrue, message: "drones run on nodes with pool <none>, a"},
+		{name: "node outside the pool", pods: []*core.Pod{dronePod("fleet-0", "a-1", true), dronePod("fleet-1", "outside", true)},
+			status: core.ConditionTrue, message: "drones run on nodes with pool a, c"},
+		{name: "missing node", pods: []*core.Pod{dronePod("fleet-0", "a-1", true), dronePod("fleet-1", "gone", true)},
+			status: core.ConditionFalse},
+		{name: "pods of others", pods: []*core.Pod{dronePod("fleet-0", "a-1", true), dronePod("stray", "b-1", true), done},
+			status: core.ConditionFalse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			swarm := newSwarm("fleet", 2)
+			swarm.Spec.HomogeneousBy = "pool"
+			r, _ := newSwarmReconciler(pooledNode("outside", "c"))
+			pool := &dronePool{}
+			for _, n := range []*core.Node{pooledNode("a-1", "a"), pooledNode("a-2", "a"), pooledNode("b-1", "b"), pooledNode("bare", "")} {
+				pool.Nodes = append(pool.Nodes, *n)
+			}
+			for _, p := range tt.pods {
+				pool.Pods = append(pool.Pods, *p)
+			}
+			drones := []experimentsv1.Drone{*newDrone("fleet-0"), *newDrone("fleet-1"), *newDrone("done")}
+
+			if err := r.checkPlacement(context.Background(), swarm, drones, pool); err != nil {
+				t.Fatal(err)
+			}
+			if len(swarm.Status.Conditions) != 1 {
+				t.Fatalf("conditions = %v, want HeterogeneousPlacement", swarm.Status.Conditions)
+			}
+			if c := swarm.Status.Conditions[0]; c.Type != experimentsv1.SwarmHeterogeneousPlacement || c.Status != tt.status || c.Message != tt.message {
+				t.Errorf("condition = %+v, want %s %q", c, tt.status, tt.message)
+			}
+			want := 0
+			if tt.status == core.ConditionTrue {
+				want = 1
+			}
+			if events := drainEvents(r.Recorder.(*record.FakeRecorder)); len(events) != want {
+				t.Errorf("events = %q, want %d", events, want)
+			}
+
+			// the warning is only given once
+			if err := r.checkPlacement(context.Background(), swarm, drones, pool); err != nil {
+				t.Fatal(err)
+			}
+			if events := drainEvents(r.Recorder.(*record.FakeRecorder)); len(events) != 0 {
+				t.Errorf("events of the second check = %q, want none", events)
+			}
+		})
+	}
+}
+
+func TestCheckPlacementWithoutKey(t *testing.T) {
+	swarm := newSwarm("fleet", 2)
+	r, _ := newSwarmReconciler()
+	pool := &dronePool{Nodes: []core.Node{*pooledNode("a-1", "a"), *pooledNode("b-1", "b")},
+		Pods: []core.Pod{*dronePod("fleet-0", "a-1", true), *dronePod("fleet-1", "b-1", true)}}
+	if err := r.checkPlacement(context.Background(), swarm, []experimentsv1.Drone{*newDrone("fleet-0"), *newDrone("fleet-1")}, pool); err != nil {
+		t.Fatal(err)
+	}
+	if swarm.Status.Conditions != nil {
+		t.Errorf("conditions = %v, want none without homogeneousBy", swarm.Status.Conditions)
+	}
+}
+
+func TestReconcileSwarmHeterogeneousPlacement(t *testing.T) {
+	swarm := newSwarm("fleet", 2)
+	swarm.Spec.Ordinal = true
+	swarm.Spec.HomogeneousBy = "pool"
+	r, clock := newSwarmReconciler(swarm, pooledNode("a-1", "a"), pooledNode("b-1", "b"))
+	dr := droneReconcilerOn(r.Client, clock)
+
+	reconcileSwarm(t, r, "fleet")
+	for _, name := range []string{"fleet-0", "fleet-1"} {
+		reconcileDrone(t, dr, name)
+	}
+	reconcileSwarm(t, r, "fleet")
+	if swarm := getSwarm(t, r, "fleet"); !swarmConditionTrue(&swarm.Status, experimentsv1.SwarmHeterogeneousPlacement) {
+		t.Errorf("conditions = %v, want HeterogeneousPlacement", swarm.Status.Conditions)
+	}
+	expectEvent(t, r.Recorder.(*record.FakeRecorder), "Warning HeterogeneousPlacement drones run on nodes with pool a, b")
+}
@@ -0,0 +1,433 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+// droneNodeLabel marks the nodes drones can fly on, unless a Drone brings its
+// own node selector.
+const droneNodeLabel = "node-role.kubernetes.io/drone"
+
+// hostnameLabel is used to pin drone pods to the node picked for them.
+const hostnameLabel = "kubernetes.io/hostname"
+
+// dronePool is a snapshot of the drone nodes and how many pods each of them
+// already carries.
+type dronePool struct {
+	Nodes []core.Node
+
+	// Pods are the pods of the namespace the pool was listed for, and
+	// PodsPerNode counts them on each node.
+	Pods        []core.Pod
+	PodsPerNode map[string]int32
+
+	// NodePods are the pods of all namespaces on the drone nodes, which all
+	// take their share of the nodes' resources.
+	NodePods []core.Pod
+
+	// TakenPerNode counts the pods holding a drone's place on each node: the
+	// pods of the namespace and the drone pods of all others.
+	TakenPerNode map[string]int32
+
+	// MaxStartingPerNode keeps nodes already starting this many drone pods
+	// from being picked, so the kubelet isn't swamped. Zero means no limit.
+	MaxStartingPerNode int32
+}
+
+// listDronePool lists the nodes matching nodeSelector (or the drone role if
+// empty) that also carry requiredLabels, and the pods running on them. Pods
+// of other namespaces than the given one, all if empty, only count towards
+// the nodes' resources and, if drone pods, their places for drones.
+func listDronePool(ctx context.Context, c client.Client, namespace string, nodeSelector, requiredLabels map[string]string) (*dronePool, error) {
+	selector := droneNodeSelector(nodeSelector, requiredLabels)
+
+	// get list of available nodes that are drones
+	dronenodes := core.NodeList{}
+	if err := c.List(ctx, &dronenodes, selector); err != nil {
+		return nil, err
+	}
+	// pods of any namespace use up the nodes, the cache holds them all
+	// anyway. It has no index on the node name, so they're filtered here.
+	allpods := core.PodList{}
+	if err := c.List(ctx, &allpods); err != nil {
+		return nil, err
+	}
+
+	pool := &dronePool{Nodes: dronenodes.Items, PodsPerNode: map[string]int32{}, TakenPerNode: map[string]int32{}}
+	for _, p := range allpods.Items {
+		if namespace == "" || p.Namespace == namespace {
+			pool.Pods = append(pool.Pods, p)
+		}
+	}
+	nodes := map[string]bool{}
+	for _, n := range dronenodes.Items {
+		nodes[n.Name] = true
+	}
+	for _, p := range allpods.Items {
+		node := podNode(&p)
+		if !nodes[node] {
+			continue
+		}
+		pool.NodePods = append(pool.NodePods, p)
+		// terminated pods don't hold on to their node
+		if podTerminated(&p) {
+			continue
+		}
+		_, drone := p.Labels[experimentsv1.DroneNameLabel]
+		if namespace == "" || p.Namespace == namespace {
+			pool.PodsPerNode[node]++
+			pool.TakenPerNode[node]++
+		} else if drone {
+			pool.TakenPerNode[node]++
+		}
+	}
+	return pool, nil
+}
+
+// droneNodeSelector returns the labels a drone node must carry: those of
+// nodeSelector, or the drone role if empty, and requiredLabels on top.
+func droneNodeSelector(nodeSelector, requiredLabels map[string]string) client.MatchingLabels {
+	selector := client.MatchingLabels{droneNodeLabel: "drone"}
+	if len(nodeSelector) > 0 {
+		selector = client.MatchingLabels{}
+		for k, v := range nodeSelector {
+			selector[k] = v
+		}
+	}
+	for k, v := range requiredLabels {
+		selector[k] = v
+	}
+	return selector
+}
+
+// RestrictToNodesRunning drops the drone nodes not running a pod matching
+// selector.
+func (p *dronePool) RestrictToNodesRunning(selector labels.Selector) {
+	running := map[string]bool{}
+	for _, pod := range p.Pods {
+		if !podTerminated(&pod) && selector.Matches(labels.Set(pod.Labels)) {
+			running[podNode(&pod)] = true
+		}
+	}
+	var nodes []core.Node
+	for _, n := range p.Nodes {
+		if running[n.Name] {
+			nodes = append(nodes, n)
+		}
+	}
+	p.Nodes = nodes
+}
+
+// ExcludeNode drops the named node.
+func (p *dronePool) ExcludeNode(name string) {
+	var nodes []core.Node
+	for _, n := range p.Nodes {
+		if n.Name != name {
+			nodes = append(nodes, n)
+		}
+	}
+	p.Nodes = nodes
+}
+
+// RestrictToNodePools drops the drone nodes matching none of the label sets.
+// Without any, all nodes stay.
+func (p *dronePool) RestrictToNodePools(selectors []map[string]string) {
+	if len(selectors) == 0 {
+		return
+	}
+	var nodes []core.Node
+	for _, n := range p.Nodes {
+		for _, selector := range selectors {
+			if labels.SelectorFromSet(selector).Matches(labels.Set(n.Labels)) {
+				nodes = append(nodes, n)
+				break
+			}
+		}
+	}
+	p.Nodes = nodes
+}
+
+// FreeNodes returns the drone nodes carrying less than maxPerNode pods of the
+// namespace and drone pods of any other. A maxPerNode of zero means one drone
+// per node. Cordoned nodes and nodes under resource pressure are never free,
+// drones placed there would likely get evicted.
+func (p *dronePool) FreeNodes(maxPerNode int32) []core.Node {
+	if maxPerNode <= 0 {
+		maxPerNode = 1
+	}
+	var free []core.Node
+	for _, n := range p.Nodes {
+		if p.TakenPerNode[n.Name] < maxPerNode && !underPressure(&n) && !n.Spec.Unschedulable {
+			free = append(free, n)
+		}
+	}
+	return free
+}
+
+// SchedulableNodes returns how many drone nodes aren't cordoned.
+func (p *dronePool) SchedulableNodes() int {
+	var schedulable int
+	for _, n := range p.Nodes {
+		if !n.Spec.Unschedulable {
+			schedulable++
+		}
+	}
+	return schedulable
+}
+
+// ReadyNodes returns how many drone nodes are Ready.
+func (p *dronePool) ReadyNodes() int32 {
+	var ready int32
+	for _, n := range p.Nodes {
+		for _, c := range n.Status.Conditions {
+			if c.Type == core.NodeReady && c.Status == core.ConditionTrue {
+				ready++
+			}
+		}
+	}
+	return ready
+}
+
+// OccupiedNodes returns how many drone nodes carry a pod of the namespace.
+func (p *dronePool) OccupiedNodes() int {
+	var occupied int
+	for _, n := range p.Nodes {
+		if p.PodsPerNode[n.Name] > 0 {
+			occupied++
+		}
+	}
+	return occupied
+}
+
+// fittingNodes returns the free drone nodes with enough spare resources for
+// requests, or why there are none.
+func (p *dronePool) fittingNodes(maxPerNode int32, requests core.ResourceList) ([]core.Node, error) {
+	if len(p.Nodes) == 0 {
+		return nil, ErrNoDroneNodes
+	}
+	free := p.FreeNodes(maxPerNode)
+	if len(free) == 0 {
+		return nil, ErrNoFreeNode
+	}
+	var fitting []core.Node
+	for _, n := range free {
+		if p.fits(&n, requests) {
+			fitting = append(fitting, n)
+		}
+	}
+	if len(fitting) == 0 {
+		return nil, ErrInsufficientCapacity
+	}
+	if p.MaxStartingPerNode <= 0 {
+		return fitting, nil
+	}
+	starting := p.startingPerNode()
+	var idle []core.Node
+	for _, n := range fitting {
+		if starting[n.Name] < p.MaxStartingPerNode {
+			idle = append(idle, n)
+		}
+	}
+	if len(idle) == 0 {
+		return nil, ErrNodesBusy
+	}
+	return idle, nil
+}
+
+// startingPerNode counts the drone pods of all namespaces on each node that
+// aren't flying yet.
+func (p *dronePool) startingPerNode() map[string]int32 {
+	starting := map[string]int32{}
+	for _, pod := range p.NodePods {
+		if _, ok := pod.Labels[experimentsv1.DroneNameLabel]; !ok || podTerminated(&pod) || podFlying(&pod) {
+			continue
+		}
+		if node := podNode(&pod); node != "" {
+			starting[node]++
+		}
+	}
+	return starting
+}
+
+// FreeNodeFits reports whether the named node is one of the free drone nodes
+// fitting requests.
+func (p *dronePool) FreeNodeFits(name string, maxPerNode int32, requests core.ResourceList) bool {
+	nodes, err := p.fittingNodes(maxPerNode, requests)
+	if err != nil {
+		return false
+	}
+	for _, n := range nodes {
+		if n.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// BestFreeNode returns the free drone node fitting requests with the most
+// spare capacity, so drones don't stack up on nearly full nodes. Spare CPU
+// decides first, spare memory breaks ties.
+func (p *dronePool) BestFreeNode(maxPerNode int32, requests core.ResourceList) (string, error) {
+	nodes, err := p.fittingNodes(maxPerNode, requests)
+	if err != nil {
+		return "", err
+	}
+	var best string
+	var bestCPU, bestMemory int64
+	for _, n := range nodes {
+		cpu, memory := p.spareCapacity(&n)
+		if best == "" || cpu > bestCPU || (cpu == bestCPU && memory > bestMemory) {
+			best, bestCPU, bestMemory = n.Name, cpu, memory
+		}
+	}
+	return best, nil
+}
+
+// BestSpreadNode is like BestFreeNode, but first prefers the nodes of the
+// spreadBy topology domain (e.g. zone) holding the fewest pods with the given
+// labels, so the drones of a swarm are balanced across domains before any of
+// them is filled.
+func (p *dronePool) BestSpreadNode(maxPerNode int32, requests core.ResourceList, spreadBy string, podLabels map[string]string) (string, error) {
+	nodes, err := p.fittingNodes(maxPerNode, requests)
+	if err != nil {
+		return "", err
+	}
+
+	domains := map[string]string{}
+	for _, n := range p.Nodes {
+		domains[n.Name] = n.Labels[spreadBy]
+	}
+	perDomain := map[string]int{}
+	for _, pod := range p.Pods {
+		if podTerminated(&pod) || !hasLabels(pod.Labels, podLabels) {
+			continue
+		}
+		if domain, ok := domains[podNode(&pod)]; ok {
+			perDomain[domain]++
+		}
+	}
+
+	var best string
+	var bestCount int
+	var bestCPU, bestMemory int64
+	for _, n := range nodes {
+		count := perDomain[domains[n.Name]]
+		cpu, memory := p.spareCapacity(&n)
+		if best == "" || count < bestCount ||
+			(count == bestCount && (cpu > bestCPU || (cpu == bestCPU && memory > bestMemory))) {
+			best, bestCount, bestCPU, bestMemory = n.Name, count, cpu, memory
+		}
+	}
+	return best, nil
+}
+
+// hasLabels reports whether labels contains all of want.
+func hasLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// fits reports whether the allocatable resources of the node cover requests on
+// top of what the pods of all namespaces on it request. This holds for extended
+// resources like nvidia.com/gpu too, so nodes without any are never picked
+// for drones requesting them.
+func (p *dronePool) fits(node *core.Node, requests core.ResourceList) bool {
+	for name, want := range requests {
+		if want.IsZero() {
+			continue
+		}
+		spare := node.Status.Allocatable[name].DeepCopy()
+		for _, pod := range p.NodePods {
+			if podNode(&pod) != node.Name || podTerminated(&pod) {
+				continue
+			}
+			for _, c := range pod.Spec.Containers {
+				if used, ok := c.Resources.Requests[name]; ok {
+					spare.Sub(used)
+				}
+			}
+			// the sandbox of pods with a runtime class takes its share too
+			if used, ok := pod.Spec.Overhead[name]; ok {
+				spare.Sub(used)
+			}
+		}
+		if spare.Cmp(want) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// spareCapacity returns the allocatable CPU (in millicores) and memory (in
+// bytes) of the node minus what the pods of all namespaces on it request.
+func (p *dronePool) spareCapacity(node *core.Node) (int64, int64) {
+	cpu := node.Status.Allocatable.Cpu().MilliValue()
+	memory := node.Status.Allocatable.Memory().Value()
+	for _, pod := range p.NodePods {
+		if podNode(&pod) != node.Name || podTerminated(&pod) {
+			continue
+		}
+		for _, c := range pod.Spec.Containers {
+			cpu -= c.Resources.Requests.Cpu().MilliValue()
+			memory -= c.Resources.Requests.Memory().Value()
+		}
+		cpu -= pod.Spec.Overhead.Cpu().MilliValue()
+		memory -= pod.Spec.Overhead.Memory().Value()
+	}
+	return cpu, memory
+}
+
+// podNode returns the node the pod runs on or, if it hasn't been scheduled
+// yet, the node a drone pod is pinned to.
+func podNode(pod *core.Pod) string {
+	if pod.Spec.NodeName != "" {
+		return pod.Spec.NodeName
+	}
+	return pod.Spec.NodeSelector[hostnameLabel]
+}
+
+// underPressure reports whether the node is running low on memory, disk or
+// process IDs.
+func underPressure(node *core.Node) bool {
+	for _, c := range node.Status.Conditions {
+		switch c.Type {
+		case core.NodeMemoryPressure, core.NodeDiskPressure, core.NodePIDPressure:
+			if c.Status == core.ConditionTrue {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// podTerminated reports whether all containers of the pod have terminated for
+// good.
+func podTerminated(pod *core.Pod) bool {
+	return pod.Status.Phase == core.PodSucceeded || pod.Status.Phase == core.PodFailed
+}
@@ -0,0 +1,349 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+func TestListDronePoolCounts(t *testing.T) {
+	other := droneNode("other")
+	delete(other.Labels, droneNodeLabel)
+	done := dronePod("done", "node-3", false)
+	done.Status.Phase = core.PodSucceeded
+	elsewhere := dronePod("elsewhere", "node-3", true)
+	elsewhere.Namespace = "elsewhere"
+	c := newFakeClient(clock.NewFakeClock(testTime),
+		droneNode("node-1"), droneNode("node-2"), droneNode("node-3"), other,
+		dronePod("a", "node-1", true), dronePod("b", "node-1", false), dronePod("c", "other", true),
+		done, elsewhere)
+
+	pool, err := listDronePool(context.Background(), c, testNamespace, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.Nodes) != 3 {
+		t.Errorf("drone nodes = %d, want 3", len(pool.Nodes))
+	}
+	// node-1 carries two pods, terminated pods and those of other
+	// namespaces don't count
+	if got := pool.OccupiedNodes(); got != 1 {
+		t.Errorf("occupied nodes = %d, want 1", got)
+	}
+	// the drone of another namespace takes node-3 all the same
+	if got := len(pool.FreeNodes(1)); got != 1 {
+		t.Errorf("free nodes = %d, want 1", got)
+	}
+	if got := len(pool.FreeNodes(3)); got != 3 {
+		t.Errorf("free nodes with 3 per node = %d, want 3", got)
+	}
+}
+
+func TestPlacementAcrossNamespaces(t *testing.T) {
+	cpu := core.ResourceList{core.ResourceCPU: resource.MustParse("3")}
+	elsewhere := func(pod *core.Pod) *core.Pod {
+		pod.Namespace = "elsewhere"
+		return pod
+	}
+	// a pod of another sort, e.g. of a DaemonSet, in a namespace of its own
+	system := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "kube-system"},
+		Spec: core.PodSpec{NodeName: "node-1", Containers: []core.Container{{Name: "agent",
+			Resources: core.ResourceRequirements{Requests: core.ResourceList{core.ResourceCPU: resource.MustParse("2")}}}}},
+		Status: core.PodStatus{Phase: core.PodRunning},
+	}
+	tests := []struct {
+		name               string
+		pods               []runtime.Object
+		requests           core.ResourceList
+		maxStartingPerNode int32
+		want               error
+	}{
+		{name: "other pods leave the node free", pods: []runtime.Object{system}},
+		{name: "drones of other namespaces take the node", pods: []runtime.Object{elsewhere(dronePod("other", "node-1", true))},
+			want: ErrNoFreeNode},
+		{name: "other pods use up the node", pods: []runtime.Object{system}, requests: cpu, want: ErrInsufficientCapacity},
+		{name: "drones of other namespaces keep the node busy", pods: []runtime.Object{elsewhere(dronePod("other", "node-1", false))},
+			maxStartingPerNode: 1, want: ErrNodesBusy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drone := newDrone("waiting")
+			drone.Spec.Resources.Requests = tt.requests
+			// room for two, so that only starting pods keep a node busy
+			if tt.maxStartingPerNode > 0 {
+				drone.Spec.MaxPerNode = 2
+			}
+			r, _ := newDroneReconciler(append(tt.pods, drone, droneNode("node-1"))...)
+			r.MaxStartingPerNode = tt.maxStartingPerNode
+
+			pool, err := r.placementPool(context.Background(), drone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pool.OccupiedNodes() != 0 {
+				t.Errorf("occupied nodes = %d, want pods of other namespaces left out", pool.OccupiedNodes())
+			}
+			node, err := pickNode(pool, drone)
+			if tt.want == nil && (err != nil || node != "node-1") {
+				t.Errorf("pickNode() = %q, %v, want node-1", node, err)
+			} else if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("pickNode() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileSwarmNodeCounts(t *testing.T) {
+	r, _ := newSwarmReconciler(newSwarm("counted", 0),
+		droneNode("node-1"), droneNode("node-2"), droneNode("node-3"),
+		dronePod("stray", "node-2", true))
+
+	reconcileSwarm(t, r, "counted")
+	status := getSwarm(t, r, "counted").Status
+	if status.AvailableNodes != 2 || status.OccupiedNodes != 1 {
+		t.Errorf("available/occupied nodes = %d/%d, want 2/1", status.AvailableNodes, status.OccupiedNodes)
+	}
+}
+
+func TestBestFreeNode(t *testing.T) {
+	small, big := droneNode("small"), droneNode("big")
+	small.Status.Allocatable[core.ResourceCPU] = resource.MustParse("2")
+	big.Status.Allocatable[core.ResourceCPU] = resource.MustParse("8")
+	busy := dronePod("busy", "big", true)
+	busy.Spec.Containers[0].Resources.Requests = core.ResourceList{core.ResourceCPU: resource.MustParse("7")}
+
+	tests := []struct {
+		name  string
+		nodes []core.Node
+		pods  []core.Pod
+		want  string
+	}{
+		{name: "larger node", nodes: []core.Node{*small, *big}, want: "big"},
+		{name: "larger node listed first", nodes: []core.Node{*big, *small}, want: "big"},
+		{name: "larger node nearly full", nodes: []core.Node{*small, *big}, pods: []core.Pod{*busy}, want: "small"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &dronePool{Nodes: tt.nodes, Pods: tt.pods, NodePods: tt.pods}
+			got, err := pool.BestFreeNode(2, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("BestFreeNode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTerminatedPodFreesNode(t *testing.T) {
+	for _, phase := range []core.PodPhase{core.PodSucceeded, core.PodFailed} {
+		t.Run(string(phase), func(t *testing.T) {
+			done := dronePod("done", "node-1", false)
+			done.Status.Phase = phase
+			r, _ := newDroneReconciler(newDrone("next"), done, droneNode("node-1"))
+
+			pool, err := listDronePool(context.Background(), r, testNamespace, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if free := pool.FreeNodes(1); len(free) != 1 || pool.OccupiedNodes() != 0 {
+				t.Errorf("free/occupied nodes = %d/%d, want 1/0", len(free), pool.OccupiedNodes())
+			}
+
+			reconcileDrone(t, r, "next")
+			if node := podNode(getPod(t, r, "next")); node != "node-1" {
+				t.Errorf("drone is on %q, want the node the %s pod freed", node, phase)
+			}
+		})
+	}
+}
+
+func TestDroneNodeSelector(t *testing.T) {
+	tests := []struct {
+		name         string
+		nodeSelector map[string]string
+		required     map[string]string
+		want         client.MatchingLabels
+	}{
+		{name: "drone role", want: client.MatchingLabels{droneNodeLabel: "drone"}},
+		{name: "required on top of the role", required: map[string]string{"gpu": "true"},
+			want: client.MatchingLabels{droneNodeLabel: "drone", "gpu": "true"}},
+		{name: "node selector instead of the role", nodeSelector: map[string]string{"pool": "blue"},
+			want: client.MatchingLabels{"pool": "blue"}},
+		{name: "required on top of the node selector", nodeSelector: map[string]string{"pool": "blue"}, required: map[string]string{"gpu": "true"},
+			want: client.MatchingLabels{"pool": "blue", "gpu": "true"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := droneNodeSelector(tt.nodeSelector, tt.required); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("droneNodeSelector() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBestSpreadNode(t *testing.T) {
+	var nodes []core.Node
+	for _, name := range []string{"a-1", "a-2", "b-1"} {
+		node := droneNode(name)
+		node.Labels[zoneLabel] = name[:1]
+		nodes = append(nodes, *node)
+	}
+	// a bigger node doesn't outweigh a less crowded zone
+	nodes[1].Status.Allocatable[core.ResourceCPU] = resource.MustParse("16")
+	swarmPod := func(name, node string) core.Pod {
+		pod := dronePod(name, node, true)
+		pod.Labels[experimentsv1.SwarmNameLabel] = "zonal"
+		return *pod
+	}
+	done := swarmPod("done", "b-1")
+	done.Status.Phase = core.PodSucceeded
+
+	tests := []struct {
+		name string
+		pods []core.Pod
+		want string
+	}{
+		{name: "empty zones", want: "a-2"},
+		{name: "less crowded zone", pods: []core.Pod{swarmPod("x", "a-1")}, want: "b-1"},
+		{name: "other swarms don't count", pods: []core.Pod{*dronePod("other", "a-1", true)}, want: "a-2"},
+		{name: "finished pods don't count", pods: []core.Pod{swarmPod("x", "a-1"), done}, want: "b-1"},
+		{name: "balanced zones", pods: []core.Pod{swarmPod("x", "a-1"), swarmPod("y", "b-1")}, want: "a-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &dronePool{Nodes: nodes, Pods: tt.pods, PodsPerNode: map[string]int32{}}
+			got, err := pool.BestSpreadNode(3, nil, zoneLabel, map[string]string{experimentsv1.SwarmNameLabel: "zonal"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("BestSpreadNode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFitsCountsPodOverhead(t *testing.T) {
+	cpu := func(q string) core.ResourceList { return core.ResourceList{core.ResourceCPU: resource.MustParse(q)} }
+	sandboxed := dronePod("sandboxed", "node-1", true)
+	sandboxed.Spec.Containers[0].Resources.Requests = cpu("2")
+	sandboxed.Spec.Overhead = cpu("500m")
+	finished := dronePod("finished", "node-1", false)
+	finished.Spec.Containers[0].Resources.Requests = cpu("4")
+	finished.Status.Phase = core.PodSucceeded
+	pool := &dronePool{Nodes: []core.Node{*droneNode("node-1")}, NodePods: []core.Pod{*sandboxed, *finished}}
+
+	tests := []struct {
+		requests core.ResourceList
+		want     bool
+	}{
+		{requests: cpu("1500m"), want: true},
+		{requests: cpu("1600m")},
+		{requests: core.ResourceList{core.ResourceCPU: resource.MustParse("0")}, want: true},
+	}
+	for _, tt := range tests {
+		if got := pool.fits(&pool.Nodes[0], tt.requests); got != tt.want {
+			t.Errorf("fits(%v) = %v, want %v", tt.requests.Cpu(), got, tt.want)
+		}
+	}
+}
+
+func TestRestrictToNodePools(t *testing.T) {
+	node := func(name string, labels map[string]string) core.Node {
+		n := droneNode(name)
+		for k, v := range labels {
+			n.Labels[k] = v
+		}
+		return *n
+	}
+	nodes := []core.Node{
+		node("gpu", map[string]string{"pool": "gpu"}),
+		node("cpu", map[string]string{"pool": "cpu", "arch": "arm64"}),
+		node("spot", map[string]string{"pool": "spot"}),
+	}
+	for _, tc := range []struct {
+		name      string
+		selectors []map[string]string
+		want      []string
+	}{
+		{name: "no selectors", want: []string{"gpu", "cpu", "spot"}},
+		{name: "one pool", selectors: []map[string]string{{"pool": "gpu"}}, want: []string{"gpu"}},
+		{name: "any pool", selectors: []map[string]string{{"pool": "gpu"}, {"pool": "cpu"}}, want: []string{"gpu", "cpu"}},
+		{name: "all labels of a pool", selectors: []map[string]string{{"pool": "cpu", "arch": "amd64"}, {"pool": "spot"}}, want: []string{"spot"}},
+		{name: "no match", selectors: []map[string]string{{"pool": "tpu"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pool := &dronePool{Nodes: append([]core.Node(nil), nodes...)}
+			pool.RestrictToNodePools(tc.selectors)
+			var got []string
+			for _, n := range pool.Nodes {
+				got = append(got, n.Name)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("nodes = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReconcileNodePools(t *testing.T) {
+	pools := []map[string]string{{"pool": "gpu"}, {"pool": "cpu"}}
+	var objs []runtime.Object
+	for _, name := range []string{"gpu", "cpu", "spot"} {
+		node := droneNode(name)
+		node.Labels["pool"] = name
+		objs = append(objs, node)
+	}
+	for _, name := range []string{"first", "second", "third"} {
+		drone := newDrone(name)
+		drone.Spec.NodePoolSelectors = pools
+		objs = append(objs, drone)
+	}
+	r, _ := newDroneReconciler(objs...)
+
+	placed := map[string]bool{}
+	for _, name := range []string{"first", "second"} {
+		reconcileDrone(t, r, name)
+		placed[podNode(getPod(t, r, name))] = true
+	}
+	if want := map[string]bool{"gpu": true, "cpu": true}; !reflect.DeepEqual(placed, want) {
+		t.Errorf("drones placed on %v, want one on each pool", placed)
+	}
+
+	// the spot node is free, but in neither pool
+	reconcileDrone(t, r, "third")
+	key := client.ObjectKey{Namespace: testNamespace, Name: "third"}
+	if err := r.Get(context.Background(), key, &core.Pod{}); !apierrors.IsNotFound(err) {
+		t.Errorf("pod lookup = %v, want no pod outside the pools", err)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+// ignoreStatusUpdates drops update events of objects of the same type as kind
+// that only changed their status, so a controller writing the status of its
+// objects doesn't trigger another reconcile of them. The generation can't tell
+// as the CRDs have no status subresource, so any write bumps it. Events of
+// other types, e.g. the status of owned objects, pass.
+func ignoreStatusUpdates(kind runtime.Object) predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if reflect.TypeOf(e.ObjectNew) != reflect.TypeOf(kind) {
+				return true
+			}
+			return !equality.Semantic.DeepEqual(specOf(e.ObjectOld), specOf(e.ObjectNew)) ||
+				!sameMetadata(e.MetaOld, e.MetaNew)
+		},
+	}
+}
+
+// specOf returns the spec of a Drone or Swarm.
+func specOf(o runtime.Object) interface{} {
+	switch o := o.(type) {
+	case *experimentsv1.Drone:
+		return &o.Spec
+	case *experimentsv1.Swarm:
+		return &o.Spec
+	}
+	return o
+}
+
+// sameMetadata reports whether the metadata the controllers act on is the
+// same.
+func sameMetadata(old, new metav1.Object) bool {
+	return equality.Semantic.DeepEqual(old.GetLabels(), new.GetLabels()) &&
+		equality.Semantic.DeepEqual(old.GetAnnotations(), new.GetAnnotations()) &&
+		equality.Semantic.DeepEqual(old.GetFinalizers(), new.GetFinalizers()) &&
+		equality.Semantic.DeepEqual(old.GetOwnerReferences(), new.GetOwnerReferences()) &&
+		equality.Semantic.DeepEqual(old.GetDeletionTimestamp(), new.GetDeletionTimestamp())
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+// updateEvent returns the update event of old turning into updated.
+func updateEvent(old, updated runtime.Object) event.UpdateEvent {
+	return event.UpdateEvent{
+		MetaOld: old.(metav1.Object), ObjectOld: old,
+		MetaNew: updated.(metav1.Object), ObjectNew: updated,
+	}
+}
+
+func TestIgnoreStatusUpdates(t *testing.T) {
+	drone := newDrone("watched")
+	drone.Spec.Image = "danacr/drone-pod:v1"
+	now := metav1.NewTime(testTime)
+	tests := []struct {
+		name   string
+		kind   runtime.Object
+		old    runtime.Object
+		update func(runtime.Object)
+		want   bool
+	}{
+		{name: "drone status", kind: &experimentsv1.Drone{}, old: drone, update: func(o runtime.Object) {
+			o.(*experimentsv1.Drone).Status.Phase = experimentsv1.DronePending
+			o.(*experimentsv1.Drone).Generation++
+		}},
+		{name: "drone spec", kind: &experimentsv1.Drone{}, old: drone, update: func(o runtime.Object) {
+			o.(*experimentsv1.Drone).Spec.Image = "danacr/drone-pod:v2"
+		}, want: true},
+		{name: "drone annotation", kind: &experimentsv1.Drone{}, old: drone, update: func(o runtime.Object) {
+			o.(*experimentsv1.Drone).Annotations = map[string]string{experimentsv1.RescheduleAnnotation: "1"}
+		}, want: true},
+		{name: "drone deletion", kind: &experimentsv1.Drone{}, old: drone, update: func(o runtime.Object) {
+			o.(*experimentsv1.Drone).DeletionTimestamp = &now
+		}, want: true},
+		{name: "swarm status", kind: &experimentsv1.Swarm{}, old: newSwarm("watched", 2), update: func(o runtime.Object) {
+			o.(*experimentsv1.Swarm).Status.FlyingDrones = 2
+		}},
+		{name: "swarm spec", kind: &experimentsv1.Swarm{}, old: newSwarm("watched", 2), update: func(o runtime.Object) {
+			o.(*experimentsv1.Swarm).Spec.HowMany = 3
+		}, want: true},
+		{name: "owned pod status", kind: &experimentsv1.Drone{}, old: dronePod("watched", "node-1", false), update: func(o runtime.Object) {
+			o.(*core.Pod).Status.Conditions[0].Status = core.ConditionTrue
+		}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := tt.old.DeepCopyObject()
+			tt.update(updated)
+			if got := ignoreStatusUpdates(tt.kind).Update(updateEvent(tt.old, updated)); got != tt.want {
+				t.Errorf("update passes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileStatusWriteDoesNotRequeue(t *testing.T) {
+	r, _ := newDroneReconciler(newDrone("blocked"), dronePod("occupant", "node-1", true), droneNode("node-1"))
+	defer pendingDrones.Delete(types.NamespacedName{Namespace: testNamespace, Name: "blocked"})
+	reconcileDrone(t, r, "blocked")
+	before := getDrone(t, r, "blocked")
+
+	// the freed node gets the drone its pod, and the drone a new status
+	if err := r.Delete(context.Background(), getPod(t, r, "occupant")); err != nil {
+		t.Fatal(err)
+	}
+	reconcileDrone(t, r, "blocked")
+	after := getDrone(t, r, "blocked")
+	if equality.Semantic.DeepEqual(before.Status, after.Status) {
+		t.Fatalf("drone status stayed %+v, want a status write", before.Status)
+	}
+	if ignoreStatusUpdates(&experimentsv1.Drone{}).Update(updateEvent(before, after)) {
+		t.Error("the status write of the reconcile would trigger another one")
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// NewRateLimiter returns a rate limiter for retrying failed reconciles shaped
+// like the workqueue default: per object exponential backoff from baseDelay
+// up to maxDelay, and an overall qps with burst across objects.
+func NewRateLimiter(baseDelay, maxDelay time.Duration, qps float64, burst int) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(qps), burst)},
+	)
+}
+
+// limitRetries requeues a failed reconcile after the delay picked by limiter
+// rather than handing the error to the controller. The controllers of our
+// controller-runtime version can't be given a rate limiter of their own, so
+// their workqueue is kept out of retries instead. With a nil limiter the
+// workqueue default applies. It must be deferred.
+func limitRetries(log logr.Logger, limiter workqueue.RateLimiter, req ctrl.Request, result *ctrl.Result, err *error) {
+	if limiter == nil {
+		return
+	}
+	if *err == nil {
+		limiter.Forget(req)
+		return
+	}
+	delay := limiter.When(req)
+	log.Error(*err, "reconcile failed, retrying", "after", delay)
+	*result, *err = ctrl.Result{RequeueAfter: delay}, nil
+}
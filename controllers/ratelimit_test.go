@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(time.Second, 4*time.Second, 1000, 1000)
+	drone := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "flaky"}}
+	other := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "other"}}
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		if got := limiter.When(drone); got != want {
+			t.Errorf("delay = %v, want %v", got, want)
+		}
+	}
+	if got := limiter.When(other); got != time.Second {
+		t.Errorf("delay of another drone = %v, want its own backoff from %v", got, time.Second)
+	}
+	limiter.Forget(drone)
+	if got := limiter.When(drone); got != time.Second {
+		t.Errorf("delay after forgetting = %v, want %v", got, time.Second)
+	}
+}
+
+// unavailableClient fails every Get while down.
+type unavailableClient struct {
+	client.Client
+	down bool
+}
+
+func (c *unavailableClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	if c.down {
+		return apierrors.NewServiceUnavailable("the API server is restarting")
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func TestReconcileRetriesThroughRateLimiter(t *testing.T) {
+	r, _ := newDroneReconciler(newDrone("flaky"), droneNode("node-1"))
+	unavailable := &unavailableClient{Client: r.Client, down: true}
+	r.Client = unavailable
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "flaky"}}
+
+	// without a limiter of our own the workqueue gets the error
+	if _, err := r.Reconcile(req); err == nil {
+		t.Fatal("reconcile succeeded against an unavailable API server")
+	}
+
+	r.RateLimiter = NewRateLimiter(time.Second, time.Minute, 1000, 1000)
+	for _, want := range []time.Duration{time.Second, 2 * time.Second} {
+		result, err := r.Reconcile(req)
+		if err != nil || result.RequeueAfter != want {
+			t.Errorf("reconcile = %v, %v, want a retry after %v", result, err, want)
+		}
+	}
+
+	unavailable.down = false
+	reconcileDrone(t, r, "flaky")
+	unavailable.down = true
+	if result, _ := r.Reconcile(req); result.RequeueAfter != time.Second {
+		t.Errorf("retry after %v once the drone reconciled fine, want the backoff reset to %v", result.RequeueAfter, time.Second)
+	}
+}
+
+func TestReconcileSwarmRetriesThroughRateLimiter(t *testing.T) {
+	r, _ := newSwarmReconciler(newSwarm("flaky", 1))
+	r.Client = &unavailableClient{Client: r.Client, down: true}
+	r.RateLimiter = NewRateLimiter(time.Second, time.Minute, 1000, 1000)
+
+	result, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "flaky"}})
+	if err != nil || result.RequeueAfter != time.Second {
+		t.Errorf("reconcile = %v, %v, want a retry after %v", result, err, time.Second)
+	}
+}
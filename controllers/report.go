@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-logr/logr"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+// fleetReportName is the name of the ConfigMap the fleet report is written to.
+const fleetReportName = "drone-fleet-report"
+
+// fleetReportKey is the key of the report in the ConfigMap.
+const fleetReportKey = "fleet.json"
+
+// FleetReporter periodically writes a summary of all swarms and drone nodes
+// to a ConfigMap, for dashboards. It runs on the leader only.
+type FleetReporter struct {
+	client.Client
+	Log logr.Logger
+
+	// Namespace is where the report ConfigMap lives.
+	Namespace string
+
+	// Interval is how often the report is refreshed.
+	Interval time.Duration
+}
+
+// fleetReport is the content of the report.
+type fleetReport struct {
+	Swarms        []swarmReport `json:"swarms"`
+	DroneNodes    int32         `json:"droneNodes"`
+	OccupiedNodes int32         `json:"occupiedNodes"`
+}
+
+// swarmReport is the state of a single swarm in the report.
+type swarmReport struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Desired   int32  `json:"desired"`
+	Flying    int32  `json:"flying"`
+}
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch
+
+// Start implements manager.Runnable.
+func (r *FleetReporter) Start(stop <-chan struct{}) error {
+	wait.Until(func() {
+		if err := r.report(context.Background()); err != nil {
+			r.Log.Error(err, "failed to write fleet report")
+		}
+	}, r.Interval, stop)
+	return nil
+}
+
+// report writes the current state of the fleet.
+func (r *FleetReporter) report(ctx context.Context) error {
+	report, err := r.fleetReport(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	cm := core.ConfigMap{}
+	cm.Name, cm.Namespace = fleetReportName, r.Namespace
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, &cm, func() error {
+		cm.Data = map[string]string{fleetReportKey: string(data)}
+		return nil
+	})
+	return err
+}
+
+// fleetReport summarizes the swarms and drone nodes of the cluster.
+func (r *FleetReporter) fleetReport(ctx context.Context) (*fleetReport, error) {
+	swarms := experimentsv1.SwarmList{}
+	if err := r.List(ctx, &swarms); err != nil {
+		return nil, err
+	}
+	pool, err := listDronePool(ctx, r.Client, "", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &fleetReport{
+		Swarms:        []swarmReport{},
+		DroneNodes:    int32(len(pool.Nodes)),
+		OccupiedNodes: int32(pool.OccupiedNodes()),
+	}
+	for _, s := range swarms.Items {
+		report.Swarms = append(report.Swarms, swarmReport{
+			Namespace: s.Namespace,
+			Name:      s.Name,
+			Desired:   s.Spec.HowMany,
+			Flying:    s.Status.FlyingDrones,
+		})
+	}
+	return report, nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestFleetReport(t *testing.T) {
+	blue := newSwarm("blue", 3)
+	blue.Status.FlyingDrones = 2
+	red := newSwarm("red", 1)
+	red.Namespace = "team-red"
+	elsewhere := dronePod("elsewhere", "node-2", true)
+	elsewhere.Namespace = "team-red"
+	c := newFakeClient(clock.NewFakeClock(testTime), blue, red,
+		droneNode("node-1"), droneNode("node-2"), droneNode("node-3"),
+		dronePod("a", "node-1", true), dronePod("b", "node-1", true), elsewhere)
+	r := &FleetReporter{Client: c, Log: logf.NullLogger{}, Namespace: "monitoring"}
+
+	// twice, the second time updates the report
+	for i := 0; i < 2; i++ {
+		if err := r.report(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cm := core.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "monitoring", Name: fleetReportName}, &cm); err != nil {
+		t.Fatal(err)
+	}
+	report := fleetReport{}
+	if err := json.Unmarshal([]byte(cm.Data[fleetReportKey]), &report); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(report.Swarms, func(i, j int) bool { return report.Swarms[i].Name < report.Swarms[j].Name })
+	want := fleetReport{
+		Swarms: []swarmReport{
+			{Namespace: testNamespace, Name: "blue", Desired: 3, Flying: 2},
+			{Namespace: "team-red", Name: "red", Desired: 1},
+		},
+		// pods of all namespaces count
+		DroneNodes:    3,
+		OccupiedNodes: 2,
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+// restartVictims picks the drones to recreate for the swarm's restartedAt,
+// out of those created before it. Drones that aren't flying go right away.
+// Flying ones go while more than minAvailable drones keep flying and fewer
+// than maxInFlight (if set) drones are being replaced; once at that floor, a
+// single one goes per pass while no other is being replaced. It also reports
+// how many outdated drones remain after the victims.
+func restartVictims(drones []experimentsv1.Drone, restartedAt string, minAvailable, maxInFlight int32) ([]experimentsv1.Drone, int32) {
+	var flying, victims []experimentsv1.Drone
+	var flyingCount, inFlight int32
+	for _, d := range drones {
+		if d.Status.Flying {
+			flyingCount++
+		}
+		switch {
+		case !outdatedDrone(&d, restartedAt):
+			if startingUp(&d) {
+				inFlight++
+			}
+		case d.DeletionTimestamp != nil:
+			// on its way out, its replacement is coming up
+			inFlight++
+		case d.Status.Flying:
+			flying = append(flying, d)
+		default:
+			victims = append(victims, d)
+		}
+	}
+
+	allowed := flyingCount - minAvailable
+	if maxInFlight > 0 && maxInFlight-inFlight < allowed {
+		allowed = maxInFlight - inFlight
+	}
+	if allowed < 1 && inFlight == 0 && len(victims) == 0 {
+		allowed = 1
+	}
+	if allowed < 0 {
+		allowed = 0
+	} else if allowed > int32(len(flying)) {
+		allowed = int32(len(flying))
+	}
+	victims = append(victims, flying[:allowed]...)
+	return victims, int32(len(flying)) - allowed
+}
+
+// withoutRestarted drops the outdated drones that are being deleted, or were
+// just deleted, so their replacements get created right away.
+func withoutRestarted(drones []experimentsv1.Drone, restartedAt string, deleted []experimentsv1.Drone) []experimentsv1.Drone {
+	gone := map[string]bool{}
+	for _, d := range deleted {
+		gone[d.Name] = true
+	}
+	var kept []experimentsv1.Drone
+	for _, d := range drones {
+		if outdatedDrone(&d, restartedAt) && (d.DeletionTimestamp != nil || gone[d.Name]) {
+			continue
+		}
+		kept = append(kept, d)
+	}
+	return kept
+}
+
+// outdatedDrone reports whether the drone was created before the swarm's
+// last restart.
+func outdatedDrone(drone *experimentsv1.Drone, restartedAt string) bool {
+	return drone.Annotations[experimentsv1.RestartedAtAnnotation] != restartedAt
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+// restartDrones returns drones named after their state and position: f
+// flying, p pending and d being deleted drones of before the restart, F
+// flying and P starting drones of after it.
+func restartDrones(states string) []experimentsv1.Drone {
+	var drones []experimentsv1.Drone
+	for i, s := range states {
+		d := newDrone(string(s) + string(rune('0'+i)))
+		d.Annotations = map[string]string{experimentsv1.RestartedAtAnnotation: "v1"}
+		switch s {
+		case 'F', 'P':
+			d.Annotations[experimentsv1.RestartedAtAnnotation] = "v2"
+		case 'd':
+			d.DeletionTimestamp = &metav1.Time{Time: testTime}
+		}
+		d.Status.Flying = s == 'f' || s == 'F' || s == 'd'
+		drones = append(drones, *d)
+	}
+	return drones
+}
+
+func TestRestartVictims(t *testing.T) {
+	tests := []struct {
+		name         string
+		states       string
+		minAvailable int32
+		maxInFlight  int32
+		want         []string
+		wantOutdated int32
+	}{
+		{name: "all at once", states: "fpf", want: []string{"p1", "f0", "f2"}},
+		{name: "down to the floor", states: "ffff", minAvailable: 3, want: []string{"f0"}, wantOutdated: 3},
+		{name: "one at a time at the floor", states: "fff", minAvailable: 3, want: []string{"f0"}, wantOutdated: 2},
+		{name: "waiting for a replacement", states: "fffP", minAvailable: 3, wantOutdated: 3},
+		{name: "not flying ones instead at the floor", states: "fffp", minAvailable: 3, want: []string{"p3"}, wantOutdated: 3},
+		{name: "in flight cap", states: "ffffFF", maxInFlight: 2, want: []string{"f0", "f1"}, wantOutdated: 2},
+		{name: "deleting counts as in flight", states: "ffffd", maxInFlight: 2, want: []string{"f0"}, wantOutdated: 3},
+		{name: "all restarted", states: "FFF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			victims, outdated := restartVictims(restartDrones(tt.states), "v2", tt.minAvailable, tt.maxInFlight)
+			if got := droneNames(victims); !reflect.DeepEqual(got, tt.want) || outdated != tt.wantOutdated {
+				t.Errorf("restartVictims() = %v, %d, want %v, %d", got, outdated, tt.want, tt.wantOutdated)
+			}
+		})
+	}
+}
+
+func TestReconcileSwarmRestart(t *testing.T) {
+	swarm := newSwarm("fleet", 3)
+	swarm.Spec.MinAvailable = 2
+	r, _ := newSwarmReconciler(swarm, droneNode("node-1"))
+	fly := func(d *experimentsv1.Drone) {
+		d.Status.Phase, d.Status.Flying = experimentsv1.DroneRunning, true
+	}
+
+	reconcileSwarm(t, r, "fleet")
+	setDroneStatuses(t, r, fly)
+	before := map[string]bool{}
+	for _, d := range listDrones(t, r, testNamespace) {
+		before[d.Name] = true
+	}
+
+	swarm = getSwarm(t, r, "fleet")
+	swarm.Annotations = map[string]string{experimentsv1.RestartedAtAnnotation: "2020-01-01T00:00:00Z"}
+	updateObject(t, r, swarm)
+	reconcileSwarm(t, r, "fleet")
+	swarm = getSwarm(t, r, "fleet")
+	if swarm.Status.OutdatedDrones != 2 || !swarmConditionTrue(&swarm.Status, experimentsv1.SwarmRestarting) {
+		t.Errorf("outdated drones = %d, conditions = %v, want 2 and Restarting", swarm.Status.OutdatedDrones, swarm.Status.Conditions)
+	}
+
+	// each pass recreates a drone once the last replacement is flying
+	for pass := 0; pass < 2; pass++ {
+		setDroneStatuses(t, r, fly)
+		reconcileSwarm(t, r, "fleet")
+	}
+	drones := listDrones(t, r, testNamespace)
+	if len(drones) != 3 {
+		t.Fatalf("drones = %v, want 3", droneNames(drones))
+	}
+	for _, d := range drones {
+		if before[d.Name] || d.Annotations[experimentsv1.RestartedAtAnnotation] != "2020-01-01T00:00:00Z" {
+			t.Errorf("drone %s with annotations %v wasn't recreated", d.Name, d.Annotations)
+		}
+	}
+	swarm = getSwarm(t, r, "fleet")
+	if swarm.Status.OutdatedDrones != 0 || swarmConditionTrue(&swarm.Status, experimentsv1.SwarmRestarting) {
+		t.Errorf("outdated drones = %d, conditions = %v, want the restart done", swarm.Status.OutdatedDrones, swarm.Status.Conditions)
+	}
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// syncJitter is the largest fraction of the sync period added to the resync
+// of a single object.
+const syncJitter = 0.1
+
+// requeueForSync brings an object that reconciled fine back after the sync
+// period plus a jitter of up to syncJitter of it, unless it comes back
+// sooner anyway. The jitter is drawn anew on every reconcile, so objects
+// spread their resyncs out rather than coming back together. A zero period
+// leaves the result alone. It must be deferred, and only once the object is
+// known to exist so deleted ones don't keep coming back.
+func requeueForSync(period time.Duration, result *ctrl.Result, err *error) {
+	if period <= 0 || *err != nil || result.Requeue {
+		return
+	}
+	after := wait.Jitter(period, syncJitter)
+	if result.RequeueAfter == 0 || result.RequeueAfter > after {
+		result.RequeueAfter = after
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestRequeueForSync(t *testing.T) {
+	const period = 10 * time.Hour
+	tests := []struct {
+		name      string
+		period    time.Duration
+		result    ctrl.Result
+		err       error
+		wantSync  bool
+		wantAfter time.Duration
+	}{
+		{name: "no period"},
+		{name: "done", period: period, wantSync: true},
+		{name: "back sooner anyway", period: period, result: ctrl.Result{RequeueAfter: time.Minute}, wantAfter: time.Minute},
+		{name: "back later", period: period, result: ctrl.Result{RequeueAfter: 24 * time.Hour}, wantSync: true},
+		{name: "requeued", period: period, result: ctrl.Result{Requeue: true}},
+		{name: "failed", period: period, err: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.result, tt.err
+			requeueForSync(tt.period, &result, &err)
+			if err != tt.err {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+			if !tt.wantSync {
+				if result.RequeueAfter != tt.wantAfter {
+					t.Errorf("requeue after = %v, want %v", result.RequeueAfter, tt.wantAfter)
+				}
+				return
+			}
+			if result.RequeueAfter < period || result.RequeueAfter > period+period/10 {
+				t.Errorf("requeue after = %v, want within 10%% above %v", result.RequeueAfter, period)
+			}
+		})
+	}
+}
+
+func TestReconcileResyncsAreSpread(t *testing.T) {
+	const period = time.Hour
+	drone := newDrone("steady")
+	r, _ := newDroneReconciler(drone, droneNode("node-1"))
+	r.SyncPeriod = period
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "steady"}}
+
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 5; i++ {
+		result, err := r.Reconcile(req)
+		if err != nil {
+			t.Fatalf("reconcile failed: %v", err)
+		}
+		if result.RequeueAfter < period || result.RequeueAfter > period+period/10 {
+			t.Fatalf("requeue after = %v, want within 10%% above %v", result.RequeueAfter, period)
+		}
+		seen[result.RequeueAfter] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("resyncs = %v, want a jitter drawn on every reconcile", seen)
+	}
+
+	swarm := newSwarm("steady", 1)
+	sr, _ := newSwarmReconciler(swarm, droneNode("node-1"))
+	sr.SyncPeriod = period
+	result, err := sr.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "steady"}})
+	if err != nil || result.RequeueAfter < period || result.RequeueAfter > period+period/10 {
+		t.Errorf("swarm reconcile = %v, %v, want a resync within 10%% above %v", result, err, period)
+	}
+
+	// deleted objects don't keep coming back
+	gone := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "gone"}}
+	if result, err := r.Reconcile(gone); err != nil || result.RequeueAfter != 0 {
+		t.Errorf("reconcile of a deleted drone = %v, %v, want no resync", result, err)
+	}
+}
@@ -17,30 +17,110 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
 	"strings"
+	"time"
 
 	experimentsv1 "github.com/danacr/drone/api/v1"
 	"github.com/docker/docker/pkg/namesgenerator"
 	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
+	core "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
+// inFlightRequeueDelay is how long a swarm waits for its drones to take off
+// when it hit the in-flight limit.
+const inFlightRequeueDelay = 10 * time.Second
+
+// scaleDownRequeueDelay is how long a swarm held at MinAvailable waits before
+// it looks for drones to delete again.
+const scaleDownRequeueDelay = 10 * time.Second
+
+// createRateRequeueDelay is how long a swarm waits, give or take jitter, when
+// the drone creation rate limit held it back.
+const createRateRequeueDelay = time.Second
+
+// deadlineExceededReason is the reason of pods killed for running past their
+// active deadline.
+const deadlineExceededReason = "DeadlineExceeded"
+
 // SwarmReconciler reconciles a Swarm object
 type SwarmReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// MaxInFlight caps how many drones of a swarm may be on their way up (not
+	// flying yet) at the same time. Zero means no limit.
+	MaxInFlight int32
+
+	// Timeout bounds a single reconcile. Zero means no timeout.
+	Timeout time.Duration
+
+	// SyncPeriod is how often each object is reconciled even when nothing
+	// changed, plus up to 10% jitter. Zero leaves it to the watches.
+	SyncPeriod time.Duration
+
+	// CreateLimiter caps the rate of drone creations across all swarms, so
+	// swarms reconciling at once (e.g. after a restart) don't create
+	// thousands of drones in a burst. Nil means no limit.
+	CreateLimiter *rate.Limiter
+
+	// Clock tells the time, the wall clock unless set.
+	Clock clock.Clock
+
+	// FinalizerPrefix is the domain of the finalizer put on Swarms,
+	// DefaultFinalizerPrefix if empty.
+	FinalizerPrefix string
+
+	// RateLimiter spaces out the retries of failed reconciles, the
+	// workqueue default if nil.
+	RateLimiter workqueue.RateLimiter
+
+	// stop is closed when the manager shuts down
+	stop <-chan struct{}
 }
 
 // +kubebuilder:rbac:groups=experiments.mad.md,resources=swarms;drones,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=experiments.mad.md,resources=swarms/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=nodes;pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile stuff
-func (r *SwarmReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	ctx := context.Background()
+func (r *SwarmReconciler) Reconcile(req ctrl.Request) (result ctrl.Result, err error) {
+	log := r.Log.WithValues("Swarm", req.NamespacedName)
+	defer limitRetries(log, r.RateLimiter, req, &result, &err)
+	defer recoverReconcile(log, &err)
+	return r.reconcile(req)
+}
+
+// reconcile brings the drones of the swarm in line with it.
+func (r *SwarmReconciler) reconcile(req ctrl.Request) (result ctrl.Result, err error) {
+	ctx, cancel := reconcileContext(r.Timeout, r.stop)
+	defer cancel()
 	log := r.Log.WithValues("Swarm", req.NamespacedName)
 
 	// your logic here
@@ -52,58 +132,562 @@ func (r *SwarmReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		// resource is created in future.
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	// resync it every SyncPeriod even when nothing changes
+	defer requeueForSync(r.SyncPeriod, &result, &err)
+	// only write the status back if it changed
+	original := swarm.Status.DeepCopy()
+
+	finalizer := finalizerName(r.FinalizerPrefix, droneCleanupFinalizer)
+	if swarm.DeletionTimestamp != nil {
+		// drones in other namespaces aren't garbage collected with the swarm
+		if hasFinalizer(&swarm, finalizer) {
+			log.Info("deleting drones of the swarm in its target namespace")
+			if err := r.deleteAll(ctx, &swarm); err != nil {
+				log.Error(err, "failed to clean up after swarm")
+				return ctrl.Result{}, err
+			}
+			controllerutil.RemoveFinalizer(&swarm, finalizer)
+			if err := r.Update(ctx, &swarm); err != nil {
+				log.Error(err, "failed to remove finalizer")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+	if swarmNamespace(&swarm) != swarm.Namespace && !hasFinalizer(&swarm, finalizer) {
+		controllerutil.AddFinalizer(&swarm, finalizer)
+		if err := r.Update(ctx, &swarm); err != nil {
+			log.Error(err, "failed to add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if msg := invalidSwarmSpec(&swarm.Spec); msg != "" {
+		// a spec update brings the swarm back
+		log.Info("invalid swarm spec, leaving it alone", "reason", msg)
+		r.Recorder.Event(&swarm, core.EventTypeWarning, reasonInvalidSpec, msg)
+		return ctrl.Result{}, nil
+	}
+
+	howMany, err := r.desiredDrones(ctx, &swarm)
+	if errors.Is(err, errInvalidHowMany) {
+		// a ConfigMap update brings the swarm back
+		log.Info("invalid drone count in ConfigMap, leaving swarm alone", "reason", err.Error())
+		r.Recorder.Event(&swarm, core.EventTypeWarning, reasonInvalidSpec, err.Error())
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		log.Error(err, "failed to read drone count")
+		return ctrl.Result{}, err
+	}
+
+	namespace := swarmNamespace(&swarm)
+	if swarm.Spec.TargetNamespace != "" {
+		ns := core.Namespace{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Name: swarm.Spec.TargetNamespace}, &ns); err != nil {
+			log.Error(err, "failed to get target namespace", "namespace", swarm.Spec.TargetNamespace)
+			return ctrl.Result{}, err
+		}
+	}
 
 	log.Info("Do we have enough drones?")
 
 	drones := experimentsv1.DroneList{}
-	if err := r.List(ctx, &drones); err != nil {
+	if drones.Items, err = DronesForSwarm(ctx, r.Client, &swarm); err != nil {
 		return ctrl.Result{}, err
 	}
+	var unmanaged []experimentsv1.Drone
+	drones.Items, unmanaged = splitUnmanaged(drones.Items)
 
-	if int32(len(drones.Items)) < *swarm.Spec.HowMany {
-		log.Info("Not enough, must create drones")
+	// cordoned swarms couldn't create the replacements
+	if swarm.Spec.FailurePolicy == experimentsv1.FailurePolicyReplace && !swarm.Spec.Cordoned {
+		var alive []experimentsv1.Drone
+		for _, d := range drones.Items {
+			// drones past their deadline are done, replacing them would
+			// only start the same countdown over and over
+			if d.Status.Phase != experimentsv1.DroneFailed || d.Status.Reason == deadlineExceededReason {
+				alive = append(alive, d)
+				continue
+			}
+			log.Info("replacing failed drone", "drone", d.Name)
+			if err := r.Delete(ctx, &d); client.IgnoreNotFound(err) != nil {
+				log.Error(err, "failed to delete failed drone")
+				return ctrl.Result{}, err
+			}
+		}
+		drones.Items = alive
+	}
 
-		name := strings.ReplaceAll(namesgenerator.GetRandomName(0), "_", "-")
+	result = ctrl.Result{}
+	if !swarm.Spec.Suspend && !swarm.Spec.Cordoned {
+		restartedAt := swarm.Annotations[experimentsv1.RestartedAtAnnotation]
+		victims, outdated := restartVictims(drones.Items, restartedAt, swarm.Spec.MinAvailable, r.MaxInFlight)
+		for i := range victims {
+			log.Info("recreating drone for restart", "drone", victims[i].Name)
+			if err := r.Delete(ctx, &victims[i]); client.IgnoreNotFound(err) != nil {
+				log.Error(err, "failed to delete outdated drone")
+				return ctrl.Result{}, err
+			}
+		}
+		// ordinal replacements have to wait for the name to be free
+		if !swarm.Spec.Ordinal {
+			drones.Items = withoutRestarted(drones.Items, restartedAt, victims)
+		}
+		swarm.Status.OutdatedDrones = outdated
+		if outdated > 0 {
+			result.RequeueAfter = scaleDownRequeueDelay
+			setSwarmCondition(&swarm.Status, experimentsv1.SwarmRestarting, core.ConditionTrue, "RecreatingDrones",
+				fmt.Sprintf("%d drones left to recreate", outdated), metav1.NewTime(r.Clock.Now()))
+		} else if restartedAt != "" {
+			setSwarmCondition(&swarm.Status, experimentsv1.SwarmRestarting, core.ConditionFalse, "DronesRecreated", "", metav1.NewTime(r.Clock.Now()))
+		}
+	}
 
-		drone := experimentsv1.Drone{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      name,
-				Namespace: req.Namespace,
-			},
+	pool, err := listDronePool(ctx, r.Client, namespace, swarm.Spec.NodeSelector, swarm.Spec.Template.RequiredNodeLabels)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	pool.RestrictToNodePools(swarm.Spec.Template.NodePoolSelectors)
+
+	if swarm.Spec.FillNodes {
+		howMany = int32(pool.SchedulableNodes())
+	}
+	// a suspended swarm logically scales to zero, all at once
+	desired, minAvailable := howMany, swarm.Spec.MinAvailable
+	if swarm.Spec.Suspend {
+		desired, minAvailable = 0, 0
+		setSwarmCondition(&swarm.Status, experimentsv1.SwarmSuspended, core.ConditionTrue, "SwarmSuspended", "", metav1.NewTime(r.Clock.Now()))
+	} else {
+		setSwarmCondition(&swarm.Status, experimentsv1.SwarmSuspended, core.ConditionFalse, "SwarmResumed", "", metav1.NewTime(r.Clock.Now()))
+	}
+
+	scaled := false
+	swarm.Status.CreatedThisPass = 0
+	missing := desired - int32(len(drones.Items))
+	if ready := pool.ReadyNodes(); ready < swarm.Spec.MinReadyNodes {
+		// the node watch brings the swarm back once nodes recover
+		if missing > 0 {
+			log.Info("too few drone nodes ready, not creating drones", "ready", ready)
+			missing = 0
 		}
-		if err := r.Client.Create(ctx, &drone); err != nil {
-			log.Error(err, "failed to create drone")
-			return ctrl.Result{}, err
+		setSwarmCondition(&swarm.Status, experimentsv1.SwarmNodesNotReady, core.ConditionTrue, "TooFewReadyNodes",
+			fmt.Sprintf("%d of %d required drone nodes are ready", ready, swarm.Spec.MinReadyNodes), metav1.NewTime(r.Clock.Now()))
+	} else if swarm.Spec.MinReadyNodes > 0 {
+		setSwarmCondition(&swarm.Status, experimentsv1.SwarmNodesNotReady, core.ConditionFalse, "EnoughReadyNodes", "", metav1.NewTime(r.Clock.Now()))
+	}
+	if swarm.Spec.Cordoned {
+		if missing > 0 {
+			log.Info("swarm is cordoned, not creating drones", "missing", missing)
+			missing = 0
 		}
+		setSwarmCondition(&swarm.Status, experimentsv1.SwarmCordoned, core.ConditionTrue, "SwarmCordoned", "", metav1.NewTime(r.Clock.Now()))
+	} else {
+		setSwarmCondition(&swarm.Status, experimentsv1.SwarmCordoned, core.ConditionFalse, "SwarmUncordoned", "", metav1.NewTime(r.Clock.Now()))
+	}
+	if missing > 0 {
+		log.Info("Not enough, must create drones", "missing", missing)
 
+		if r.MaxInFlight > 0 {
+			var inFlight int32
+			for _, d := range drones.Items {
+				if startingUp(&d) {
+					inFlight++
+				}
+			}
+			if budget := r.MaxInFlight - inFlight; budget < missing {
+				log.Info("too many drones in flight, ramping up gradually", "inFlight", inFlight)
+				missing = budget
+				result.RequeueAfter = inFlightRequeueDelay
+			}
+		}
+		if swarm.Spec.OnePerNode || swarm.Spec.FillNodes {
+			if free := int32(len(pool.FreeNodes(1))); free < missing {
+				log.Info("not enough free drone nodes", "free", free)
+				missing = free
+			}
+		}
+
+		var names []string
+		if swarm.Spec.Ordinal {
+			// unmanaged drones still hold on to their names
+			names = missingOrdinalNames(swarm.Name, append(unmanaged, drones.Items...), missing)
+		} else {
+			for i := int32(0); i < missing; i++ {
+				names = append(names, strings.ReplaceAll(namesgenerator.GetRandomName(0), "_", "-"))
+			}
+		}
+
+		for _, name := range names {
+			if shuttingDown(ctx) {
+				log.Info("shutting down, not creating any more drones")
+				return ctrl.Result{}, nil
+			}
+			if r.CreateLimiter != nil && !r.CreateLimiter.Allow() {
+				// the jitter keeps held back swarms from coming back in lockstep
+				log.Info("drone creation rate limited, creating the rest later", "created", swarm.Status.CreatedThisPass)
+				result.RequeueAfter = wait.Jitter(createRateRequeueDelay, 1)
+				break
+			}
+			drone, err := r.newDrone(&swarm, namespace, name)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if err := r.Client.Create(ctx, drone); err != nil {
+				if isQuotaExceeded(err) {
+					log.Info("resource quota keeps us from creating drones")
+					r.Recorder.Event(&swarm, core.EventTypeWarning, string(experimentsv1.SwarmQuotaExceeded), err.Error())
+					setSwarmCondition(&swarm.Status, experimentsv1.SwarmQuotaExceeded, core.ConditionTrue, "FailedCreate", err.Error(), metav1.NewTime(r.Clock.Now()))
+				} else {
+					log.Error(err, "failed to create drone")
+				}
+				// keep what was created so far on record, the error makes the
+				// swarm come back with backoff for the rest
+				log.Info("created part of the missing drones", "created", swarm.Status.CreatedThisPass, "missing", len(names))
+				if swarm.Status.CreatedThisPass > 0 {
+					recordScale(&swarm.Status, experimentsv1.ScaleUp, metav1.NewTime(r.Clock.Now()))
+				}
+				r.recordProgress(ctx, &swarm, drones.Items)
+				return ctrl.Result{}, err
+			}
+			swarm.Status.CreatedThisPass++
+		}
+		setSwarmCondition(&swarm.Status, experimentsv1.SwarmQuotaExceeded, core.ConditionFalse, "DronesCreated", "", metav1.NewTime(r.Clock.Now()))
+		if swarm.Status.CreatedThisPass > 0 {
+			scaled = true
+			recordScale(&swarm.Status, experimentsv1.ScaleUp, metav1.NewTime(r.Clock.Now()))
+		}
 	}
-	if int32(len(drones.Items)) > *swarm.Spec.HowMany {
-		log.Info("Too many, must kill")
-		r.Delete(ctx, &experimentsv1.Drone{
-			ObjectMeta: ctrl.ObjectMeta{
-				Name:      drones.Items[0].Name,
-				Namespace: req.Namespace,
-			},
-		})
+	if surplus := int32(len(drones.Items)) - desired; surplus > 0 {
+		log.Info("Too many, must kill", "surplus", surplus)
+		sortForScaleDown(drones.Items, swarm.Spec.ScaleDownOrder)
+		victims, paced := scaleDownVictims(drones.Items, surplus, minAvailable)
+		if swarm.Spec.Ordinal {
+			victims, paced = orderedScaleDownVictims(swarm.Name, drones.Items, surplus, minAvailable)
+		}
+		if paced {
+			log.Info("keeping drones flying for MinAvailable, holding back the scale-down")
+			result.RequeueAfter = scaleDownRequeueDelay
+		}
+		for i := range victims {
+			if err := r.Delete(ctx, &victims[i]); client.IgnoreNotFound(err) != nil {
+				log.Error(err, "failed to delete drone")
+				return ctrl.Result{}, err
+			}
+		}
+		if len(victims) > 0 {
+			scaled = true
+			recordScale(&swarm.Status, experimentsv1.ScaleDown, metav1.NewTime(r.Clock.Now()))
+		}
 	}
 
-	log.Info("updating swarm status")
-	if err := r.List(ctx, &drones); err != nil {
+	if err := r.reconcilePDB(ctx, &swarm, namespace, howMany); err != nil {
+		log.Error(err, "failed to reconcile pod disruption budget")
 		return ctrl.Result{}, err
 	}
-	swarm.Status.FlyingDrones = int32(len(drones.Items))
-	err := r.Update(ctx, &swarm)
-	if err != nil {
+
+	if scaled {
+		// pick up the drones just created or deleted
+		if drones.Items, err = DronesForSwarm(ctx, r.Client, &swarm); err != nil {
+			return ctrl.Result{}, err
+		}
+		drones.Items, _ = splitUnmanaged(drones.Items)
+		if pool, err = listDronePool(ctx, r.Client, namespace, swarm.Spec.NodeSelector, swarm.Spec.Template.RequiredNodeLabels); err != nil {
+			return ctrl.Result{}, err
+		}
+		pool.RestrictToNodePools(swarm.Spec.Template.NodePoolSelectors)
+	}
+	swarm.Status.FlyingDrones = 0
+	swarm.Status.UnschedulableDrones = 0
+	for _, d := range drones.Items {
+		if d.Status.Flying {
+			swarm.Status.FlyingDrones++
+		}
+		// drones only have a pending time while no node is free for them
+		if d.Status.PendingSince != nil {
+			swarm.Status.UnschedulableDrones++
+		}
+	}
+	swarm.Status.AvailableNodes = int32(len(pool.FreeNodes(1)))
+	swarm.Status.OccupiedNodes = int32(pool.OccupiedNodes())
+	if err := r.checkPlacement(ctx, &swarm, drones.Items, pool); err != nil {
+		log.Error(err, "failed to check drone placement")
+		return ctrl.Result{}, err
+	}
+	if equality.Semantic.DeepEqual(original, &swarm.Status) {
+		return result, nil
+	}
+	log.Info("updating swarm status")
+	if err := r.Update(ctx, &swarm); err != nil {
 		log.Error(err, "failed to update swarm status")
 		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{}, nil
+	return result, nil
+}
+
+// deleteAll deletes the drones and PodDisruptionBudget of the swarm.
+func (r *SwarmReconciler) deleteAll(ctx context.Context, swarm *experimentsv1.Swarm) error {
+	drones, err := DronesForSwarm(ctx, r.Client, swarm)
+	if err != nil {
+		return err
+	}
+	for i := range drones {
+		if err := r.Delete(ctx, &drones[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return r.deletePDB(ctx, swarm, swarmNamespace(swarm))
+}
+
+// startingUp reports whether the drone is on its way up. Drones that
+// succeeded or failed aren't, they won't fly again.
+func startingUp(drone *experimentsv1.Drone) bool {
+	phase := drone.Status.Phase
+	return !drone.Status.Flying && phase != experimentsv1.DroneSucceeded && phase != experimentsv1.DroneFailed
+}
+
+// recordScale notes in the status that the swarm just scaled.
+func recordScale(status *experimentsv1.SwarmStatus, action experimentsv1.ScaleAction, now metav1.Time) {
+	status.LastScaleTime = now
+	status.LastScaleAction = action
+}
+
+// recordProgress writes the status of a swarm whose reconcile is cut short,
+// counting the flying ones among the given drones.
+func (r *SwarmReconciler) recordProgress(ctx context.Context, swarm *experimentsv1.Swarm, drones []experimentsv1.Drone) {
+	swarm.Status.FlyingDrones = 0
+	for _, d := range drones {
+		if d.Status.Flying {
+			swarm.Status.FlyingDrones++
+		}
+	}
+	if err := r.Update(ctx, swarm); err != nil {
+		r.Log.Error(err, "failed to update swarm status", "Swarm", swarm.Name)
+	}
+}
+
+// InjectStopChannel is called by the manager with the channel closed on
+// shutdown.
+func (r *SwarmReconciler) InjectStopChannel(stop <-chan struct{}) error {
+	r.stop = stop
+	return nil
 }
 
 // SetupWithManager stuff
 func (r *SwarmReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Clock == nil {
+		r.Clock = clock.RealClock{}
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&experimentsv1.Swarm{}).
+		Owns(&experimentsv1.Drone{}).
+		Owns(&policy.PodDisruptionBudget{}).
+		Watches(&source.Kind{Type: &experimentsv1.Drone{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(crossNamespaceSwarm),
+		}).
+		Watches(&source.Kind{Type: &core.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.swarmsCountingFrom),
+		}).
+		Watches(&source.Kind{Type: &core.Node{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.nodeDependentSwarms),
+		}).
+		WithEventFilter(ignoreStatusUpdates(&experimentsv1.Swarm{})).
 		Complete(r)
 }
+
+// newDrone builds a new drone for the swarm from its template.
+func (r *SwarmReconciler) newDrone(swarm *experimentsv1.Swarm, namespace, name string) (*experimentsv1.Drone, error) {
+	drone := &experimentsv1.Drone{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    swarmLabels(swarm, namespace),
+		},
+		Spec: *swarm.Spec.Template.DeepCopy(),
+	}
+	if restartedAt, ok := swarm.Annotations[experimentsv1.RestartedAtAnnotation]; ok {
+		drone.Annotations = map[string]string{experimentsv1.RestartedAtAnnotation: restartedAt}
+	}
+	if len(swarm.Spec.NodeSelector) > 0 {
+		drone.Spec.NodeSelector = swarm.Spec.NodeSelector
+	}
+	if swarm.Spec.SpreadBy != "" {
+		drone.Spec.SpreadBy = swarm.Spec.SpreadBy
+	}
+	if swarm.Spec.MaxSkew > 0 {
+		drone.Spec.MaxSkew = swarm.Spec.MaxSkew
+	}
+	for _, key := range swarm.Spec.PropagatedLabels {
+		if v, ok := swarm.Labels[key]; ok {
+			if drone.Spec.PodLabels == nil {
+				drone.Spec.PodLabels = map[string]string{}
+			}
+			drone.Spec.PodLabels[key] = v
+		}
+	}
+	if swarm.Spec.OnePerNode || swarm.Spec.FillNodes {
+		drone.Spec.MaxPerNode = 1
+		drone.Spec.Affinity = spreadOverNodes(drone.Spec.Affinity, swarm.Name)
+	}
+	// owner references can't cross namespaces, drones elsewhere only carry
+	// the swarm label
+	if namespace == swarm.Namespace {
+		if err := controllerutil.SetControllerReference(swarm, drone, r.Scheme); err != nil {
+			return nil, err
+		}
+	}
+	return drone, nil
+}
+
+// spreadOverNodes adds a pod anti-affinity to affinity keeping the drone pods
+// of the swarm on separate nodes.
+func spreadOverNodes(affinity *core.Affinity, swarmName string) *core.Affinity {
+	if affinity == nil {
+		affinity = &core.Affinity{}
+	}
+	if affinity.PodAntiAffinity == nil {
+		affinity.PodAntiAffinity = &core.PodAntiAffinity{}
+	}
+	affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = append(
+		affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution,
+		core.PodAffinityTerm{
+			LabelSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{experimentsv1.SwarmNameLabel: swarmName},
+			},
+			TopologyKey: hostnameLabel,
+		})
+	return affinity
+}
+
+// scaleDownVictims picks surplus drones to delete. Drones that aren't flying
+// go first. Flying drones are only deleted while more than minAvailable keep
+// flying; at that floor none are, the swarm waits for MinAvailable to be
+// lowered or drones to stop flying. It reports whether deletions were held
+// back by the floor.
+func scaleDownVictims(drones []experimentsv1.Drone, surplus, minAvailable int32) ([]experimentsv1.Drone, bool) {
+	var flying, victims []experimentsv1.Drone
+	for _, d := range drones {
+		if d.Status.Flying {
+			flying = append(flying, d)
+		} else if int32(len(victims)) < surplus {
+			victims = append(victims, d)
+		}
+	}
+	surplus -= int32(len(victims))
+	if surplus == 0 {
+		return victims, false
+	}
+
+	paced := false
+	if allowed := int32(len(flying)) - minAvailable; allowed < surplus {
+		paced = true
+		surplus = allowed
+	}
+	if surplus > 0 {
+		victims = append(victims, flying[:surplus]...)
+	}
+	return victims, paced
+}
+
+// sortForScaleDown sorts the drones so that those to delete first come first.
+// Without an order the drones are left as listed.
+func sortForScaleDown(drones []experimentsv1.Drone, order experimentsv1.ScaleDownOrder) {
+	switch order {
+	case experimentsv1.ScaleDownNewest:
+		sort.SliceStable(drones, func(i, j int) bool {
+			return drones[j].CreationTimestamp.Before(&drones[i].CreationTimestamp)
+		})
+	case experimentsv1.ScaleDownOldest:
+		sort.SliceStable(drones, func(i, j int) bool {
+			return drones[i].CreationTimestamp.Before(&drones[j].CreationTimestamp)
+		})
+	case experimentsv1.ScaleDownRandom:
+		// hashing the names shuffles the drones the same way every time
+		hash := func(name string) uint32 {
+			hasher := fnv.New32a()
+			hasher.Write([]byte(name))
+			return hasher.Sum32()
+		}
+		sort.SliceStable(drones, func(i, j int) bool {
+			return hash(drones[i].Name) < hash(drones[j].Name)
+		})
+	}
+}
+
+// isQuotaExceeded reports whether a create was refused by a ResourceQuota.
+func isQuotaExceeded(err error) bool {
+	return apierrors.IsForbidden(err) && strings.Contains(err.Error(), "exceeded quota")
+}
+
+// swarmNamespace returns the namespace the drones of the swarm live in.
+func swarmNamespace(swarm *experimentsv1.Swarm) string {
+	if swarm.Spec.TargetNamespace != "" {
+		return swarm.Spec.TargetNamespace
+	}
+	return swarm.Namespace
+}
+
+// DronesForSwarm returns the drones belonging to the swarm: those in its
+// target namespace carrying its label, minus any controlled by another
+// object (e.g. a different swarm of the same name).
+func DronesForSwarm(ctx context.Context, c client.Client, swarm *experimentsv1.Swarm) ([]experimentsv1.Drone, error) {
+	drones := experimentsv1.DroneList{}
+	if err := c.List(ctx, &drones, client.InNamespace(swarmNamespace(swarm)),
+		client.MatchingLabels{experimentsv1.SwarmNameLabel: swarm.Name}); err != nil {
+		return nil, err
+	}
+
+	var owned []experimentsv1.Drone
+	for _, d := range drones.Items {
+		if ownedBySwarm(&d, swarm) {
+			owned = append(owned, d)
+		}
+	}
+	return owned, nil
+}
+
+// splitUnmanaged splits the drones into those the swarm manages and those
+// marked with the unmanaged annotation.
+func splitUnmanaged(drones []experimentsv1.Drone) (managed, unmanaged []experimentsv1.Drone) {
+	for _, d := range drones {
+		if d.Annotations[experimentsv1.UnmanagedAnnotation] == "true" {
+			unmanaged = append(unmanaged, d)
+		} else {
+			managed = append(managed, d)
+		}
+	}
+	return managed, unmanaged
+}
+
+// swarmLabels returns the labels tying objects the swarm creates in namespace
+// to it.
+func swarmLabels(swarm *experimentsv1.Swarm, namespace string) map[string]string {
+	labels := map[string]string{experimentsv1.SwarmNameLabel: swarm.Name}
+	if namespace != swarm.Namespace {
+		labels[experimentsv1.SwarmNamespaceLabel] = swarm.Namespace
+	}
+	return labels
+}
+
+// ownedBySwarm reports whether the object carrying the swarm's name label
+// belongs to it: it is controlled by the swarm, or by nothing and not labelled
+// with another swarm namespace.
+func ownedBySwarm(o metav1.Object, swarm *experimentsv1.Swarm) bool {
+	if owner := metav1.GetControllerOf(o); owner != nil {
+		return owner.UID == swarm.UID
+	}
+	ns, ok := o.GetLabels()[experimentsv1.SwarmNamespaceLabel]
+	return !ok || ns == swarm.Namespace
+}
+
+// crossNamespaceSwarm maps a drone in another namespace than its swarm to
+// that swarm, which owner references can't do.
+func crossNamespaceSwarm(o handler.MapObject) []reconcile.Request {
+	labels := o.Meta.GetLabels()
+	name, ok := labels[experimentsv1.SwarmNameLabel]
+	namespace, crossNamespace := labels[experimentsv1.SwarmNamespaceLabel]
+	if !ok || !crossNamespace {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}}
+}
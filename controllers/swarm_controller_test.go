@@ -0,0 +1,1102 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+func TestReconcileSwarmTargetNamespace(t *testing.T) {
+	swarm := newSwarm("fleet", 2)
+	swarm.Spec.TargetNamespace = "drones"
+	target := &core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "drones"}}
+	r, _ := newSwarmReconciler(swarm, target)
+
+	reconcileSwarm(t, r, "fleet")
+	drones := listDrones(t, r, "drones")
+	if len(drones) != 2 {
+		t.Fatalf("got %d drones in the target namespace, want 2", len(drones))
+	}
+	for _, d := range drones {
+		if d.Labels[experimentsv1.SwarmNameLabel] != "fleet" || d.Labels[experimentsv1.SwarmNamespaceLabel] != testNamespace {
+			t.Errorf("drone %s labels = %v, want them to point at the swarm", d.Name, d.Labels)
+		}
+	}
+	if drones := listDrones(t, r, testNamespace); len(drones) != 0 {
+		t.Errorf("got %d drones in the swarm's namespace, want none", len(drones))
+	}
+
+	// counting happens in the target namespace too
+	reconcileSwarm(t, r, "fleet")
+	if drones := listDrones(t, r, "drones"); len(drones) != 2 {
+		t.Errorf("got %d drones after another reconcile, want 2", len(drones))
+	}
+}
+
+func TestReconcileSwarmMissingTargetNamespace(t *testing.T) {
+	swarm := newSwarm("fleet", 2)
+	swarm.Spec.TargetNamespace = "missing"
+	r, _ := newSwarmReconciler(swarm)
+
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "fleet"}})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("reconcile error = %v, want the namespace not to be found", err)
+	}
+	if drones := listDrones(t, r, "missing"); len(drones) != 0 {
+		t.Errorf("got %d drones in a missing namespace, want none", len(drones))
+	}
+}
+
+func TestReconcileSwarmNodeSelector(t *testing.T) {
+	swarm := newSwarm("blue", 2)
+	swarm.Spec.NodeSelector = map[string]string{"pool": "blue"}
+	blue1, blue2 := droneNode("blue-1"), droneNode("blue-2")
+	for _, n := range []*core.Node{blue1, blue2} {
+		n.Labels = map[string]string{"pool": "blue", hostnameLabel: n.Name}
+	}
+	r, clock := newSwarmReconciler(swarm, blue1, blue2, droneNode("drone-1"), droneNode("drone-2"))
+
+	reconcileSwarm(t, r, "blue")
+	dr := droneReconcilerOn(r.Client, clock)
+	drones := listDrones(t, r, testNamespace)
+	if len(drones) != 2 {
+		t.Fatalf("got %d drones, want 2", len(drones))
+	}
+	for _, d := range drones {
+		if d.Spec.NodeSelector["pool"] != "blue" {
+			t.Errorf("drone %s node selector = %v, want the swarm's", d.Name, d.Spec.NodeSelector)
+		}
+		reconcileDrone(t, dr, d.Name)
+		if node := podNode(getPod(t, r, d.Name)); node != "blue-1" && node != "blue-2" {
+			t.Errorf("drone %s landed on %q, want a blue node", d.Name, node)
+		}
+	}
+}
+
+func TestReconcileSwarmPacesCreations(t *testing.T) {
+	swarm := newSwarm("horde", 100)
+	// ordinal names can't collide, unlike a hundred random ones
+	swarm.Spec.Ordinal = true
+	r, _ := newSwarmReconciler(swarm)
+	r.MaxInFlight = 10
+
+	result := reconcileSwarm(t, r, "horde")
+	if got := len(listDrones(t, r, testNamespace)); got != 10 {
+		t.Fatalf("got %d drones after the first reconcile, want 10", got)
+	}
+	if result.RequeueAfter != inFlightRequeueDelay {
+		t.Errorf("requeue after = %v, want %v to ramp up further", result.RequeueAfter, inFlightRequeueDelay)
+	}
+
+	reconcileSwarm(t, r, "horde")
+	if got := len(listDrones(t, r, testNamespace)); got != 10 {
+		t.Errorf("got %d drones while all are in flight, want 10", got)
+	}
+
+	setDroneStatuses(t, r, func(d *experimentsv1.Drone) {
+		d.Status.Phase, d.Status.Flying = experimentsv1.DroneRunning, true
+	})
+	reconcileSwarm(t, r, "horde")
+	if got := len(listDrones(t, r, testNamespace)); got != 20 {
+		t.Errorf("got %d drones once the first ones fly, want 20", got)
+	}
+
+	// finished drones aren't on their way up either
+	setDroneStatuses(t, r, func(d *experimentsv1.Drone) {
+		if !d.Status.Flying {
+			d.Status.Phase = experimentsv1.DroneSucceeded
+		}
+	})
+	reconcileSwarm(t, r, "horde")
+	if got := len(listDrones(t, r, testNamespace)); got != 30 {
+		t.Errorf("got %d drones once the others finished, want 30", got)
+	}
+
+	for i := 0; i < 20; i++ {
+		setDroneStatuses(t, r, func(d *experimentsv1.Drone) {
+			d.Status.Phase, d.Status.Flying = experimentsv1.DroneRunning, true
+		})
+		reconcileSwarm(t, r, "horde")
+	}
+	if got := len(listDrones(t, r, testNamespace)); got != 100 {
+		t.Errorf("got %d drones in the end, want 100", got)
+	}
+}
+
+// setDroneStatuses updates the status of every drone of the test namespace
+// with set, as their controller would.
+func setDroneStatuses(t *testing.T, c client.Client, set func(*experimentsv1.Drone)) {
+	t.Helper()
+	for _, d := range listDrones(t, c, testNamespace) {
+		set(&d)
+		if err := c.Update(context.Background(), &d); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReconcileSwarmFailurePolicy(t *testing.T) {
+	tests := []struct {
+		policy     experimentsv1.FailurePolicy
+		reason     string
+		wantFailed int
+	}{
+		{policy: experimentsv1.FailurePolicyReplace, wantFailed: 0},
+		// recreating it would only start the same countdown over
+		{policy: experimentsv1.FailurePolicyReplace, reason: deadlineExceededReason, wantFailed: 1},
+		{policy: experimentsv1.FailurePolicyIgnore, wantFailed: 1},
+		{policy: "", wantFailed: 1},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy)+" "+tt.reason, func(t *testing.T) {
+			swarm := newSwarm("fragile", 3)
+			swarm.Spec.FailurePolicy = tt.policy
+			r, _ := newSwarmReconciler(swarm)
+			reconcileSwarm(t, r, "fragile")
+
+			failed := listDrones(t, r, testNamespace)[0].Name
+			setDroneStatuses(t, r, func(d *experimentsv1.Drone) {
+				if d.Name == failed {
+					d.Status.Phase, d.Status.Reason = experimentsv1.DroneFailed, tt.reason
+				}
+			})
+			reconcileSwarm(t, r, "fragile")
+			// the replacement comes in the reconcile after the deletion at
+			// the latest
+			reconcileSwarm(t, r, "fragile")
+
+			drones := listDrones(t, r, testNamespace)
+			if len(drones) != 3 {
+				t.Errorf("got %d drones, want 3", len(drones))
+			}
+			var gotFailed int
+			for _, d := range drones {
+				if d.Status.Phase == experimentsv1.DroneFailed {
+					gotFailed++
+				}
+			}
+			if gotFailed != tt.wantFailed {
+				t.Errorf("got %d failed drones, want %d", gotFailed, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestDronesForSwarm(t *testing.T) {
+	swarm := newSwarm("fleet", 3)
+	previous := newSwarm("fleet", 3)
+	previous.UID = "previous"
+	drone := func(name, namespace string, labels map[string]string, owner *experimentsv1.Swarm) *experimentsv1.Drone {
+		d := newDrone(name)
+		d.Namespace, d.Labels = namespace, labels
+		if owner != nil {
+			d.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(owner, experimentsv1.GroupVersion.WithKind("Swarm"))}
+		}
+		return d
+	}
+	fleet := map[string]string{experimentsv1.SwarmNameLabel: "fleet"}
+	r, _ := newSwarmReconciler(
+		drone("owned", testNamespace, fleet, swarm),
+		drone("selected", testNamespace, fleet, nil),
+		drone("unlabelled", testNamespace, nil, swarm),
+		drone("other-swarm", testNamespace, map[string]string{experimentsv1.SwarmNameLabel: "other"}, nil),
+		drone("previous-swarm", testNamespace, fleet, previous),
+		drone("namesake", testNamespace, map[string]string{
+			experimentsv1.SwarmNameLabel: "fleet", experimentsv1.SwarmNamespaceLabel: "elsewhere",
+		}, nil),
+		drone("own-namespace", testNamespace, map[string]string{
+			experimentsv1.SwarmNameLabel: "fleet", experimentsv1.SwarmNamespaceLabel: testNamespace,
+		}, nil),
+		drone("elsewhere", "elsewhere", fleet, nil),
+	)
+
+	drones, err := DronesForSwarm(context.Background(), r, swarm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range drones {
+		got = append(got, d.Name)
+	}
+	sort.Strings(got)
+	if want := []string{"own-namespace", "owned", "selected"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("drones = %v, want %v", got, want)
+	}
+}
+
+func TestDronesForSwarmTargetNamespace(t *testing.T) {
+	swarm := newSwarm("fleet", 3)
+	swarm.Spec.TargetNamespace = "drones"
+	drone := newDrone("far")
+	drone.Namespace = "drones"
+	drone.Labels = map[string]string{experimentsv1.SwarmNameLabel: "fleet", experimentsv1.SwarmNamespaceLabel: testNamespace}
+	near := newDrone("near")
+	near.Labels = map[string]string{experimentsv1.SwarmNameLabel: "fleet"}
+	r, _ := newSwarmReconciler(drone, near)
+
+	drones, err := DronesForSwarm(context.Background(), r, swarm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drones) != 1 || drones[0].Name != "far" {
+		t.Errorf("drones = %v, want only the one in the target namespace", drones)
+	}
+}
+
+func TestReconcileSwarmOnePerNode(t *testing.T) {
+	tests := []struct {
+		name       string
+		onePerNode bool
+		maxPerNode int32
+		wantDrones int
+		wantMax    int
+	}{
+		{name: "one per node", onePerNode: true, wantDrones: 3, wantMax: 1},
+		{name: "packed", maxPerNode: 2, wantDrones: 5, wantMax: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			swarm := newSwarm("spread", 5)
+			swarm.Spec.OnePerNode = tt.onePerNode
+			swarm.Spec.Template.MaxPerNode = tt.maxPerNode
+			r, clock := newSwarmReconciler(swarm, droneNode("node-1"), droneNode("node-2"), droneNode("node-3"))
+			dr := droneReconcilerOn(r.Client, clock)
+
+			reconcileSwarm(t, r, "spread")
+			for _, d := range listDrones(t, r, testNamespace) {
+				reconcileDrone(t, dr, d.Name)
+			}
+			reconcileSwarm(t, r, "spread")
+
+			drones := listDrones(t, r, testNamespace)
+			if len(drones) != tt.wantDrones {
+				t.Fatalf("got %d drones, want %d", len(drones), tt.wantDrones)
+			}
+			perNode := map[string]int{}
+			for _, d := range drones {
+				reconcileDrone(t, dr, d.Name)
+				perNode[podNode(getPod(t, r, d.Name))]++
+				if antiAffinity := d.Spec.Affinity != nil && d.Spec.Affinity.PodAntiAffinity != nil; antiAffinity != tt.onePerNode {
+					t.Errorf("drone %s has anti-affinity %v, want %v", d.Name, antiAffinity, tt.onePerNode)
+				}
+			}
+			for node, n := range perNode {
+				if node == "" || n > tt.wantMax {
+					t.Errorf("node %q carries %d drones, want at most %d", node, n, tt.wantMax)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileSwarmWithoutHowMany(t *testing.T) {
+	// without the defaulting webhook a missing howmany decodes to 0
+	swarm := newSwarm("empty", 0)
+	r, _ := newSwarmReconciler(swarm, droneNode("node-1"))
+
+	reconcileSwarm(t, r, "empty")
+	if drones := listDrones(t, r, testNamespace); len(drones) != 0 {
+		t.Errorf("got %d drones, want none", len(drones))
+	}
+}
+
+// scaleDownDrones returns drones named after whether they fly: f for flying,
+// anything else for not.
+func scaleDownDrones(states string) []experimentsv1.Drone {
+	var drones []experimentsv1.Drone
+	for i, s := range states {
+		d := newDrone(string(s) + string(rune('0'+i)))
+		d.Status.Flying = s == 'f'
+		drones = append(drones, *d)
+	}
+	return drones
+}
+
+func droneNames(drones []experimentsv1.Drone) []string {
+	var names []string
+	for _, d := range drones {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+func TestScaleDownVictims(t *testing.T) {
+	tests := []struct {
+		name         string
+		states       string
+		surplus      int32
+		minAvailable int32
+		want         []string
+		wantPaced    bool
+	}{
+		{name: "not flying first", states: "ffpfp", surplus: 2, want: []string{"p2", "p4"}},
+		{name: "flying after the rest", states: "ffpfp", surplus: 3, want: []string{"p2", "p4", "f0"}},
+		{name: "no floor", states: "ffff", surplus: 4, want: []string{"f0", "f1", "f2", "f3"}},
+		{name: "down to the floor", states: "ffffffffff", surplus: 10, minAvailable: 3,
+			want: []string{"f0", "f1", "f2", "f3", "f4", "f5", "f6"}, wantPaced: true},
+		{name: "none at the floor", states: "fff", surplus: 3, minAvailable: 3, wantPaced: true},
+		{name: "none below the floor", states: "ff", surplus: 2, minAvailable: 3, wantPaced: true},
+		{name: "not flying ones instead at the floor", states: "fffp", surplus: 2, minAvailable: 3, want: []string{"p3"}, wantPaced: true},
+		{name: "floor not reached", states: "fffff", surplus: 2, minAvailable: 3, want: []string{"f0", "f1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			victims, paced := scaleDownVictims(scaleDownDrones(tt.states), tt.surplus, tt.minAvailable)
+			if got := droneNames(victims); !reflect.DeepEqual(got, tt.want) || paced != tt.wantPaced {
+				t.Errorf("scaleDownVictims() = %v, %v, want %v, %v", got, paced, tt.want, tt.wantPaced)
+			}
+		})
+	}
+}
+
+func TestReconcileSwarmScaleDownFloor(t *testing.T) {
+	swarm := newSwarm("floor", 10)
+	swarm.Spec.MinAvailable = 3
+	r, _ := newSwarmReconciler(swarm)
+	reconcileSwarm(t, r, "floor")
+	setDroneStatuses(t, r, func(d *experimentsv1.Drone) {
+		d.Status.Phase, d.Status.Flying = experimentsv1.DroneRunning, true
+	})
+
+	swarm = getSwarm(t, r, "floor")
+	swarm.Spec.HowMany = 0
+	updateObject(t, r, swarm)
+	result := reconcileSwarm(t, r, "floor")
+	if got := len(listDrones(t, r, testNamespace)); got != 3 {
+		t.Fatalf("got %d drones after the first pass, want the 3 of MinAvailable", got)
+	}
+	if result.RequeueAfter != scaleDownRequeueDelay {
+		t.Errorf("requeue after = %v, want %v", result.RequeueAfter, scaleDownRequeueDelay)
+	}
+
+	// at the floor no flying drone goes, however often the swarm comes back
+	for pass := 0; pass < 3; pass++ {
+		result := reconcileSwarm(t, r, "floor")
+		if got := len(listDrones(t, r, testNamespace)); got != 3 {
+			t.Fatalf("got %d drones in pass %d, want the 3 of MinAvailable", got, pass)
+		}
+		if result.RequeueAfter != scaleDownRequeueDelay {
+			t.Errorf("requeue after = %v, want %v", result.RequeueAfter, scaleDownRequeueDelay)
+		}
+	}
+
+	// a drone that stops flying may go, the rest wait for a lower floor
+	grounded := listDrones(t, r, testNamespace)[0]
+	grounded.Status.Flying = false
+	if err := r.Status().Update(context.Background(), &grounded); err != nil {
+		t.Fatal(err)
+	}
+	reconcileSwarm(t, r, "floor")
+	if got := len(listDrones(t, r, testNamespace)); got != 2 {
+		t.Fatalf("got %d drones, want the grounded one deleted", got)
+	}
+
+	swarm = getSwarm(t, r, "floor")
+	swarm.Spec.MinAvailable = 0
+	updateObject(t, r, swarm)
+	reconcileSwarm(t, r, "floor")
+	if got := len(listDrones(t, r, testNamespace)); got != 0 {
+		t.Errorf("got %d drones, want all deleted once MinAvailable is lowered", got)
+	}
+}
+
+// quotaClient lets a number of drones be created before a resource quota
+// forbids any more.
+type quotaClient struct {
+	client.Client
+	allowed int
+}
+
+func (c *quotaClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if drone, ok := obj.(*experimentsv1.Drone); ok {
+		if c.allowed == 0 {
+			return apierrors.NewForbidden(experimentsv1.GroupVersion.WithResource("drones").GroupResource(), drone.Name,
+				errors.New("exceeded quota: drones, requested: count/drones.experiments.mad.md=1, used: 1, limited: 1"))
+		}
+		c.allowed--
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestReconcileSwarmQuotaExceeded(t *testing.T) {
+	r, _ := newSwarmReconciler(newSwarm("capped", 3))
+	quota := &quotaClient{Client: r.Client, allowed: 1}
+	r.Client = quota
+	recorder := r.Recorder.(*record.FakeRecorder)
+
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "capped"}})
+	if !apierrors.IsForbidden(err) {
+		t.Fatalf("reconcile error = %v, want it forbidden so the swarm backs off", err)
+	}
+	expectEvent(t, recorder, "Warning QuotaExceeded ")
+	swarm := getSwarm(t, r, "capped")
+	if !swarmConditionTrue(&swarm.Status, experimentsv1.SwarmQuotaExceeded) {
+		t.Errorf("conditions = %v, want QuotaExceeded", swarm.Status.Conditions)
+	}
+	if drones := listDrones(t, r, testNamespace); len(drones) != 1 {
+		t.Errorf("got %d drones, want the one the quota allowed", len(drones))
+	}
+
+	quota.allowed = 2
+	reconcileSwarm(t, r, "capped")
+	swarm = getSwarm(t, r, "capped")
+	if swarmConditionTrue(&swarm.Status, experimentsv1.SwarmQuotaExceeded) {
+		t.Errorf("conditions = %v, want QuotaExceeded cleared", swarm.Status.Conditions)
+	}
+	if drones := listDrones(t, r, testNamespace); len(drones) != 3 {
+		t.Errorf("got %d drones once the quota allows them, want 3", len(drones))
+	}
+}
+
+func TestReconcileSwarmCountsFlyingDrones(t *testing.T) {
+	const registered = core.PodConditionType("example.com/registered")
+	swarm := newSwarm("gated", 2)
+	swarm.Spec.Ordinal = true
+	swarm.Spec.Template.ReadinessGates = []core.PodReadinessGate{{ConditionType: registered}}
+	r, clock := newSwarmReconciler(swarm, droneNode("node-1"), droneNode("node-2"))
+	dr := droneReconcilerOn(r.Client, clock)
+
+	reconcileSwarm(t, r, "gated")
+	// both pods are ready, only the first one is registered
+	for i, name := range []string{"gated-0", "gated-1"} {
+		reconcileDrone(t, dr, name)
+		pod := getPod(t, r, name)
+		pod.Status.Phase = core.PodRunning
+		pod.Status.Conditions = []core.PodCondition{{Type: core.PodReady, Status: core.ConditionTrue}}
+		if i == 0 {
+			pod.Status.Conditions = append(pod.Status.Conditions, core.PodCondition{Type: registered, Status: core.ConditionTrue})
+		}
+		updateObject(t, r, pod)
+		reconcileDrone(t, dr, name)
+	}
+	reconcileSwarm(t, r, "gated")
+	if flying := getSwarm(t, r, "gated").Status.FlyingDrones; flying != 1 {
+		t.Errorf("flying drones = %d, want only the registered one", flying)
+	}
+}
+
+func TestReconcileSwarmPartialScaleUp(t *testing.T) {
+	swarm := newSwarm("big", 50)
+	swarm.Spec.Ordinal = true
+	r, _ := newSwarmReconciler(swarm)
+	failing := &failingClient{Client: r.Client, failAfter: 29}
+	r.Client = failing
+
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "big"}}); err == nil {
+		t.Fatal("reconcile succeeded with a failed create, want an error to back off with")
+	}
+	if drones := listDrones(t, r, testNamespace); len(drones) != 29 {
+		t.Errorf("got %d drones, want the 29 created before the failure", len(drones))
+	}
+	status := getSwarm(t, r, "big").Status
+	if status.CreatedThisPass != 29 || status.LastScaleAction != experimentsv1.ScaleUp {
+		t.Errorf("created this pass/last scale = %d/%q, want 29/%q", status.CreatedThisPass, status.LastScaleAction, experimentsv1.ScaleUp)
+	}
+
+	failing.failAfter = -1
+	reconcileSwarm(t, r, "big")
+	if drones := listDrones(t, r, testNamespace); len(drones) != 50 {
+		t.Errorf("got %d drones after the retry, want 50", len(drones))
+	}
+	if status := getSwarm(t, r, "big").Status; status.CreatedThisPass != 21 {
+		t.Errorf("created this pass = %d, want the 21 missing", status.CreatedThisPass)
+	}
+}
+
+// failingClient fails drone creations once failAfter of them succeeded. A
+// negative failAfter never fails.
+type failingClient struct {
+	client.Client
+	failAfter int
+}
+
+func (c *failingClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if _, ok := obj.(*experimentsv1.Drone); ok && c.failAfter >= 0 {
+		if c.failAfter == 0 {
+			return apierrors.NewServiceUnavailable("etcd is having a bad day")
+		}
+		c.failAfter--
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestReconcileSwarmCreateRateLimit(t *testing.T) {
+	first, second := newSwarm("first", 20), newSwarm("second", 20)
+	first.Spec.Ordinal, second.Spec.Ordinal = true, true
+	r, _ := newSwarmReconciler(first, second)
+	// a burst of 5, with hardly any refill during the test
+	r.CreateLimiter = rate.NewLimiter(rate.Every(time.Hour), 5)
+
+	result := reconcileSwarm(t, r, "first")
+	if drones := listDrones(t, r, testNamespace); len(drones) != 5 {
+		t.Errorf("got %d drones, want the burst of 5", len(drones))
+	}
+	if result.RequeueAfter < createRateRequeueDelay || result.RequeueAfter >= 2*createRateRequeueDelay {
+		t.Errorf("requeue after = %v, want %v plus jitter", result.RequeueAfter, createRateRequeueDelay)
+	}
+
+	// the limit holds across swarms
+	reconcileSwarm(t, r, "second")
+	if drones := listDrones(t, r, testNamespace); len(drones) != 5 {
+		t.Errorf("got %d drones after another swarm, want still 5", len(drones))
+	}
+	if created := getSwarm(t, r, "second").Status.CreatedThisPass; created != 0 {
+		t.Errorf("created this pass = %d, want 0", created)
+	}
+}
+
+func TestReconcileSwarmSpreadByZone(t *testing.T) {
+	var nodes []runtime.Object
+	for _, name := range []string{"a-1", "a-2", "a-3", "b-1", "b-2"} {
+		node := droneNode(name)
+		node.Labels[zoneLabel] = name[:1]
+		nodes = append(nodes, node)
+	}
+	for _, spreadBy := range []string{"", zoneLabel} {
+		t.Run("spread by "+spreadBy, func(t *testing.T) {
+			swarm := newSwarm("zonal", 4)
+			swarm.Spec.Ordinal = true
+			swarm.Spec.SpreadBy = spreadBy
+			objs := []runtime.Object{swarm}
+			for _, n := range nodes {
+				objs = append(objs, n.DeepCopyObject())
+			}
+			r, clock := newSwarmReconciler(objs...)
+			dr := droneReconcilerOn(r.Client, clock)
+
+			reconcileSwarm(t, r, "zonal")
+			perZone := map[string]int{}
+			for _, d := range listDrones(t, r, testNamespace) {
+				reconcileDrone(t, dr, d.Name)
+				perZone[podNode(getPod(t, r, d.Name))[:1]]++
+			}
+			// without spreading the equally sized nodes fill in order
+			want := map[string]int{"a": 3, "b": 1}
+			if spreadBy != "" {
+				want = map[string]int{"a": 2, "b": 2}
+			}
+			if !reflect.DeepEqual(perZone, want) {
+				t.Errorf("drones per zone = %v, want %v", perZone, want)
+			}
+		})
+	}
+}
+
+func TestReconcileSwarmPropagatesLabels(t *testing.T) {
+	swarm := newSwarm("netpol", 1)
+	swarm.Labels = map[string]string{"team": "blue", "tier": "edge"}
+	swarm.Spec.PropagatedLabels = []string{"team", "missing"}
+	r, clock := newSwarmReconciler(swarm, droneNode("node-1"))
+	dr := droneReconcilerOn(r.Client, clock)
+
+	reconcileSwarm(t, r, "netpol")
+	drones := listDrones(t, r, testNamespace)
+	if len(drones) != 1 {
+		t.Fatalf("got %d drones, want 1", len(drones))
+	}
+	if want := map[string]string{"team": "blue"}; !reflect.DeepEqual(drones[0].Spec.PodLabels, want) {
+		t.Errorf("drone pod labels = %v, want %v", drones[0].Spec.PodLabels, want)
+	}
+	reconcileDrone(t, dr, drones[0].Name)
+	labels := getPod(t, r, drones[0].Name).Labels
+	if labels["team"] != "blue" {
+		t.Errorf("pod labels = %v, want the propagated team label", labels)
+	}
+	if _, ok := labels["tier"]; ok {
+		t.Errorf("pod labels = %v, want only the propagated swarm labels", labels)
+	}
+}
+
+func TestReconcileSwarmUnschedulableDrones(t *testing.T) {
+	swarm := newSwarm("crowded", 3)
+	swarm.Spec.Ordinal = true
+	r, clock := newSwarmReconciler(swarm, droneNode("node-1"), droneNode("node-2"))
+	dr := droneReconcilerOn(r.Client, clock)
+	reconcileAll := func() {
+		reconcileSwarm(t, r, "crowded")
+		for _, d := range listDrones(t, r, testNamespace) {
+			reconcileDrone(t, dr, d.Name)
+		}
+		reconcileSwarm(t, r, "crowded")
+	}
+
+	reconcileAll()
+	if n := getSwarm(t, r, "crowded").Status.UnschedulableDrones; n != 1 {
+		t.Errorf("unschedulable drones = %d, want 1 of 3 on 2 nodes", n)
+	}
+
+	if err := r.Create(context.Background(), droneNode("node-3")); err != nil {
+		t.Fatal(err)
+	}
+	reconcileAll()
+	if n := getSwarm(t, r, "crowded").Status.UnschedulableDrones; n != 0 {
+		t.Errorf("unschedulable drones = %d, want none once a node is added", n)
+	}
+}
+
+func TestReconcileSwarmSuspend(t *testing.T) {
+	swarm := newSwarm("napping", 3)
+	swarm.Spec.Ordinal = true
+	swarm.Spec.MinAvailable = 2
+	r, _ := newSwarmReconciler(swarm, droneNode("node-1"))
+
+	reconcileSwarm(t, r, "napping")
+	if n := len(listDrones(t, r, testNamespace)); n != 3 {
+		t.Fatalf("got %d drones, want 3", n)
+	}
+
+	swarm = getSwarm(t, r, "napping")
+	swarm.Spec.Suspend = true
+	updateObject(t, r, swarm)
+	reconcileSwarm(t, r, "napping")
+	if drones := listDrones(t, r, testNamespace); len(drones) != 0 {
+		t.Errorf("drones = %v, want all deleted while suspended", droneNames(drones))
+	}
+	swarm = getSwarm(t, r, "napping")
+	if !swarmConditionTrue(&swarm.Status, experimentsv1.SwarmSuspended) {
+		t.Errorf("conditions = %v, want Suspended", swarm.Status.Conditions)
+	}
+
+	swarm.Spec.Suspend = false
+	updateObject(t, r, swarm)
+	reconcileSwarm(t, r, "napping")
+	if n := len(listDrones(t, r, testNamespace)); n != 3 {
+		t.Errorf("got %d drones, want 3 once resumed", n)
+	}
+	if swarm := getSwarm(t, r, "napping"); swarmConditionTrue(&swarm.Status, experimentsv1.SwarmSuspended) {
+		t.Errorf("conditions = %v, want Suspended cleared", swarm.Status.Conditions)
+	}
+}
+
+func TestReconcileSwarmAcrossNamespaces(t *testing.T) {
+	swarm := newSwarm("fleet", 2)
+	swarm.Spec.Ordinal = true
+	swarm.Spec.TargetNamespace = "drones"
+	r, _ := newSwarmReconciler(swarm, &core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "drones"}})
+
+	reconcileSwarm(t, r, "fleet")
+	drones := listDrones(t, r, "drones")
+	if len(drones) != 2 {
+		t.Fatalf("got %d drones in the target namespace, want 2", len(drones))
+	}
+	for _, d := range drones {
+		if len(d.OwnerReferences) != 0 {
+			t.Errorf("drone %s owner references = %v, want none across namespaces", d.Name, d.OwnerReferences)
+		}
+		want := []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "fleet"}}}
+		if requests := crossNamespaceSwarm(handler.MapObject{Meta: &d, Object: &d}); !reflect.DeepEqual(requests, want) {
+			t.Errorf("drone %s maps to %v, want %v", d.Name, requests, want)
+		}
+	}
+	swarm = getSwarm(t, r, "fleet")
+	finalizer := finalizerName("", droneCleanupFinalizer)
+	if !hasFinalizer(swarm, finalizer) {
+		t.Fatalf("finalizers = %v, want the drone cleanup one", swarm.Finalizers)
+	}
+
+	now := metav1.NewTime(testTime)
+	swarm.DeletionTimestamp = &now
+	updateObject(t, r, swarm)
+	reconcileSwarm(t, r, "fleet")
+	if drones := listDrones(t, r, "drones"); len(drones) != 0 {
+		t.Errorf("drones = %v, want them cleaned up with the swarm", droneNames(drones))
+	}
+	if swarm := getSwarm(t, r, "fleet"); hasFinalizer(swarm, finalizer) {
+		t.Errorf("finalizers = %v, want the drone cleanup one removed", swarm.Finalizers)
+	}
+}
+
+func TestReconcileSwarmSameNamespaceNeedsNoFinalizer(t *testing.T) {
+	r, _ := newSwarmReconciler(newSwarm("fleet", 1))
+	reconcileSwarm(t, r, "fleet")
+	if swarm := getSwarm(t, r, "fleet"); len(swarm.Finalizers) != 0 {
+		t.Errorf("finalizers = %v, want none, the garbage collector deletes owned drones", swarm.Finalizers)
+	}
+	drones := listDrones(t, r, testNamespace)
+	if len(drones) != 1 || metav1.GetControllerOf(&drones[0]) == nil {
+		t.Errorf("drones = %v, want one owned by the swarm", drones)
+	}
+	if requests := crossNamespaceSwarm(handler.MapObject{Meta: &drones[0], Object: &drones[0]}); requests != nil {
+		t.Errorf("drone maps to %v, want owned drones left to the owner watch", requests)
+	}
+}
+
+func TestSortForScaleDown(t *testing.T) {
+	created := func(name string, minutes int) experimentsv1.Drone {
+		drone := newDrone(name)
+		drone.CreationTimestamp = metav1.NewTime(testTime.Add(time.Duration(minutes) * time.Minute))
+		return *drone
+	}
+	listed := func() []experimentsv1.Drone {
+		return []experimentsv1.Drone{created("middle", 1), created("oldest", 0), created("newest", 2), created("twin", 1)}
+	}
+	tests := []struct {
+		order experimentsv1.ScaleDownOrder
+		want  []string
+	}{
+		{order: "", want: []string{"middle", "oldest", "newest", "twin"}},
+		{order: experimentsv1.ScaleDownNewest, want: []string{"newest", "middle", "twin", "oldest"}},
+		{order: experimentsv1.ScaleDownOldest, want: []string{"oldest", "middle", "twin", "newest"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.order), func(t *testing.T) {
+			drones := listed()
+			sortForScaleDown(drones, tt.order)
+			if got := droneNames(drones); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run(string(experimentsv1.ScaleDownRandom), func(t *testing.T) {
+		drones := listed()
+		sortForScaleDown(drones, experimentsv1.ScaleDownRandom)
+		// however they're listed, the shuffle comes out the same
+		reversed := listed()
+		for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+			reversed[i], reversed[j] = reversed[j], reversed[i]
+		}
+		sortForScaleDown(reversed, experimentsv1.ScaleDownRandom)
+		if got, want := droneNames(reversed), droneNames(drones); !reflect.DeepEqual(got, want) {
+			t.Errorf("order = %v, want the same shuffle as %v", got, want)
+		}
+	})
+}
+
+func TestReconcileSwarmScaleDownOrder(t *testing.T) {
+	for _, order := range []experimentsv1.ScaleDownOrder{experimentsv1.ScaleDownOldest, experimentsv1.ScaleDownNewest} {
+		t.Run(string(order), func(t *testing.T) {
+			swarm := newSwarm("fleet", 0)
+			swarm.Spec.ScaleDownOrder = order
+			r, clock := newSwarmReconciler(swarm)
+			scaleTo := func(howMany int32) {
+				swarm := getSwarm(t, r, "fleet")
+				swarm.Spec.HowMany = howMany
+				updateObject(t, r, swarm)
+				reconcileSwarm(t, r, "fleet")
+			}
+			// one drone a minute, noting the names as they come
+			var created []string
+			seen := map[string]bool{}
+			for howMany := int32(1); howMany <= 3; howMany++ {
+				scaleTo(howMany)
+				for _, name := range sortedDroneNames(t, r) {
+					if !seen[name] {
+						seen[name] = true
+						created = append(created, name)
+					}
+				}
+				clock.Step(time.Minute)
+			}
+
+			scaleTo(2)
+			want := append([]string{}, created[1:]...)
+			if order == experimentsv1.ScaleDownNewest {
+				want = append([]string{}, created[:2]...)
+			}
+			sort.Strings(want)
+			if got := sortedDroneNames(t, r); !reflect.DeepEqual(got, want) {
+				t.Errorf("drones = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestReconcileSwarmFillNodes(t *testing.T) {
+	swarm := newSwarm("everywhere", 0)
+	swarm.Spec.FillNodes = true
+	r, clock := newSwarmReconciler(swarm, newSwarm("fixed", 0), droneNode("node-1"), droneNode("node-2"))
+	dr := droneReconcilerOn(r.Client, clock)
+	reconcileAll := func() []experimentsv1.Drone {
+		reconcileSwarm(t, r, "everywhere")
+		for _, d := range listDrones(t, r, testNamespace) {
+			reconcileDrone(t, dr, d.Name)
+		}
+		return listDrones(t, r, testNamespace)
+	}
+	nodesOf := func(drones []experimentsv1.Drone) []string {
+		var nodes []string
+		for _, d := range drones {
+			nodes = append(nodes, podNode(getPod(t, r, d.Name)))
+		}
+		sort.Strings(nodes)
+		return nodes
+	}
+
+	if nodes := nodesOf(reconcileAll()); !reflect.DeepEqual(nodes, []string{"node-1", "node-2"}) {
+		t.Fatalf("drones on %v, want one on each node", nodes)
+	}
+
+	node := droneNode("node-3")
+	if err := r.Create(context.Background(), node); err != nil {
+		t.Fatal(err)
+	}
+	want := []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "everywhere"}}}
+	if requests := r.nodeDependentSwarms(handler.MapObject{Meta: node, Object: node}); !reflect.DeepEqual(requests, want) {
+		t.Errorf("node maps to %v, want %v", requests, want)
+	}
+	if nodes := nodesOf(reconcileAll()); !reflect.DeepEqual(nodes, []string{"node-1", "node-2", "node-3"}) {
+		t.Errorf("drones on %v, want one on the new node too", nodes)
+	}
+
+	if err := r.Delete(context.Background(), droneNode("node-1")); err != nil {
+		t.Fatal(err)
+	}
+	if drones := reconcileAll(); len(drones) != 2 {
+		t.Errorf("got %d drones, want 2 once a node left", len(drones))
+	}
+}
+
+func TestReconcileSwarmTopologySpreadConstraints(t *testing.T) {
+	tests := []struct {
+		name     string
+		spreadBy string
+		maxSkew  int32
+		want     []core.TopologySpreadConstraint
+	}{
+		{name: "not spread"},
+		{name: "spread without skew", spreadBy: zoneLabel},
+		{name: "spread by zone", spreadBy: zoneLabel, maxSkew: 2, want: []core.TopologySpreadConstraint{{
+			MaxSkew:           2,
+			TopologyKey:       zoneLabel,
+			WhenUnsatisfiable: core.DoNotSchedule,
+			LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{experimentsv1.SwarmNameLabel: "spread"}},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			swarm := newSwarm("spread", 1)
+			swarm.Spec.SpreadBy, swarm.Spec.MaxSkew = tt.spreadBy, tt.maxSkew
+			node := droneNode("node-1")
+			node.Labels[zoneLabel] = "zone-a"
+			r, clock := newSwarmReconciler(swarm, node)
+			dr := droneReconcilerOn(r.Client, clock)
+
+			reconcileSwarm(t, r, "spread")
+			drones := listDrones(t, r, testNamespace)
+			if len(drones) != 1 {
+				t.Fatalf("got %d drones, want 1", len(drones))
+			}
+			reconcileDrone(t, dr, drones[0].Name)
+			if got := getPod(t, r, drones[0].Name).Spec.TopologySpreadConstraints; !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("topology spread constraints = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileSwarmScaleDownDeletesPods(t *testing.T) {
+	swarm := newSwarm("fleet", 2)
+	swarm.Spec.Ordinal = true
+	r, clock := newSwarmReconciler(swarm, droneNode("node-1"), droneNode("node-2"))
+	r.Client = &finalizingClient{Client: r.Client, clock: clock}
+	dr := droneReconcilerOn(r.Client, clock)
+
+	reconcileSwarm(t, r, "fleet")
+	for _, name := range []string{"fleet-0", "fleet-1"} {
+		reconcileDrone(t, dr, name)
+		getPod(t, r, name)
+	}
+
+	swarm = getSwarm(t, r, "fleet")
+	swarm.Spec.HowMany = 1
+	updateObject(t, r, swarm)
+	reconcileSwarm(t, r, "fleet")
+	// held back by the finalizer of the drone controller
+	if drone := getDrone(t, r, "fleet-1"); drone.DeletionTimestamp == nil {
+		t.Fatal("surplus drone isn't being deleted")
+	}
+
+	reconcileDrone(t, dr, "fleet-1")
+	key := client.ObjectKey{Namespace: testNamespace, Name: "fleet-1"}
+	if err := r.Get(context.Background(), key, &experimentsv1.Drone{}); !apierrors.IsNotFound(err) {
+		t.Errorf("drone lookup = %v, want it gone", err)
+	}
+	if err := r.Get(context.Background(), key, &core.Pod{}); !apierrors.IsNotFound(err) {
+		t.Errorf("pod lookup = %v, want it gone without waiting for garbage collection", err)
+	}
+	getPod(t, r, "fleet-0")
+}
+
+func TestSplitUnmanaged(t *testing.T) {
+	drone := func(name, unmanaged string) experimentsv1.Drone {
+		d := newDrone(name)
+		if unmanaged != "" {
+			d.Annotations = map[string]string{experimentsv1.UnmanagedAnnotation: unmanaged}
+		}
+		return *d
+	}
+	managed, unmanaged := splitUnmanaged([]experimentsv1.Drone{
+		drone("plain", ""), drone("opted-out", "true"), drone("opted-in", "false"), drone("typo", "yes"),
+	})
+	if got, want := droneNames(managed), []string{"plain", "opted-in", "typo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("managed = %v, want %v", got, want)
+	}
+	if got, want := droneNames(unmanaged), []string{"opted-out"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unmanaged = %v, want %v", got, want)
+	}
+}
+
+func TestReconcileSwarmUnmanagedDrones(t *testing.T) {
+	swarm := newSwarm("fleet", 2)
+	swarm.Spec.Ordinal = true
+	kept := newDrone("fleet-0")
+	kept.Labels = map[string]string{experimentsv1.SwarmNameLabel: "fleet"}
+	kept.Annotations = map[string]string{experimentsv1.UnmanagedAnnotation: "true"}
+	kept.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(swarm, experimentsv1.GroupVersion.WithKind("Swarm"))}
+	r, _ := newSwarmReconciler(swarm, kept)
+
+	reconcileSwarm(t, r, "fleet")
+	// the unmanaged drone doesn't count, but still holds on to its name
+	if got, want := droneNames(listDrones(t, r, testNamespace)), []string{"fleet-0", "fleet-1", "fleet-2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("drones = %v, want %v", got, want)
+	}
+
+	swarm = getSwarm(t, r, "fleet")
+	swarm.Spec.HowMany = 0
+	updateObject(t, r, swarm)
+	reconcileSwarm(t, r, "fleet")
+	if got, want := droneNames(listDrones(t, r, testNamespace)), []string{"fleet-0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("drones after scaling to zero = %v, want %v", got, want)
+	}
+}
+
+func TestReconcileSwarmLastScale(t *testing.T) {
+	r, clock := newSwarmReconciler(newSwarm("fleet", 2))
+	expectScale := func(action experimentsv1.ScaleAction, at time.Time) {
+		t.Helper()
+		status := getSwarm(t, r, "fleet").Status
+		if status.LastScaleAction != action || !status.LastScaleTime.Time.Equal(at) {
+			t.Errorf("last scale = %s at %v, want %s at %v", status.LastScaleAction, status.LastScaleTime, action, at)
+		}
+	}
+
+	scaledUp := clock.Now()
+	reconcileSwarm(t, r, "fleet")
+	expectScale(experimentsv1.ScaleUp, scaledUp)
+
+	// nothing to do, nothing to record
+	clock.Step(time.Minute)
+	reconcileSwarm(t, r, "fleet")
+	expectScale(experimentsv1.ScaleUp, scaledUp)
+
+	clock.Step(time.Minute)
+	scaledDown := clock.Now()
+	swarm := getSwarm(t, r, "fleet")
+	swarm.Spec.HowMany = 1
+	updateObject(t, r, swarm)
+	reconcileSwarm(t, r, "fleet")
+	expectScale(experimentsv1.ScaleDown, scaledDown)
+
+	clock.Step(time.Minute)
+	reconcileSwarm(t, r, "fleet")
+	expectScale(experimentsv1.ScaleDown, scaledDown)
+}
+
+func TestReconcileSwarmMinReadyNodes(t *testing.T) {
+	swarm := newSwarm("careful", 3)
+	swarm.Spec.MinReadyNodes = 2
+	degraded := droneNode("node-2")
+	degraded.Status.Conditions[0].Status = core.ConditionFalse
+	r, _ := newSwarmReconciler(swarm, newSwarm("reckless", 0), droneNode("node-1"), degraded)
+
+	reconcileSwarm(t, r, "careful")
+	if drones := listDrones(t, r, testNamespace); len(drones) != 0 {
+		t.Fatalf("drones = %v, want none while a single node is ready", droneNames(drones))
+	}
+	if swarm := getSwarm(t, r, "careful"); !swarmConditionTrue(&swarm.Status, experimentsv1.SwarmNodesNotReady) {
+		t.Errorf("conditions = %v, want NodesNotReady", swarm.Status.Conditions)
+	}
+
+	// the node coming back brings the swarm back, and only that swarm
+	degraded.Status.Conditions[0].Status = core.ConditionTrue
+	updateObject(t, r, degraded)
+	want := []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: testNamespace, Name: "careful"}}}
+	if requests := r.nodeDependentSwarms(handler.MapObject{Meta: degraded, Object: degraded}); !reflect.DeepEqual(requests, want) {
+		t.Errorf("node dependent swarms = %v, want %v", requests, want)
+	}
+	reconcileSwarm(t, r, "careful")
+	if n := len(listDrones(t, r, testNamespace)); n != 3 {
+		t.Errorf("got %d drones, want 3 once enough nodes are ready", n)
+	}
+	if swarm := getSwarm(t, r, "careful"); swarmConditionTrue(&swarm.Status, experimentsv1.SwarmNodesNotReady) {
+		t.Errorf("conditions = %v, want NodesNotReady cleared", swarm.Status.Conditions)
+	}
+}
+
+func TestReconcileSwarmCordoned(t *testing.T) {
+	swarm := newSwarm("fleet", 2)
+	swarm.Spec.Ordinal = true
+	swarm.Spec.FailurePolicy = experimentsv1.FailurePolicyReplace
+	r, _ := newSwarmReconciler(swarm, droneNode("node-1"))
+	reconcileSwarm(t, r, "fleet")
+
+	swarm = getSwarm(t, r, "fleet")
+	swarm.Spec.Cordoned = true
+	swarm.Spec.HowMany = 4
+	swarm.Annotations = map[string]string{experimentsv1.RestartedAtAnnotation: "2020-01-01T00:00:00Z"}
+	updateObject(t, r, swarm)
+	failed := getDrone(t, r, "fleet-0")
+	failed.Status.Phase = experimentsv1.DroneFailed
+	updateObject(t, r, failed)
+	reconcileSwarm(t, r, "fleet")
+	// neither grown, nor failed or outdated drones replaced
+	drones := listDrones(t, r, testNamespace)
+	if got, want := droneNames(drones), []string{"fleet-0", "fleet-1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("drones = %v, want %v kept while cordoned", got, want)
+	}
+	for _, d := range drones {
+		if d.DeletionTimestamp != nil {
+			t.Errorf("drone %s is being deleted while cordoned", d.Name)
+		}
+	}
+	if swarm := getSwarm(t, r, "fleet"); !swarmConditionTrue(&swarm.Status, experimentsv1.SwarmCordoned) {
+		t.Errorf("conditions = %v, want Cordoned", swarm.Status.Conditions)
+	}
+
+	swarm = getSwarm(t, r, "fleet")
+	swarm.Spec.Cordoned = false
+	updateObject(t, r, swarm)
+	reconcileSwarm(t, r, "fleet")
+	reconcileSwarm(t, r, "fleet")
+	if n := len(listDrones(t, r, testNamespace)); n != 4 {
+		t.Errorf("got %d drones, want 4 once uncordoned", n)
+	}
+	if swarm := getSwarm(t, r, "fleet"); swarmConditionTrue(&swarm.Status, experimentsv1.SwarmCordoned) {
+		t.Errorf("conditions = %v, want Cordoned cleared", swarm.Status.Conditions)
+	}
+}
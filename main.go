@@ -17,10 +17,13 @@ package main
 
 import (
 	"flag"
+	"io/ioutil"
 	"os"
+	"time"
 
 	experimentsv1 "github.com/danacr/drone/api/v1"
 	"github.com/danacr/drone/controllers"
+	"golang.org/x/time/rate"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
@@ -43,44 +46,219 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validateManifests(os.Args[2:]))
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
+	var syncPeriod time.Duration
+	var enableWebhooks bool
+	var maxInFlight int
+	var nonControllerOwner bool
+	var reconcileTimeout time.Duration
+	var imageRewrites string
+	var deferToScheduler bool
+	var taintDroneNodes bool
+	var meshAnnotations string
+	var fleetReportNamespace string
+	var fleetReportInterval time.Duration
+	var createQPS float64
+	var createBurst int
+	var enableDroneController bool
+	var enableSwarmController bool
+	var requiredSwarmLabel string
+	var imageFallbackAfter time.Duration
+	var droneTemplatesFile string
+	var eventInterval time.Duration
+	var finalizerPrefix string
+	var retryBaseDelay time.Duration
+	var retryMaxDelay time.Duration
+	var retryQPS float64
+	var retryBurst int
+	var defaultDroneImage string
+	var largeSwarmThreshold int
+	var largeSwarmNamespaceLabel string
+	var maxStartingPerNode int
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&syncPeriod, "sync-period", 10*time.Hour,
+		"How often each drone and swarm is reconciled even when nothing changed, plus a jitter of up to 10% drawn per object. "+
+			"Shorter periods heal drift sooner, but every resync reads the object's pods or drones, so they add load on the API server.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"Enable the admission webhooks. Requires the webhook serving certificates to be mounted.")
+	flag.IntVar(&maxInFlight, "max-in-flight-drones", 0,
+		"How many drones of a swarm may be starting up at the same time. 0 means no limit.")
+	flag.BoolVar(&nonControllerOwner, "non-controller-pod-owner", false,
+		"Make Drones plain owners of their pods instead of their controllers, so other controllers can co-own them.")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", 30*time.Second,
+		"How long a single reconcile may take before it is given up and requeued. 0 means no timeout.")
+	flag.StringVar(&imageRewrites, "image-rewrite", "",
+		"Comma separated prefix=replacement pairs applied to drone images, e.g. to pull them from a mirror.")
+	flag.BoolVar(&deferToScheduler, "defer-to-scheduler", false,
+		"Let the scheduler of Drones naming one pick their node instead of pinning their pods to a free drone node.")
+	flag.BoolVar(&taintDroneNodes, "taint-drone-nodes", false,
+		"Taint drone nodes so only drones get scheduled onto them, and let drone pods tolerate the taint.")
+	flag.StringVar(&meshAnnotations, "mesh-annotations", controllers.DefaultMeshAnnotations,
+		"Comma separated key=value annotations put on the pods of drones with mesh injection.")
+	flag.StringVar(&fleetReportNamespace, "fleet-report-namespace", "",
+		"Namespace to write the drone-fleet-report ConfigMap summarizing all swarms to. Empty disables the report.")
+	flag.DurationVar(&fleetReportInterval, "fleet-report-interval", time.Minute,
+		"How often the fleet report is refreshed.")
+	flag.Float64Var(&createQPS, "drone-create-qps", 0,
+		"How many drones per second all swarms may create together. 0 means no limit.")
+	flag.IntVar(&createBurst, "drone-create-burst", 10,
+		"How many drones may be created in a burst on top of --drone-create-qps.")
+	flag.BoolVar(&enableDroneController, "enable-drone-controller", true,
+		"Run the Drone controller. Disable it when drone pods are managed elsewhere.")
+	flag.BoolVar(&enableSwarmController, "enable-swarm-controller", true,
+		"Run the Swarm controller.")
+	flag.StringVar(&requiredSwarmLabel, "required-swarm-label", "",
+		"A label key every Swarm must carry, enforced by the Swarm webhook. Empty requires none.")
+	flag.DurationVar(&imageFallbackAfter, "image-fallback-after", 2*time.Minute,
+		"How long a drone pod may fail to pull its image before the Drone's fallback image is used.")
+	flag.StringVar(&droneTemplatesFile, "drone-templates", "",
+		"Path to a YAML file of named drone specs swarms can default their template from with the "+
+			experimentsv1.DroneTemplateAnnotation+" annotation.")
+	flag.DurationVar(&eventInterval, "event-interval", 5*time.Minute,
+		"How often the same event may be recorded on an object, so stuck drones don't flood the API server. 0 means always.")
+	flag.StringVar(&finalizerPrefix, "finalizer-prefix", controllers.DefaultFinalizerPrefix,
+		"Domain the finalizers put on drones and swarms live under. Objects still carrying finalizers under a previous prefix must have them removed by hand.")
+	flag.DurationVar(&retryBaseDelay, "retry-base-delay", 5*time.Millisecond,
+		"How long the controllers wait before retrying a failed reconcile the first time. The wait doubles on every further failure.")
+	flag.DurationVar(&retryMaxDelay, "retry-max-delay", 1000*time.Second,
+		"The longest the controllers wait before retrying a failed reconcile.")
+	flag.Float64Var(&retryQPS, "retry-qps", 10,
+		"How many failed reconciles per second each controller retries at most.")
+	flag.IntVar(&retryBurst, "retry-burst", 100,
+		"How many failed reconciles each controller may retry in a burst on top of --retry-qps.")
+	flag.StringVar(&defaultDroneImage, "default-drone-image", controllers.DefaultDroneImage,
+		"The image drones run when their Drone doesn't name one or names the CRD's default, "+controllers.DefaultDroneImage+".")
+	flag.IntVar(&largeSwarmThreshold, "large-swarm-threshold", 0,
+		"The number of drones above which a Swarm needs the "+experimentsv1.LargeSwarmApprovedAnnotation+
+			" annotation, or a namespace labelled with --large-swarm-namespace-label, enforced by the Swarm webhook. 0 means no limit.")
+	flag.StringVar(&largeSwarmNamespaceLabel, "large-swarm-namespace-label", "",
+		"A label key that, set to \"true\" on a namespace, lets its swarms exceed --large-swarm-threshold without approval.")
+	flag.IntVar(&maxStartingPerNode, "max-starting-drones-per-node", 0,
+		"How many drone pods a single node may be starting at the same time. 0 means no limit.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(func(o *zap.Options) {
 		o.Development = true
 	}))
 
+	rewrites, err := controllers.ParseImageRewrites(imageRewrites)
+	if err != nil {
+		setupLog.Error(err, "unable to parse image rewrites")
+		os.Exit(1)
+	}
+
+	meshes, err := controllers.ParseMeshAnnotations(meshAnnotations)
+	if err != nil {
+		setupLog.Error(err, "unable to parse mesh annotations")
+		os.Exit(1)
+	}
+
+	if droneTemplatesFile != "" {
+		data, err := ioutil.ReadFile(droneTemplatesFile)
+		if err == nil {
+			experimentsv1.DroneTemplates, err = experimentsv1.ParseDroneTemplates(data)
+		}
+		if err != nil {
+			setupLog.Error(err, "unable to load drone templates")
+			os.Exit(1)
+		}
+	}
+
+	noResync := time.Duration(0)
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: metricsAddr,
 		LeaderElection:     enableLeaderElection,
-		Port:               9443,
+		// the reconcilers requeue each object on their own, jittered, rather
+		// than have the informers resync all of them at once
+		SyncPeriod: &noResync,
+		Port:       9443,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	if err = (&controllers.DroneReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Drone"),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	if err = setupController(mgr, enableDroneController, &controllers.DroneReconciler{
+		Client:             mgr.GetClient(),
+		Log:                ctrl.Log.WithName("controllers").WithName("Drone"),
+		Scheme:             mgr.GetScheme(),
+		Recorder:           controllers.ThrottleEvents(mgr.GetEventRecorderFor("drone-controller"), eventInterval),
+		NonControllerOwner: nonControllerOwner,
+		Timeout:            reconcileTimeout,
+		SyncPeriod:         syncPeriod,
+		ImageRewrites:      rewrites,
+		DeferToScheduler:   deferToScheduler,
+		TolerateNodeTaint:  taintDroneNodes,
+		MeshAnnotations:    meshes,
+		ImageFallbackAfter: imageFallbackAfter,
+		FinalizerPrefix:    finalizerPrefix,
+		RateLimiter:        controllers.NewRateLimiter(retryBaseDelay, retryMaxDelay, retryQPS, retryBurst),
+		DefaultImage:       defaultDroneImage,
+		MaxStartingPerNode: int32(maxStartingPerNode),
+	}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Drone")
 		os.Exit(1)
 	}
-	if err = (&controllers.SwarmReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Swarm"),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	var createLimiter *rate.Limiter
+	if createQPS > 0 {
+		createLimiter = rate.NewLimiter(rate.Limit(createQPS), createBurst)
+	}
+	if err = setupController(mgr, enableSwarmController, &controllers.SwarmReconciler{
+		Client:          mgr.GetClient(),
+		Log:             ctrl.Log.WithName("controllers").WithName("Swarm"),
+		Scheme:          mgr.GetScheme(),
+		Recorder:        controllers.ThrottleEvents(mgr.GetEventRecorderFor("swarm-controller"), eventInterval),
+		MaxInFlight:     int32(maxInFlight),
+		Timeout:         reconcileTimeout,
+		SyncPeriod:      syncPeriod,
+		CreateLimiter:   createLimiter,
+		FinalizerPrefix: finalizerPrefix,
+		RateLimiter:     controllers.NewRateLimiter(retryBaseDelay, retryMaxDelay, retryQPS, retryBurst),
+	}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Swarm")
 		os.Exit(1)
 	}
+	if taintDroneNodes {
+		if err = (&controllers.NodeReconciler{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName("controllers").WithName("Node"),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Node")
+			os.Exit(1)
+		}
+	}
+	if fleetReportNamespace != "" {
+		if err = mgr.Add(&controllers.FleetReporter{
+			Client:    mgr.GetClient(),
+			Log:       ctrl.Log.WithName("fleet-report"),
+			Namespace: fleetReportNamespace,
+			Interval:  fleetReportInterval,
+		}); err != nil {
+			setupLog.Error(err, "unable to add fleet report")
+			os.Exit(1)
+		}
+	}
+	if enableWebhooks {
+		if err = (&experimentsv1.Drone{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Drone")
+			os.Exit(1)
+		}
+		experimentsv1.RequiredSwarmLabel = requiredSwarmLabel
+		experimentsv1.LargeSwarmThreshold = int32(largeSwarmThreshold)
+		experimentsv1.LargeSwarmNamespaceLabel = largeSwarmNamespaceLabel
+		if err = (&experimentsv1.Swarm{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Swarm")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -89,3 +267,12 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// setupController registers the reconciler of a controller with the manager
+// unless the controller is disabled, in which case it watches nothing.
+func setupController(mgr ctrl.Manager, enabled bool, r interface{ SetupWithManager(ctrl.Manager) error }) error {
+	if !enabled {
+		return nil
+	}
+	return r.SetupWithManager(mgr)
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/danacr/drone/controllers"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// watchRecorder is a manager recording the kinds the controllers set up with
+// it watch.
+type watchRecorder struct {
+	manager.Manager
+	kinds []string
+}
+
+// Add hands the controllers the SetFields of the recorder, through which they
+// set up their watches.
+func (m *watchRecorder) Add(r manager.Runnable) error {
+	if err := m.Manager.Add(r); err != nil {
+		return err
+	}
+	_, err := inject.InjectorInto(m.SetFields, r)
+	return err
+}
+
+func (m *watchRecorder) SetFields(i interface{}) error {
+	if src, ok := i.(*source.Kind); ok {
+		m.kinds = append(m.kinds, fmt.Sprintf("%T", src.Type))
+	}
+	return m.Manager.SetFields(i)
+}
+
+// newWatchRecorder returns a manager that never talks to an API server.
+func newWatchRecorder(t *testing.T) *watchRecorder {
+	t.Helper()
+	mgr, err := ctrl.NewManager(&rest.Config{Host: "http://127.0.0.1:1"}, ctrl.Options{
+		Scheme:             scheme,
+		MetricsBindAddress: "0",
+		MapperProvider: func(*rest.Config) (meta.RESTMapper, error) {
+			mapper := meta.NewDefaultRESTMapper(nil)
+			for gvk := range scheme.AllKnownTypes() {
+				mapper.Add(gvk, meta.RESTScopeNamespace)
+			}
+			return mapper, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &watchRecorder{Manager: mgr}
+}
+
+func TestSetupController(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		r       interface{ SetupWithManager(ctrl.Manager) error }
+	}{
+		{name: "drone disabled", r: &controllers.DroneReconciler{Log: ctrl.Log}},
+		{name: "swarm disabled", r: &controllers.SwarmReconciler{Log: ctrl.Log}},
+		{name: "drone enabled", enabled: true, r: &controllers.DroneReconciler{Log: ctrl.Log}},
+		{name: "swarm enabled", enabled: true, r: &controllers.SwarmReconciler{Log: ctrl.Log}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr := newWatchRecorder(t)
+			if err := setupController(mgr, tt.enabled, tt.r); err != nil {
+				t.Fatal(err)
+			}
+			if watching := len(mgr.kinds) > 0; watching != tt.enabled {
+				t.Errorf("watches = %v, want some only if enabled", mgr.kinds)
+			}
+		})
+	}
+}
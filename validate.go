@@ -0,0 +1,143 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// validateManifests runs the Drones and Swarms in the given YAML files through
+// the checks of the admission webhooks, e.g. in CI before applying them, and
+// returns the exit code: 1 if any is invalid. The flags the webhooks depend on
+// come before the files.
+func validateManifests(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	droneTemplatesFile := fs.String("drone-templates", "",
+		"Path to a YAML file of the named drone specs swarms may refer to, as given to the controller.")
+	requiredSwarmLabel := fs.String("required-swarm-label", "",
+		"A label key every Swarm must carry, as given to the controller.")
+	largeSwarmThreshold := fs.Int("large-swarm-threshold", 0,
+		"The number of drones above which a Swarm needs approval, as given to the controller. Namespace labels aren't looked at.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: manager validate [flags] FILE...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *droneTemplatesFile != "" {
+		data, err := ioutil.ReadFile(*droneTemplatesFile)
+		if err == nil {
+			experimentsv1.DroneTemplates, err = experimentsv1.ParseDroneTemplates(data)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	experimentsv1.RequiredSwarmLabel = *requiredSwarmLabel
+	experimentsv1.LargeSwarmThreshold = int32(*largeSwarmThreshold)
+
+	code := 0
+	for _, path := range paths {
+		if err := validateFile(path); err != nil {
+			code = 1
+		}
+	}
+	return code
+}
+
+// validateFile validates the documents of a YAML file, printing what is wrong
+// with them. It returns an error if any is invalid or the file can't be read.
+func validateFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return err
+	}
+	defer f.Close()
+	var invalid error
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(f))
+	for i := 1; ; i++ {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return invalid
+		}
+		if err != nil {
+			// the reader can't get past it, e.g. for a directory
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return err
+		}
+		if err := validateManifest(doc); err != nil {
+			fmt.Fprintf(os.Stderr, "%s (document %d): %v\n", path, i, err)
+			invalid = err
+		}
+	}
+}
+
+// validateManifest validates a single YAML document, skipping empty ones and
+// kinds other than Drones and Swarms.
+func validateManifest(doc []byte) error {
+	if len(bytes.TrimSpace(doc)) == 0 {
+		return nil
+	}
+	meta := metav1.TypeMeta{}
+	if err := yaml.Unmarshal(doc, &meta); err != nil {
+		return err
+	}
+	if meta.GroupVersionKind().GroupVersion() != experimentsv1.GroupVersion {
+		return nil
+	}
+	switch meta.Kind {
+	case "Drone":
+		drone := experimentsv1.Drone{}
+		if err := yaml.UnmarshalStrict(doc, &drone); err != nil {
+			return err
+		}
+		return drone.ValidateCreate()
+	case "Swarm":
+		swarm := experimentsv1.Swarm{}
+		if err := yaml.UnmarshalStrict(doc, &swarm); err != nil {
+			return err
+		}
+		raw, err := yaml.YAMLToJSON(doc)
+		if err != nil {
+			return err
+		}
+		if err := swarm.DefaultFromJSON(raw); err != nil {
+			return err
+		}
+		return swarm.ValidateCreate()
+	}
+	return nil
+}
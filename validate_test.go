@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	experimentsv1 "github.com/danacr/drone/api/v1"
+)
+
+const validDrone = `
+apiVersion: experiments.mad.md/v1
+kind: Drone
+metadata:
+  name: drone-sample
+spec:
+  image: danacr/drone-pod:v1
+`
+
+const validSwarm = `
+apiVersion: experiments.mad.md/v1
+kind: Swarm
+metadata:
+  name: swarm-sample
+  labels:
+    team: blue
+spec:
+  howmany: 3
+`
+
+func TestValidateManifests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { experimentsv1.RequiredSwarmLabel, experimentsv1.LargeSwarmThreshold = "", 0 }()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	valid := write("valid.yaml", validDrone+"---\n"+validSwarm+`---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: not-ours
+`)
+	badImage := write("bad-image.yaml", validSwarm+"---\n"+`
+apiVersion: experiments.mad.md/v1
+kind: Drone
+metadata:
+  name: drone-sample
+spec:
+  image: danacr/drone-pod:
+`)
+	misspelled := write("misspelled.yaml", `
+apiVersion: experiments.mad.md/v1
+kind: Drone
+metadata:
+  name: drone-sample
+spec:
+  imag: danacr/drone-pod:v1
+`)
+	conflicting := write("conflicting.yaml", `
+apiVersion: experiments.mad.md/v1
+kind: Swarm
+metadata:
+  name: swarm-sample
+spec:
+  fillNodes: true
+  howmany: 3
+`)
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{name: "valid", args: []string{valid}, want: 0},
+		{name: "invalid image", args: []string{badImage}, want: 1},
+		{name: "misspelled field", args: []string{misspelled}, want: 1},
+		{name: "conflicting fields", args: []string{conflicting}, want: 1},
+		{name: "valid and invalid", args: []string{valid, badImage}, want: 1},
+		{name: "required label present", args: []string{"-required-swarm-label", "team", valid}, want: 0},
+		{name: "required label missing", args: []string{"-required-swarm-label", "owner", valid}, want: 1},
+		{name: "directory", args: []string{dir}, want: 1},
+		{name: "missing file", args: []string{filepath.Join(dir, "missing.yaml")}, want: 1},
+		{name: "no files", want: 2},
+		{name: "unknown flag", args: []string{"-strict", valid}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateManifests(tt.args); got != tt.want {
+				t.Errorf("validateManifests(%q) = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+}